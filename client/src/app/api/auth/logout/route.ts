@@ -5,7 +5,7 @@ import { cookies } from "next/headers";
 export async function POST() {
   try {
     try {
-      await fetch(`${process.env.SERVER_URI}/auth/logout`, {
+      await fetch(`${process.env.SERVER_URI}/api/auth/logout`, {
         method: "POST",
         credentials: "include",
         headers: {
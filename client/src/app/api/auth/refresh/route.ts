@@ -74,6 +74,18 @@ export async function POST(request: NextRequest) {
       });
     }
 
+    // Refresh tokens are rotated, so the old one no longer works
+    if (data.refresh_token) {
+      successResponse.cookies.set({
+        name: "refresh_token",
+        value: data.refresh_token,
+        httpOnly: true,
+        secure: true,
+        maxAge: 3600 * 24 * 30,
+        path: "/",
+      });
+    }
+
     return successResponse;
   } catch (error) {
     console.error("Token refresh failed:", error);
@@ -2,19 +2,25 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
-	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 	"github.com/mjxoro/sent/server/internal/auth"
 	"github.com/mjxoro/sent/server/internal/config"
+	"github.com/mjxoro/sent/server/internal/db/memory"
 	"github.com/mjxoro/sent/server/internal/db/postgres"
 	"github.com/mjxoro/sent/server/internal/db/redis"
-	"github.com/mjxoro/sent/server/internal/handler"
+	"github.com/mjxoro/sent/server/internal/ratelimit"
+	"github.com/mjxoro/sent/server/internal/repository"
+	"github.com/mjxoro/sent/server/internal/server"
 	"github.com/mjxoro/sent/server/internal/service"
 	"github.com/mjxoro/sent/server/pkg/websocket"
 )
@@ -29,47 +35,83 @@ func main() {
 	// Load application configuration
 	cfg := config.Load()
 
-	// Initialize database connections
-	pgDB, err := postgres.NewDB(cfg)
-	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
-	}
-	defer pgDB.Close()
+	// Initialize storage
+	var (
+		repos      *repository.Set
+		transactor repository.Transactor
+		cache      repository.Cache
+		pubsub     repository.PubSub
+		buckets    repository.RateLimiter
+	)
+
+	switch cfg.Storage.Driver {
+	case "memory":
+		log.Println("Using in-memory storage; data will not be persisted")
+		store := memory.NewStore()
+		repos = store.Repositories()
+		transactor = store
+		cache = memory.NewCache()
+		pubsub = memory.NewPubSub()
+		buckets = memory.NewRateLimiter()
+
+	case "postgres":
+		pgDB, err := postgres.NewDB(cfg)
+		if err != nil {
+			log.Fatalf("Failed to connect to database: %v", err)
+		}
+		defer func() {
+			if err := pgDB.Close(); err != nil {
+				log.Printf("Failed to close database: %v", err)
+			}
+		}()
 
-	redisClient, err := redis.NewClient(cfg)
-	if err != nil {
-		log.Fatalf("Failed to connect to Redis: %v", err)
-	}
-	defer redisClient.Close()
+		redisClient, err := redis.NewClient(cfg)
+		if err != nil {
+			log.Fatalf("Failed to connect to Redis: %v", err)
+		}
+		defer func() {
+			if err := redisClient.Close(); err != nil {
+				log.Printf("Failed to close Redis: %v", err)
+			}
+		}()
 
-	// Initialize Redis cache
-	redisCache := redis.NewCache(redisClient)
+		repos = postgres.NewRepositories(pgDB)
+		transactor = pgDB
+		cache = redis.NewCache(redisClient)
+		pubsub = redis.NewPubSub(redisClient)
+		buckets = redis.NewRateLimiter(redisClient)
 
-	// Initialize repositories
-	pgUser := postgres.NewUser(pgDB)
-	pgRoom := postgres.NewRoom(pgDB)
-	pgMessage := postgres.NewMessage(pgDB)
-	pgRefreshToken := postgres.NewRefreshToken(pgDB)
-	pgFriendship := postgres.NewFriendship(pgDB)
+	default:
+		log.Fatalf("Unknown storage driver: %s", cfg.Storage.Driver)
+	}
 
 	// Initialize services
-	userService := service.NewUserService(pgUser)
-	chatService := service.NewChatService(pgRoom, pgMessage, redisClient)
-	refreshTokenService := service.NewRefreshTokenService(pgRefreshToken)
-	friendshipService := service.NewFriendshipService(pgFriendship, pgUser, redisCache)
+	userService := service.NewUserService(repos.Users)
+	chatService := service.NewChatService(transactor, repos.Rooms, repos.Messages, repos.Users, pubsub)
+	refreshTokenService := service.NewRefreshTokenService(transactor, repos.RefreshTokens)
+	friendshipService := service.NewFriendshipService(transactor, repos.Friendships, repos.Users, cache)
+	friendListService := service.NewFriendListService(repos.FriendLists, repos.Friendships)
 
 	// Initialize auth services
 	oauthService := auth.NewOAuthService(cfg)
 	jwtService := auth.NewJWTService()
 
+	origins, err := auth.NewOriginAllowlist(cfg.Server.AllowedOrigins, cfg.Server.DevMode)
+	if err != nil {
+		log.Fatalf("Invalid ALLOWED_ORIGINS: %v", err)
+	}
+
 	// Initialize WebSocket hub
-	hub := websocket.NewHub()
+	hub := websocket.NewHub(cfg.WebSocket.HubShards, websocket.QueuePolicy{
+		Size:            cfg.WebSocket.SendQueueSize,
+		OverflowTimeout: cfg.WebSocket.SendQueueOverflowTimeout,
+	})
 	go hub.Run()
 
-	// Initialize handlers
-	authHandler := handler.NewAuthHandler(oauthService, jwtService, userService, refreshTokenService)
-	wsHandler := handler.NewWSHandler(hub, chatService, userService, jwtService)
-	friendshipHandler := handler.NewFriendshipHandler(friendshipService)
+	// Delete expired refresh tokens in the background
+	cleanupCtx, stopCleanup := context.WithCancel(context.Background())
+	defer stopCleanup()
+	go refreshTokenService.RunCleanup(cleanupCtx, time.Hour)
 
 	// Set Gin mode
 	if os.Getenv("GIN_MODE") == "release" {
@@ -77,207 +119,67 @@ func main() {
 	}
 
 	// Create router
-	r := gin.Default()
-
-	// Add CORS middleware
-	r.Use(cors.New(cors.Config{
-		AllowOrigins: []string{os.Getenv("FRONTEND_URI")}, // Your frontend URL
-		// AllowOrigins:     []string{"http://localhost:3000"}, // Your frontend URL
-		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
-		AllowCredentials: true,
-		MaxAge:           12 * time.Hour,
-	}))
-
-	// Health check endpoint
-	r.GET("/health", func(c *gin.Context) {
-		c.JSON(200, gin.H{
-			"status": "ok",
-		})
+	r := server.NewRouter(server.Dependencies{
+		UserService:         userService,
+		ChatService:         chatService,
+		RefreshTokenService: refreshTokenService,
+		FriendshipService:   friendshipService,
+		FriendListService:   friendListService,
+		OAuthService:        oauthService,
+		JWTService:          jwtService,
+		WSTicketService:     service.NewWSTicketService(cache),
+		TokenRevocations:    service.NewTokenRevocationService(cache, jwtService.TokenDuration()),
+		Hub:                 hub,
+		RateLimiter:         ratelimit.New(buckets, cfg.RateLimit),
+		Origins:             origins,
+		WebSocket:           cfg.WebSocket,
 	})
 
-	// API routes
-	api := r.Group("/api")
-	{
-		// Auth routes
-		authRoutes := api.Group("/auth")
-		{
-			authRoutes.GET("/login", authHandler.Login)
-			authRoutes.GET("/callback", authHandler.Callback)
-			authRoutes.POST("/refresh_token", authHandler.RefreshToken)
-		}
-
-		// Protected routes
-		protected := api.Group("/")
-		protected.Use(auth.AuthMiddleware(jwtService))
-		{
-			// User routes
-			protected.GET("/user/profile", func(c *gin.Context) {
-				userID := c.GetString("userID")
-				user, err := userService.GetByID(userID)
-				if err != nil {
-					c.JSON(404, gin.H{"error": "user not found"})
-					return
-				}
-				c.JSON(200, user)
-			})
-
-			// Room routes
-			protected.GET("/rooms", func(c *gin.Context) {
-				userID := c.GetString("userID")
-				rooms, err := chatService.GetUserRooms(userID)
-				if err != nil {
-					c.JSON(500, gin.H{"error": "failed to get rooms"})
-					return
-				}
-				c.JSON(200, rooms)
-			})
-
-			protected.POST("/rooms", func(c *gin.Context) {
-				userID := c.GetString("userID")
-				var req struct {
-					Name        string   `json:"name" binding:"required"`
-					Description string   `json:"description"`
-					IsPrivate   bool     `json:"is_private"`
-					MemberIDs   []string `json:"member_ids"`
-				}
-				if err := c.ShouldBindJSON(&req); err != nil {
-					c.JSON(400, gin.H{"error": err.Error()})
-					return
-				}
-
-				room, err := chatService.CreateRoom(req.Name, req.Description, req.IsPrivate, userID)
-				if err != nil {
-					c.JSON(500, gin.H{"error": "failed to create room"})
-					return
-				}
-
-				// Add members to the room if specified
-				if len(req.MemberIDs) > 0 {
-					for _, memberID := range req.MemberIDs {
-						if err := pgRoom.AddMember(room.ID, memberID, "member"); err != nil {
-							log.Printf("Failed to add member %s to room: %v", memberID, err)
-						}
-					}
-				}
-
-				c.JSON(201, room)
-			})
-
-			protected.POST("/dm/:userId", func(c *gin.Context) {
-				userID := c.GetString("userID")
-				targetUserID := c.Param("userId")
-
-				room, err := chatService.CreateDirectMessageRoom(userID, targetUserID)
-				if err != nil {
-					c.JSON(500, gin.H{"error": "failed to create DM room"})
-					return
-				}
-				c.JSON(201, room)
-			})
-
-			protected.GET("/rooms/:roomId/messages", func(c *gin.Context) {
-				roomID := c.Param("roomId")
-				limit := 50
-				offset := 0
-
-				if limitParam := c.Query("limit"); limitParam != "" {
-					if _, err := fmt.Sscanf(limitParam, "%d", &limit); err != nil {
-						limit = 50
-					}
-				}
-
-				if offsetParam := c.Query("offset"); offsetParam != "" {
-					if _, err := fmt.Sscanf(offsetParam, "%d", &offset); err != nil {
-						offset = 0
-					}
-				}
-
-				messages, err := chatService.GetRoomMessages(roomID, limit, offset)
-				if err != nil {
-					c.JSON(500, gin.H{"error": "failed to get messages"})
-					return
-				}
-				c.JSON(200, messages)
-			})
-
-			protected.DELETE("/rooms/:roomId", func(c *gin.Context) {
-				userID := c.GetString("userID")
-				roomID := c.Param("roomId")
-
-				// Check if user is a member of the room
-				isMember, err := chatService.IsUserMemberOfRoom(userID, roomID)
-				if err != nil {
-					c.JSON(500, gin.H{"error": "error checking membership"})
-					return
-				}
-
-				if !isMember {
-					c.JSON(403, gin.H{"error": "access denied"})
-					return
-				}
-
-				// Delete the room
-				if err := chatService.DeleteRoom(roomID, userID); err != nil {
-					if err.Error() == "unauthorized: only the room creator can delete this room" {
-						c.JSON(403, gin.H{"error": err.Error()})
-					} else {
-						c.JSON(500, gin.H{"error": "failed to delete room"})
-					}
-					return
-				}
-
-				c.JSON(200, gin.H{"message": "room deleted successfully"})
-			})
-			// Friendship routes
-			friendRoutes := protected.Group("/friends")
-			{
-				friendRoutes.GET("", friendshipHandler.GetFriends)
-				friendRoutes.GET("/requests", friendshipHandler.GetFriendRequests)
-				friendRoutes.GET("/relationships", friendshipHandler.GetAllRelationships)
-				friendRoutes.GET("/potential", friendshipHandler.GetPotentialFriends)
-				friendRoutes.GET("/status/:userId", friendshipHandler.GetFriendshipStatus)
+	// Start server
+	srv := &http.Server{
+		Addr:    ":" + cfg.Server.Port,
+		Handler: r,
+	}
 
-				friendRoutes.POST("/requests/:userId", friendshipHandler.SendFriendRequest)
-				friendRoutes.POST("/accept/:friendshipId", friendshipHandler.AcceptFriendRequest)
-				friendRoutes.POST("/reject/:friendshipId", friendshipHandler.RejectFriendRequest)
-				friendRoutes.DELETE("/:userId", friendshipHandler.RemoveFriend)
+	// Catch interrupt and termination signals before serving, so one arriving
+	// while the server starts still shuts it down gracefully
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-				friendRoutes.POST("/block/:userId", friendshipHandler.BlockUser)
-				friendRoutes.POST("/unblock/:userId", friendshipHandler.UnblockUser)
-			}
-
-			// WebSocket endpoint - Single connection for all rooms
-			protected.GET("/ws", wsHandler.HandleConnection)
+	go func() {
+		log.Printf("Server starting on %s\n", srv.Addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Server failed: %v", err)
 		}
+	}()
 
-		// Get room details - with auth check
-		protected.GET("/rooms/:roomId", func(c *gin.Context) {
-			userID := c.GetString("userID")
-			roomID := c.Param("roomId")
+	// Wait for an interrupt or termination signal
+	<-ctx.Done()
+	stop()
 
-			// Get room details
-			room, err := chatService.GetRoomDetails(roomID)
-			if err != nil {
-				c.JSON(404, gin.H{"error": "room not found"})
-				return
-			}
+	log.Printf("Shutting down server (timeout %s)", cfg.Server.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
 
-			// Check if user is a member of the room
-			isMember, err := chatService.IsUserMemberOfRoom(userID, roomID)
-			if err != nil || !isMember {
-				c.JSON(403, gin.H{"error": "access denied"})
-				return
-			}
+	// Event streams are ordinary requests, so the hub has to start telling clients
+	// to reconnect elsewhere as soon as the server starts draining requests
+	srv.RegisterOnShutdown(func() {
+		hub.Stop(shutdownCtx)
+	})
 
-			// Return room details
-			c.JSON(200, room)
-		})
+	// Stop accepting connections and drain in-flight HTTP requests. WebSocket
+	// connections are hijacked, so the server doesn't wait for them.
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("HTTP server shutdown did not complete: %v", err)
 	}
 
-	// Start server
-	port := cfg.Server.Port
-	log.Printf("Server starting on :%s\n", port)
-	r.Run(":" + port)
+	// Wait for WebSocket clients to be told to reconnect elsewhere and for their
+	// writers to flush the going away frame and close, so the process doesn't
+	// exit underneath them
+	if err := hub.Stop(shutdownCtx); err != nil {
+		log.Printf("WebSocket hub shutdown did not complete: %v", err)
+	}
+
+	// Postgres and Redis are closed by their deferred Close calls
+	log.Println("Server stopped")
 }
@@ -8,6 +8,14 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+)
+
+// Token types, kept in the typ claim so a refresh token can't be used as an
+// access token or the other way round
+const (
+	TokenTypeAccess  = "access"
+	TokenTypeRefresh = "refresh"
 )
 
 // JWTService handles JWT operations
@@ -22,6 +30,14 @@ type TokenClaims struct {
 	Email  string `json:"email"`
 	Name   string `json:"name"`
 	Avatar string `json:"avatar"`
+
+	// TokenType is TokenTypeAccess or TokenTypeRefresh
+	TokenType string `json:"typ"`
+
+	// IssuedAtNano is when the token was issued in Unix nanoseconds, as iat is
+	// only precise to the second
+	IssuedAtNano int64 `json:"iat_ns,omitempty"`
+
 	jwt.RegisteredClaims
 }
 
@@ -44,16 +60,26 @@ func NewJWTService() *JWTService {
 
 // GenerateToken creates a new JWT token
 func (s *JWTService) GenerateToken(userID, email, name, avatar string) (string, error) {
-	// Create the claims
+	return s.GenerateTokenWithDuration(userID, email, name, avatar, s.tokenDuration)
+}
+
+// GenerateTokenWithDuration creates a new JWT token that expires after duration
+func (s *JWTService) GenerateTokenWithDuration(userID, email, name, avatar string, duration time.Duration) (string, error) {
+	// Create the claims. The random ID lets a single token be revoked, and the
+	// precise issue time lets every token issued before a moment be revoked.
+	now := time.Now()
 	claims := TokenClaims{
-		UserID: userID,
-		Email:  email,
-		Name:   name,
-		Avatar: avatar,
+		UserID:       userID,
+		Email:        email,
+		Name:         name,
+		Avatar:       avatar,
+		TokenType:    TokenTypeAccess,
+		IssuedAtNano: now.UnixNano(),
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(s.tokenDuration)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			NotBefore: jwt.NewNumericDate(time.Now()),
+			ID:        uuid.NewString(),
+			ExpiresAt: jwt.NewNumericDate(now.Add(duration)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
 		},
 	}
 
@@ -64,8 +90,18 @@ func (s *JWTService) GenerateToken(userID, email, name, avatar string) (string,
 	return token.SignedString([]byte(s.secretKey))
 }
 
-// ValidateToken validates the JWT token
+// ValidateToken validates an access token
 func (s *JWTService) ValidateToken(tokenString string) (*TokenClaims, error) {
+	return s.validateTokenType(tokenString, TokenTypeAccess)
+}
+
+// ValidateRefreshToken validates a refresh token
+func (s *JWTService) ValidateRefreshToken(tokenString string) (*TokenClaims, error) {
+	return s.validateTokenType(tokenString, TokenTypeRefresh)
+}
+
+// validateTokenType validates a JWT token and checks it is of tokenType
+func (s *JWTService) validateTokenType(tokenString, tokenType string) (*TokenClaims, error) {
 	// Parse the token
 	token, err := jwt.ParseWithClaims(
 		tokenString,
@@ -84,11 +120,39 @@ func (s *JWTService) ValidateToken(tokenString string) (*TokenClaims, error) {
 	}
 
 	// Validate the token and return the claims
-	if claims, ok := token.Claims.(*TokenClaims); ok && token.Valid {
-		return claims, nil
+	claims, ok := token.Claims.(*TokenClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+	if claims.TokenType != tokenType {
+		return nil, fmt.Errorf("expected %s token, got %q", tokenType, claims.TokenType)
+	}
+	return claims, nil
+}
+
+// ExpiresAtTime returns when the token's claims expire, or the zero time if they don't
+func (c *TokenClaims) ExpiresAtTime() time.Time {
+	if c.ExpiresAt == nil {
+		return time.Time{}
 	}
+	return c.ExpiresAt.Time
+}
+
+// IssuedAtTime returns when the token was issued, to the nanosecond if it says,
+// or the zero time if it doesn't say
+func (c *TokenClaims) IssuedAtTime() time.Time {
+	if c.IssuedAtNano != 0 {
+		return time.Unix(0, c.IssuedAtNano)
+	}
+	if c.IssuedAt == nil {
+		return time.Time{}
+	}
+	return c.IssuedAt.Time
+}
 
-	return nil, errors.New("invalid token")
+// TokenDuration returns how long access tokens last
+func (s *JWTService) TokenDuration() time.Duration {
+	return s.tokenDuration
 }
 
 // GenerateRefreshToken creates a longer-lasting refresh token
@@ -96,10 +160,13 @@ func (s *JWTService) GenerateRefreshToken(userID string) (string, error) {
 	// Set refresh token duration (30 days)
 	refreshDuration := 30 * 24 * time.Hour
 
-	// Create the claims
+	// Create the claims. The random ID keeps tokens issued in the same second
+	// distinct, as rotation issues one per refresh.
 	claims := TokenClaims{
-		UserID: userID,
+		UserID:    userID,
+		TokenType: TokenTypeRefresh,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(refreshDuration)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			NotBefore: jwt.NewNumericDate(time.Now()),
@@ -2,36 +2,27 @@
 package auth
 
 import (
+	"context"
+	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+// TokenRevocations reports whether an otherwise valid access token was revoked,
+// by logging out, before it expired
+type TokenRevocations interface {
+	IsRevoked(ctx context.Context, userID, tokenID string, issuedAt time.Time) (bool, error)
+}
+
 // AuthMiddleware creates middleware for JWT authentication
-func AuthMiddleware(jwtService *JWTService) gin.HandlerFunc {
+func AuthMiddleware(jwtService *JWTService, revocations TokenRevocations) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var tokenString string
-
-		// First check Authorization header
-		authHeader := c.GetHeader("Authorization")
-		if authHeader != "" {
-			// Check if the header has the Bearer prefix
-			headerParts := strings.Split(authHeader, " ")
-			if len(headerParts) == 2 && headerParts[0] == "Bearer" {
-				tokenString = headerParts[1]
-			}
-		}
-
-		// If no token in header, check cookie
-		if tokenString == "" {
-			cookie, err := c.Cookie("auth_token")
-			if err == nil {
-				tokenString = cookie
-			}
-		}
+		tokenString := TokenFromRequest(c)
 
-		// If still no token, return unauthorized
+		// If there is no token, return unauthorized
 		if tokenString == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
 			c.Abort()
@@ -46,10 +37,47 @@ func AuthMiddleware(jwtService *JWTService) gin.HandlerFunc {
 			return
 		}
 
+		// Logging out revokes tokens before they expire
+		revoked, err := revocations.IsRevoked(c.Request.Context(), claims.UserID, claims.ID, claims.IssuedAtTime())
+		if err != nil {
+			log.Printf("Failed to check token revocation: %v", err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "failed to check token"})
+			c.Abort()
+			return
+		}
+		if revoked {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "token revoked"})
+			c.Abort()
+			return
+		}
+
 		// Set the user ID in the context
 		c.Set("userID", claims.UserID)
 		c.Set("email", claims.Email)
 		c.Set("name", claims.Name)
+		c.Set("tokenID", claims.ID)
+		c.Set("tokenIssuedAt", claims.IssuedAtTime())
+		c.Set("tokenExpiresAt", claims.ExpiresAtTime())
 		c.Next()
 	}
 }
+
+// TokenFromRequest returns the access token from the Authorization header, or
+// from the auth_token cookie, or "" if the request has neither
+func TokenFromRequest(c *gin.Context) string {
+	// First check Authorization header
+	authHeader := c.GetHeader("Authorization")
+	if authHeader != "" {
+		// Check if the header has the Bearer prefix
+		headerParts := strings.Split(authHeader, " ")
+		if len(headerParts) == 2 && headerParts[0] == "Bearer" {
+			return headerParts[1]
+		}
+	}
+
+	// If no token in header, check cookie
+	if cookie, err := c.Cookie("auth_token"); err == nil {
+		return cookie
+	}
+	return ""
+}
@@ -79,18 +79,17 @@ func (s *OAuthService) GetLoginURL(state string) string {
 }
 
 // Exchange exchanges the authorization code for a token
-func (s *OAuthService) Exchange(code string) (*oauth2.Token, error) {
-	ctx := context.Background()
+func (s *OAuthService) Exchange(ctx context.Context, code string) (*oauth2.Token, error) {
 	return s.oauthConfig.Exchange(ctx, code)
 }
 
 // GetUserInfo fetches the user's information from the OAuth provider
-func (s *OAuthService) GetUserInfo(token *oauth2.Token) (*UserInfo, error) {
+func (s *OAuthService) GetUserInfo(ctx context.Context, token *oauth2.Token) (*UserInfo, error) {
 	if !token.Valid() {
 		return nil, errors.New("invalid token")
 	}
 
-	client := s.oauthConfig.Client(context.Background(), token)
+	client := s.oauthConfig.Client(ctx, token)
 	resp, err := client.Get(s.config.OAuth.UserInfoURL)
 	if err != nil {
 		return nil, err
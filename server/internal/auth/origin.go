@@ -0,0 +1,131 @@
+// internal/auth/origin.go
+package auth
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// OriginAllowlist decides which browser origins may make credentialed requests,
+// for both CORS and WebSocket upgrades
+type OriginAllowlist struct {
+	origins []originPattern
+
+	// dev also allows any loopback origin, for local frontends on any port
+	dev bool
+}
+
+// originPattern is an allowed origin, whose host may start with "*." to allow any subdomain
+type originPattern struct {
+	scheme string
+	host   string
+	port   string
+}
+
+// NewOriginAllowlist parses origins such as "https://app.example.com" or
+// "https://*.example.com". In dev mode any localhost origin is also allowed.
+func NewOriginAllowlist(origins []string, dev bool) (*OriginAllowlist, error) {
+	a := &OriginAllowlist{dev: dev}
+	for _, origin := range origins {
+		if origin == "*" {
+			return nil, fmt.Errorf("origin %q: credentialed requests need explicit origins", origin)
+		}
+
+		u, err := parseOrigin(origin)
+		if err != nil {
+			return nil, fmt.Errorf("origin %q: %w", origin, err)
+		}
+		a.origins = append(a.origins, originPattern{
+			scheme: u.Scheme,
+			host:   u.Hostname(),
+			port:   originPort(u),
+		})
+	}
+	return a, nil
+}
+
+// Allowed reports whether a request from origin is allowed
+func (a *OriginAllowlist) Allowed(origin string) bool {
+	u, err := parseOrigin(origin)
+	if err != nil {
+		return false
+	}
+	host, port := u.Hostname(), originPort(u)
+
+	if a.dev && isLoopback(host) {
+		return true
+	}
+
+	for _, p := range a.origins {
+		if p.scheme != u.Scheme || p.port != port {
+			continue
+		}
+		if suffix, ok := strings.CutPrefix(p.host, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if host == p.host {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckOrigin allows WebSocket upgrades from allowed origins, and from clients
+// that aren't browsers and so send no Origin. Rejections are logged.
+func (a *OriginAllowlist) CheckOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || a.Allowed(origin) {
+		return true
+	}
+	log.Printf("Rejected WebSocket upgrade from origin %q (%s)", origin, r.RemoteAddr)
+	return false
+}
+
+// AllowCORSOrigin allows cross-origin requests from allowed origins, logging rejections
+func (a *OriginAllowlist) AllowCORSOrigin(origin string) bool {
+	if a.Allowed(origin) {
+		return true
+	}
+	log.Printf("Rejected cross-origin request from origin %q", origin)
+	return false
+}
+
+// parseOrigin parses a scheme://host[:port] origin, ignoring case and a trailing slash
+func parseOrigin(origin string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSuffix(strings.ToLower(origin), "/"))
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("not an http(s) origin")
+	}
+	if u.Path != "" || u.RawQuery != "" || u.User != nil {
+		return nil, fmt.Errorf("origin must not have a path, query or user info")
+	}
+	return u, nil
+}
+
+// originPort returns an origin's port, filling in the scheme's default
+func originPort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+	if u.Scheme == "https" {
+		return "443"
+	}
+	return "80"
+}
+
+// isLoopback reports whether host is localhost or a loopback address
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
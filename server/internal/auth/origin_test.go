@@ -0,0 +1,59 @@
+package auth
+
+import "testing"
+
+func TestOriginAllowlist(t *testing.T) {
+	allowlist, err := NewOriginAllowlist([]string{"https://app.example.com", "https://*.example.org/", "http://localhost:3000"}, false)
+	if err != nil {
+		t.Fatalf("NewOriginAllowlist: %v", err)
+	}
+
+	for origin, want := range map[string]bool{
+		"https://app.example.com":      true,
+		"https://APP.example.com":      true,
+		"https://app.example.com:443":  true,
+		"http://app.example.com":       false,
+		"https://app.example.com:8443": false,
+		"https://evil.example.com":     false,
+		"https://app.example.com.evil": false,
+		"https://chat.example.org":     true,
+		"https://a.b.example.org":      true,
+		"https://example.org":          false,
+		"https://badexample.org":       false,
+		"http://localhost:3000":        true,
+		"http://localhost:3001":        false,
+		"null":                         false,
+		"":                             false,
+	} {
+		if got := allowlist.Allowed(origin); got != want {
+			t.Errorf("Allowed(%q) = %v, want %v", origin, got, want)
+		}
+	}
+}
+
+func TestOriginAllowlistDevAllowsLoopback(t *testing.T) {
+	allowlist, err := NewOriginAllowlist([]string{"https://app.example.com"}, true)
+	if err != nil {
+		t.Fatalf("NewOriginAllowlist: %v", err)
+	}
+
+	for origin, want := range map[string]bool{
+		"http://localhost:5173":  true,
+		"http://127.0.0.1:3000":  true,
+		"http://[::1]:3000":      true,
+		"https://evil.localhost": false,
+		"https://evil.com":       false,
+	} {
+		if got := allowlist.Allowed(origin); got != want {
+			t.Errorf("Allowed(%q) = %v, want %v", origin, got, want)
+		}
+	}
+}
+
+func TestOriginAllowlistRejectsInvalidOrigins(t *testing.T) {
+	for _, origin := range []string{"*", "example.com", "https://example.com/app", "ftp://example.com"} {
+		if _, err := NewOriginAllowlist([]string{origin}, false); err == nil {
+			t.Errorf("expected %q to be rejected", origin)
+		}
+	}
+}
@@ -1,19 +1,80 @@
 // internal/config/config.go
 package config
 
-import "os"
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
 
 // Config holds all application configuration
 type Config struct {
-	Server   ServerConfig
-	Database DatabaseConfig
-	Redis    RedisConfig
-	OAuth    OAuthConfig
+	Server    ServerConfig
+	WebSocket WebSocketConfig
+	RateLimit RateLimitConfig
+	Storage   StorageConfig
+	Database  DatabaseConfig
+	Redis     RedisConfig
+	OAuth     OAuthConfig
 }
 
 // ServerConfig contains server related settings
 type ServerConfig struct {
 	Port string
+
+	// ShutdownTimeout is how long in-flight requests and WebSocket clients get to drain on shutdown
+	ShutdownTimeout time.Duration
+
+	// AllowedOrigins are the browser origins allowed to make CORS requests and open
+	// WebSockets, such as "https://app.example.com" or "https://*.example.com"
+	AllowedOrigins []string
+
+	// DevMode relaxes checks for local development, such as allowing any localhost origin
+	DevMode bool
+}
+
+// WebSocketConfig contains WebSocket delivery settings
+type WebSocketConfig struct {
+	// HubShards is the number of goroutines rooms are spread across; 0 means one per CPU
+	HubShards int
+
+	// SendQueueSize is how many frames are queued for a slow client before its
+	// typing and presence frames are dropped
+	SendQueueSize int
+
+	// SendQueueOverflowTimeout is how long a client's queue may stay full before it is disconnected
+	SendQueueOverflowTimeout time.Duration
+
+	// CompressionLevel is the permessage-deflate level (1-9) offered to clients; 0 disables compression
+	CompressionLevel int
+
+	// TypingTimeout is how long a user shows as typing after their last typing frame
+	TypingTimeout time.Duration
+}
+
+// RateLimitConfig contains the rate limits for WebSocket frames and HTTP endpoints
+type RateLimitConfig struct {
+	// Frames limits each user's WebSocket frames by type; "*" applies to types without their own limit
+	Frames map[string]RateLimit
+
+	// RoomFrames limits each room's WebSocket frames by type, across all of its users
+	RoomFrames map[string]RateLimit
+
+	// Endpoints limits each user's requests to an endpoint by name, or each client IP's before sign in
+	Endpoints map[string]RateLimit
+}
+
+// RateLimit allows Burst requests at once, refilling completely over Period
+type RateLimit struct {
+	Burst  int
+	Period time.Duration
+}
+
+// StorageConfig selects the storage backend
+type StorageConfig struct {
+	// Driver is "postgres" (PostgreSQL and Redis) or "memory" (in-process, not persisted)
+	Driver string
 }
 
 // DatabaseConfig contains database settings
@@ -50,7 +111,37 @@ type OAuthConfig struct {
 func Load() *Config {
 	return &Config{
 		Server: ServerConfig{
-			Port: getEnv("PORT", "8080"),
+			Port:            getEnv("PORT", "8080"),
+			ShutdownTimeout: getDurationEnv("SHUTDOWN_TIMEOUT", 15*time.Second),
+			AllowedOrigins:  getListEnv("ALLOWED_ORIGINS", []string{getEnv("FRONTEND_URI", "http://localhost:3000")}),
+			DevMode:         getEnv("GO_ENV", "production") == "development",
+		},
+		WebSocket: WebSocketConfig{
+			HubShards:                getIntEnv("WS_HUB_SHARDS", 0),
+			SendQueueSize:            getIntEnv("WS_SEND_QUEUE_SIZE", 256),
+			SendQueueOverflowTimeout: getDurationEnv("WS_SEND_QUEUE_OVERFLOW_TIMEOUT", 5*time.Second),
+			CompressionLevel:         getIntRangeEnv("WS_COMPRESSION_LEVEL", 1, 0, 9),
+			TypingTimeout:            getDurationEnv("WS_TYPING_TIMEOUT", 6*time.Second),
+		},
+		RateLimit: RateLimitConfig{
+			Frames: getRateLimitsEnv("RATE_LIMIT_FRAMES", map[string]RateLimit{
+				"*":             {Burst: 60, Period: 10 * time.Second},
+				"message":       {Burst: 20, Period: 10 * time.Second},
+				"typing":        {Burst: 10, Period: 5 * time.Second},
+				"create_thread": {Burst: 5, Period: time.Minute},
+			}),
+			RoomFrames: getRateLimitsEnv("RATE_LIMIT_ROOM_FRAMES", map[string]RateLimit{
+				"message": {Burst: 100, Period: 10 * time.Second},
+			}),
+			Endpoints: getRateLimitsEnv("RATE_LIMIT_ENDPOINTS", map[string]RateLimit{
+				"friend_request": {Burst: 10, Period: time.Minute},
+				"send_message":   {Burst: 20, Period: 10 * time.Second},
+				"ws_ticket":      {Burst: 10, Period: time.Minute},
+				"refresh_token":  {Burst: 10, Period: time.Minute},
+			}),
+		},
+		Storage: StorageConfig{
+			Driver: getEnv("STORAGE_DRIVER", "postgres"),
 		},
 		Database: DatabaseConfig{
 			Host:     getEnv("POSTGRES_HOST", "localhost"),
@@ -87,3 +178,77 @@ func getEnv(key, defaultValue string) string {
 	}
 	return value
 }
+
+// Helper function to get a duration environment variable such as "15s" with a default value
+func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// Helper function to get a positive integer environment variable with a default value
+func getIntEnv(key string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || value <= 0 {
+		return defaultValue
+	}
+	return value
+}
+
+// Helper function to get an integer environment variable between low and high
+// with a default value, for settings where 0 means something
+func getIntRangeEnv(key string, defaultValue, low, high int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || value < low || value > high {
+		return defaultValue
+	}
+	return value
+}
+
+// Helper function to get a comma-separated list environment variable with a default value
+func getListEnv(key string, defaultValue []string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	if len(values) == 0 {
+		return defaultValue
+	}
+	return values
+}
+
+// Helper function to get rate limits such as "message=20/10s,typing=off" from an
+// environment variable, overriding or turning off individual defaults
+func getRateLimitsEnv(key string, defaults map[string]RateLimit) map[string]RateLimit {
+	limits := make(map[string]RateLimit, len(defaults))
+	for name, limit := range defaults {
+		limits[name] = limit
+	}
+
+	for _, entry := range strings.Split(os.Getenv(key), ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			continue
+		}
+		if value == "off" {
+			delete(limits, name)
+			continue
+		}
+
+		burst, period, ok := strings.Cut(value, "/")
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(burst)
+		d, err2 := time.ParseDuration(period)
+		if err != nil || err2 != nil || n <= 0 || d <= 0 {
+			continue
+		}
+		limits[name] = RateLimit{Burst: n, Period: d}
+	}
+	return limits
+}
@@ -0,0 +1,27 @@
+package config
+
+import "testing"
+
+func TestLoadCompressionLevel(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int
+	}{
+		{"", 1},
+		{"0", 0},
+		{"6", 6},
+		{"9", 9},
+		{"10", 1},
+		{"-1", 1},
+		{"fast", 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			t.Setenv("WS_COMPRESSION_LEVEL", test.value)
+			if got := Load().WebSocket.CompressionLevel; got != test.want {
+				t.Fatalf("WS_COMPRESSION_LEVEL=%q: got level %d, want %d", test.value, got, test.want)
+			}
+		})
+	}
+}
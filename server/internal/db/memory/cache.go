@@ -0,0 +1,160 @@
+// internal/db/memory/cache.go
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/mjxoro/sent/server/internal/repository"
+)
+
+var _ repository.Cache = (*Cache)(nil)
+
+// Cache is an in-memory cache with per-key expiry. Missing keys return
+// repository.ErrCacheMiss, like the Redis-backed cache.
+type Cache struct {
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+// cacheEntry is a JSON encoded value and its expiry time (zero for no expiry)
+type cacheEntry struct {
+	data      []byte
+	expiresAt time.Time
+}
+
+// NewCache creates a new in-memory cache
+func NewCache() *Cache {
+	return &Cache{
+		entries: make(map[string]cacheEntry),
+	}
+}
+
+// Set stores a value in the cache with an expiration time
+func (c *Cache) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(key, data, expiration)
+	return nil
+}
+
+// Get retrieves a value from the cache and unmarshals it into the result
+func (c *Cache) Get(ctx context.Context, key string, result interface{}) error {
+	c.mu.Lock()
+	data, ok := c.get(key)
+	c.mu.Unlock()
+
+	if !ok {
+		return repository.ErrCacheMiss
+	}
+	return json.Unmarshal(data, result)
+}
+
+// Take retrieves a value and removes it from the cache, so a value is only ever returned once
+func (c *Cache) Take(ctx context.Context, key string, result interface{}) error {
+	c.mu.Lock()
+	data, ok := c.get(key)
+	delete(c.entries, key)
+	c.mu.Unlock()
+
+	if !ok {
+		return repository.ErrCacheMiss
+	}
+	return json.Unmarshal(data, result)
+}
+
+// Delete removes a key from the cache
+func (c *Cache) Delete(ctx context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+	return nil
+}
+
+// Exists checks if a key exists in the cache
+func (c *Cache) Exists(ctx context.Context, key string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.get(key)
+	return ok, nil
+}
+
+// GetUserOnlineStatus gets the online status of users
+func (c *Cache) GetUserOnlineStatus(ctx context.Context, userIDs []string) (map[string]bool, error) {
+	result := make(map[string]bool, len(userIDs))
+	for _, userID := range userIDs {
+		online, err := c.Exists(ctx, "user:online:"+userID)
+		if err != nil {
+			return nil, err
+		}
+		result[userID] = online
+	}
+	return result, nil
+}
+
+// SetUserOnline marks a user as online with a TTL
+func (c *Cache) SetUserOnline(ctx context.Context, userID string, duration time.Duration) error {
+	return c.Set(ctx, "user:online:"+userID, "1", duration)
+}
+
+// GetUnreadMessageCount gets the cached unread message count for a user in a room
+func (c *Cache) GetUnreadMessageCount(ctx context.Context, userID, roomID string) (int, error) {
+	var count int
+	if err := c.Get(ctx, "unread:"+userID+":"+roomID, &count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// IncrementUnreadCount increments the unread message count for a user in a room
+func (c *Cache) IncrementUnreadCount(ctx context.Context, userID, roomID string) error {
+	key := "unread:" + userID + ":" + roomID
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	count := 0
+	if data, ok := c.get(key); ok {
+		if err := json.Unmarshal(data, &count); err != nil {
+			return err
+		}
+	}
+
+	data, _ := json.Marshal(count + 1)
+	c.set(key, data, 0)
+	return nil
+}
+
+// ResetUnreadCount resets the unread message count for a user in a room
+func (c *Cache) ResetUnreadCount(ctx context.Context, userID, roomID string) error {
+	return c.Set(ctx, "unread:"+userID+":"+roomID, 0, 0)
+}
+
+// get returns the data stored under key if it hasn't expired. c.mu must be held.
+func (c *Cache) get(key string) ([]byte, bool) {
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !entry.expiresAt.IsZero() && time.Now().After(entry.expiresAt) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return entry.data, true
+}
+
+// set stores data under key. c.mu must be held.
+func (c *Cache) set(key string, data []byte, expiration time.Duration) {
+	entry := cacheEntry{data: data}
+	if expiration > 0 {
+		entry.expiresAt = time.Now().Add(expiration)
+	}
+	c.entries[key] = entry
+}
@@ -0,0 +1,149 @@
+// internal/db/memory/friend_list.go
+package memory
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mjxoro/sent/server/internal/models"
+	"github.com/mjxoro/sent/server/internal/repository"
+)
+
+var _ repository.FriendList = (*FriendList)(nil)
+
+// FriendList is an in-memory friend list repository
+type FriendList struct {
+	store *Store
+}
+
+// Create creates a new friend list
+func (r *FriendList) Create(ctx context.Context, list *models.FriendList) error {
+	return r.store.write(func(st *state) error {
+		if st.friendListNameTaken(list.OwnerID, list.Name, "") {
+			return errors.New("duplicate key value violates unique constraint on friend_lists")
+		}
+
+		now := time.Now()
+		list.ID = uuid.NewString()
+		list.CreatedAt = now
+		list.UpdatedAt = now
+		st.friendLists[list.ID] = *list
+		return nil
+	})
+}
+
+// FindByID finds a friend list by ID
+func (r *FriendList) FindByID(ctx context.Context, id string) (*models.FriendList, error) {
+	var list *models.FriendList
+	err := r.store.read(func(st *state) error {
+		found, ok := st.friendLists[id]
+		if !ok {
+			return errNotFound
+		}
+		list = &found
+		return nil
+	})
+	return list, err
+}
+
+// FindByOwnerID finds all friend lists owned by a user
+func (r *FriendList) FindByOwnerID(ctx context.Context, ownerID string) ([]*models.FriendList, error) {
+	var lists []*models.FriendList
+	err := r.store.read(func(st *state) error {
+		for _, list := range st.friendLists {
+			if list.OwnerID == ownerID {
+				lists = append(lists, &list)
+			}
+		}
+		return nil
+	})
+
+	sort.Slice(lists, func(i, j int) bool {
+		return lists[i].Name < lists[j].Name
+	})
+
+	return lists, err
+}
+
+// UpdateName renames a friend list
+func (r *FriendList) UpdateName(ctx context.Context, id, name string) error {
+	return r.store.write(func(st *state) error {
+		list, ok := st.friendLists[id]
+		if !ok {
+			return nil
+		}
+		if st.friendListNameTaken(list.OwnerID, name, id) {
+			return errors.New("duplicate key value violates unique constraint on friend_lists")
+		}
+
+		list.Name = name
+		list.UpdatedAt = time.Now()
+		st.friendLists[id] = list
+		return nil
+	})
+}
+
+// Delete deletes a friend list
+func (r *FriendList) Delete(ctx context.Context, id string) error {
+	return r.store.write(func(st *state) error {
+		delete(st.friendLists, id)
+		delete(st.friendListMembers, id)
+		return nil
+	})
+}
+
+// AddMember adds a user to a friend list
+func (r *FriendList) AddMember(ctx context.Context, listID, userID string) error {
+	return r.store.write(func(st *state) error {
+		if _, ok := st.friendLists[listID]; !ok {
+			return errors.New("insert violates foreign key constraint on friend_list_members.list_id")
+		}
+		if st.friendListMembers[listID] == nil {
+			st.friendListMembers[listID] = make(map[string]time.Time)
+		}
+		if _, ok := st.friendListMembers[listID][userID]; !ok {
+			st.friendListMembers[listID][userID] = time.Now()
+		}
+		return nil
+	})
+}
+
+// RemoveMember removes a user from a friend list
+func (r *FriendList) RemoveMember(ctx context.Context, listID, userID string) error {
+	return r.store.write(func(st *state) error {
+		delete(st.friendListMembers[listID], userID)
+		return nil
+	})
+}
+
+// GetMembers gets the members of a friend list who are still accepted friends of the owner
+func (r *FriendList) GetMembers(ctx context.Context, listID string) ([]*models.User, error) {
+	var users []*models.User
+	err := r.store.read(func(st *state) error {
+		list, ok := st.friendLists[listID]
+		if !ok {
+			return nil
+		}
+		for userID := range st.friendListMembers[listID] {
+			if user := st.userPtr(userID); user != nil && st.areFriends(list.OwnerID, userID) {
+				users = append(users, user)
+			}
+		}
+		return nil
+	})
+	sortUsersByName(users)
+	return users, err
+}
+
+// friendListNameTaken reports whether an owner already has another list with the name
+func (st *state) friendListNameTaken(ownerID, name, exceptID string) bool {
+	for id, list := range st.friendLists {
+		if id != exceptID && list.OwnerID == ownerID && list.Name == name {
+			return true
+		}
+	}
+	return false
+}
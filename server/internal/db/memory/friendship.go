@@ -0,0 +1,251 @@
+// internal/db/memory/friendship.go
+package memory
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mjxoro/sent/server/internal/models"
+	"github.com/mjxoro/sent/server/internal/repository"
+)
+
+var _ repository.Friendship = (*Friendship)(nil)
+
+// friendshipStatusOrder matches the declaration order of the friendship_status enum
+var friendshipStatusOrder = map[models.FriendshipStatus]int{
+	models.FriendshipStatusPending:  0,
+	models.FriendshipStatusAccepted: 1,
+	models.FriendshipStatusRejected: 2,
+	models.FriendshipStatusBlocked:  3,
+}
+
+// Friendship is an in-memory friendship repository
+type Friendship struct {
+	store *Store
+}
+
+// Create creates a new friendship request
+func (r *Friendship) Create(ctx context.Context, friendship *models.Friendship) error {
+	return r.store.write(func(st *state) error {
+		if friendship.UserID == friendship.FriendID {
+			return errors.New("friendship violates check constraint on friendships")
+		}
+		for _, existing := range st.friendships {
+			if existing.UserID == friendship.UserID && existing.FriendID == friendship.FriendID {
+				return errors.New("duplicate key value violates unique constraint on friendships")
+			}
+		}
+
+		now := time.Now()
+		friendship.ID = uuid.NewString()
+		friendship.CreatedAt = now
+		friendship.UpdatedAt = now
+		st.friendships[friendship.ID] = *friendship
+		return nil
+	})
+}
+
+// FindByID finds a friendship by ID
+func (r *Friendship) FindByID(ctx context.Context, id string) (*models.Friendship, error) {
+	var friendship *models.Friendship
+	err := r.store.read(func(st *state) error {
+		found, ok := st.friendships[id]
+		if !ok {
+			return errNotFound
+		}
+		friendship = &found
+		return nil
+	})
+	return friendship, err
+}
+
+// FindByIDForUpdate finds a friendship by ID. Transactions on the store are
+// serialized, so no additional locking is needed.
+func (r *Friendship) FindByIDForUpdate(ctx context.Context, id string) (*models.Friendship, error) {
+	return r.FindByID(ctx, id)
+}
+
+// FindByUserAndFriend finds a friendship between two users
+func (r *Friendship) FindByUserAndFriend(ctx context.Context, userID, friendID string) (*models.Friendship, error) {
+	var friendship *models.Friendship
+	err := r.store.read(func(st *state) error {
+		friendship = st.findFriendship(userID, friendID)
+		if friendship == nil {
+			return errNotFound
+		}
+		return nil
+	})
+	return friendship, err
+}
+
+// FindFriendsByUserID finds all friends of a user with specified status
+func (r *Friendship) FindFriendsByUserID(ctx context.Context, userID string, status models.FriendshipStatus) ([]*models.FriendshipWithUser, error) {
+	friends := r.withOtherUser(userID, func(friendship models.Friendship) bool {
+		return friendship.Status == status
+	})
+
+	sort.Slice(friends, func(i, j int) bool {
+		return friends[i].FriendName < friends[j].FriendName
+	})
+
+	return friends, nil
+}
+
+// FindAllUserRelationships finds all friendship relationships for a user
+func (r *Friendship) FindAllUserRelationships(ctx context.Context, userID string) ([]*models.FriendshipWithUser, error) {
+	friends := r.withOtherUser(userID, func(models.Friendship) bool {
+		return true
+	})
+
+	sort.Slice(friends, func(i, j int) bool {
+		if friends[i].Status != friends[j].Status {
+			return friendshipStatusOrder[friends[i].Status] < friendshipStatusOrder[friends[j].Status]
+		}
+		return friends[i].FriendName < friends[j].FriendName
+	})
+
+	return friends, nil
+}
+
+// FindPendingRequests finds all pending friend requests for a user
+func (r *Friendship) FindPendingRequests(ctx context.Context, userID string) ([]*models.FriendshipWithUser, error) {
+	var requests []*models.FriendshipWithUser
+	err := r.store.read(func(st *state) error {
+		for _, friendship := range st.friendships {
+			if friendship.FriendID != userID || friendship.Status != models.FriendshipStatusPending {
+				continue
+			}
+			if requester, ok := st.users[friendship.UserID]; ok {
+				requests = append(requests, withUser(friendship, requester))
+			}
+		}
+		return nil
+	})
+
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].CreatedAt.After(requests[j].CreatedAt)
+	})
+
+	return requests, err
+}
+
+// UpdateStatus updates the status of a friendship
+func (r *Friendship) UpdateStatus(ctx context.Context, id string, status models.FriendshipStatus) error {
+	return r.store.write(func(st *state) error {
+		friendship, ok := st.friendships[id]
+		if !ok {
+			return nil
+		}
+		friendship.Status = status
+		friendship.UpdatedAt = time.Now()
+		st.friendships[id] = friendship
+		return nil
+	})
+}
+
+// Delete deletes a friendship
+func (r *Friendship) Delete(ctx context.Context, id string) error {
+	return r.store.write(func(st *state) error {
+		delete(st.friendships, id)
+		return nil
+	})
+}
+
+// FindSuggestions finds users who are not connected to the specified user, ranked by
+// mutual friends and then by shared group rooms, excluding dismissed suggestions
+func (r *Friendship) FindSuggestions(ctx context.Context, userID string, limit, offset int) ([]*models.FriendSuggestion, error) {
+	var suggestions []*models.FriendSuggestion
+	err := r.store.read(func(st *state) error {
+		for candidateID, candidate := range st.users {
+			if candidateID == userID || st.findFriendship(userID, candidateID) != nil || st.dismissals[userID][candidateID] {
+				continue
+			}
+
+			suggestion := &models.FriendSuggestion{User: candidate}
+			for friendID := range st.users {
+				if st.areFriends(userID, friendID) && st.areFriends(candidateID, friendID) {
+					suggestion.MutualFriends++
+				}
+			}
+
+			var latestShared *models.Room
+			for roomID, members := range st.roomMembers {
+				room := st.rooms[roomID]
+				_, userIn := members[userID]
+				_, candidateIn := members[candidateID]
+				if room.Type != "group" || !userIn || !candidateIn {
+					continue
+				}
+				suggestion.SharedRooms++
+				if latestShared == nil || room.UpdatedAt.After(latestShared.UpdatedAt) {
+					latestShared = &room
+				}
+			}
+			if latestShared != nil {
+				suggestion.SharedRoomName = latestShared.Name
+			}
+
+			suggestions = append(suggestions, suggestion)
+		}
+		return nil
+	})
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		a, b := suggestions[i], suggestions[j]
+		if a.MutualFriends != b.MutualFriends {
+			return a.MutualFriends > b.MutualFriends
+		}
+		if a.SharedRooms != b.SharedRooms {
+			return a.SharedRooms > b.SharedRooms
+		}
+		return a.Name < b.Name
+	})
+
+	return paginate(suggestions, limit, offset), err
+}
+
+// DismissSuggestion hides a user from another user's friend suggestions
+func (r *Friendship) DismissSuggestion(ctx context.Context, userID, suggestedUserID string) error {
+	return r.store.write(func(st *state) error {
+		if st.dismissals[userID] == nil {
+			st.dismissals[userID] = make(map[string]bool)
+		}
+		st.dismissals[userID][suggestedUserID] = true
+		return nil
+	})
+}
+
+// withOtherUser finds a user's friendships matching the filter, joined with the other user
+func (r *Friendship) withOtherUser(userID string, match func(models.Friendship) bool) []*models.FriendshipWithUser {
+	var result []*models.FriendshipWithUser
+	r.store.read(func(st *state) error {
+		for _, friendship := range st.friendships {
+			if (friendship.UserID != userID && friendship.FriendID != userID) || !match(friendship) {
+				continue
+			}
+
+			otherID := friendship.FriendID
+			if friendship.FriendID == userID {
+				otherID = friendship.UserID
+			}
+			if other, ok := st.users[otherID]; ok {
+				result = append(result, withUser(friendship, other))
+			}
+		}
+		return nil
+	})
+	return result
+}
+
+// withUser joins a friendship with the details of a user
+func withUser(friendship models.Friendship, user models.User) *models.FriendshipWithUser {
+	return &models.FriendshipWithUser{
+		Friendship:   friendship,
+		FriendName:   user.Name,
+		FriendEmail:  user.Email,
+		FriendAvatar: user.Avatar,
+	}
+}
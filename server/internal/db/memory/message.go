@@ -0,0 +1,169 @@
+// internal/db/memory/message.go
+package memory
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mjxoro/sent/server/internal/models"
+	"github.com/mjxoro/sent/server/internal/repository"
+)
+
+var _ repository.Message = (*Message)(nil)
+
+// Message is an in-memory message repository
+type Message struct {
+	store *Store
+}
+
+// Create creates a new message
+func (r *Message) Create(ctx context.Context, message *models.Message) error {
+	return r.store.write(func(st *state) error {
+		if message.ClientMsgID != nil && st.messageByClientMsgID(message.UserID, *message.ClientMsgID) != nil {
+			return errors.New("duplicate key value violates unique constraint on messages.client_msg_id")
+		}
+		now := time.Now()
+		message.ID = uuid.NewString()
+		message.CreatedAt = now
+		message.UpdatedAt = now
+		st.messages[message.ID] = *message
+		return nil
+	})
+}
+
+// CreateIfNotExists creates a new message unless the user already sent a message
+// with the same client message ID. It reports whether the message was created.
+func (r *Message) CreateIfNotExists(ctx context.Context, message *models.Message) (bool, error) {
+	created := false
+	err := r.store.write(func(st *state) error {
+		if message.ClientMsgID != nil && st.messageByClientMsgID(message.UserID, *message.ClientMsgID) != nil {
+			return nil
+		}
+		now := time.Now()
+		message.ID = uuid.NewString()
+		message.CreatedAt = now
+		message.UpdatedAt = now
+		st.messages[message.ID] = *message
+		created = true
+		return nil
+	})
+	return created, err
+}
+
+// FindByClientMsgID finds a message by its sender and client message ID
+func (r *Message) FindByClientMsgID(ctx context.Context, userID, clientMsgID string) (*models.Message, error) {
+	var message *models.Message
+	err := r.store.read(func(st *state) error {
+		message = st.messageByClientMsgID(userID, clientMsgID)
+		if message == nil {
+			return errNotFound
+		}
+		return nil
+	})
+	return message, err
+}
+
+// messageByClientMsgID returns a copy of the user's message with a client message ID, or nil
+func (st *state) messageByClientMsgID(userID, clientMsgID string) *models.Message {
+	for _, message := range st.messages {
+		if message.UserID == userID && message.ClientMsgID != nil && *message.ClientMsgID == clientMsgID {
+			return &message
+		}
+	}
+	return nil
+}
+
+// FindByRoomID finds messages in a room with pagination, oldest first
+func (r *Message) FindByRoomID(ctx context.Context, roomID string, limit, offset int) ([]*models.MessageDTO, error) {
+	var messages []*models.MessageDTO
+	err := r.store.read(func(st *state) error {
+		for _, message := range st.messages {
+			if message.RoomID != roomID {
+				continue
+			}
+			user, ok := st.users[message.UserID]
+			if !ok {
+				continue
+			}
+			messages = append(messages, &models.MessageDTO{
+				ID:         message.ID,
+				RoomID:     message.RoomID,
+				UserID:     message.UserID,
+				Content:    message.Content,
+				CreatedAt:  message.CreatedAt,
+				UpdatedAt:  message.UpdatedAt,
+				UserName:   user.Name,
+				UserAvatar: user.Avatar,
+			})
+		}
+		return nil
+	})
+
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].CreatedAt.Before(messages[j].CreatedAt)
+	})
+
+	return paginate(messages, limit, offset), err
+}
+
+// FindByID finds a message by ID
+func (r *Message) FindByID(ctx context.Context, id string) (*models.Message, error) {
+	var message *models.Message
+	err := r.store.read(func(st *state) error {
+		found, ok := st.messages[id]
+		if !ok {
+			return errNotFound
+		}
+		message = &found
+		return nil
+	})
+	return message, err
+}
+
+// MarkAsRead marks a message as read by a user
+func (r *Message) MarkAsRead(ctx context.Context, messageID, userID string) error {
+	return r.store.write(func(st *state) error {
+		if st.messageStatus[messageID] == nil {
+			st.messageStatus[messageID] = make(map[string]models.MessageStatus)
+		}
+		st.messageStatus[messageID][userID] = models.MessageStatus{
+			MessageID: messageID,
+			UserID:    userID,
+			IsRead:    true,
+			ReadAt:    time.Now(),
+		}
+		return nil
+	})
+}
+
+// GetUnreadCount gets the count of unread messages in a room for a user
+func (r *Message) GetUnreadCount(ctx context.Context, roomID, userID string) (int, error) {
+	count := 0
+	err := r.store.read(func(st *state) error {
+		for _, message := range st.messages {
+			if message.RoomID != roomID {
+				continue
+			}
+			if status, ok := st.messageStatus[message.ID][userID]; !ok || !status.IsRead {
+				count++
+			}
+		}
+		return nil
+	})
+	return count, err
+}
+
+// paginate applies a limit and offset to a slice
+func paginate[T any](items []T, limit, offset int) []T {
+	if offset >= len(items) {
+		return nil
+	}
+	items = items[offset:]
+	if limit >= 0 && limit < len(items) {
+		items = items[:limit]
+	}
+	return items
+}
@@ -0,0 +1,109 @@
+// internal/db/memory/pubsub.go
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+
+	"github.com/mjxoro/sent/server/internal/repository"
+)
+
+var _ repository.PubSub = (*PubSub)(nil)
+
+// subscriberBuffer is how many messages a subscriber may fall behind before messages are dropped
+const subscriberBuffer = 64
+
+// publication is a message delivered to a subscriber
+type publication struct {
+	channel string
+	payload []byte
+}
+
+// PubSub is an in-process pub/sub implementation
+type PubSub struct {
+	mu          sync.RWMutex
+	subscribers map[string]map[chan publication]bool
+}
+
+// NewPubSub creates a new in-process PubSub instance
+func NewPubSub() *PubSub {
+	return &PubSub{
+		subscribers: make(map[string]map[chan publication]bool),
+	}
+}
+
+// PublishMessage publishes a message to a channel
+func (ps *PubSub) PublishMessage(ctx context.Context, channel string, message interface{}) error {
+	jsonData, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	for ch := range ps.subscribers[channel] {
+		select {
+		case ch <- publication{channel: channel, payload: jsonData}:
+		default:
+			log.Printf("Dropping message on channel %s for slow subscriber", channel)
+		}
+	}
+
+	return nil
+}
+
+// Subscribe subscribes to a channel and handles incoming messages until ctx is cancelled
+func (ps *PubSub) Subscribe(ctx context.Context, channel string, handler func([]byte)) {
+	ps.listen(ctx, []string{channel}, func(pub publication) {
+		handler(pub.payload)
+	})
+}
+
+// SubscribeToRooms subscribes to multiple room channels until ctx is cancelled
+func (ps *PubSub) SubscribeToRooms(ctx context.Context, roomIDs []string, handler func(string, []byte)) {
+	channels := make([]string, len(roomIDs))
+	for i, roomID := range roomIDs {
+		channels[i] = "chat:room:" + roomID
+	}
+
+	ps.listen(ctx, channels, func(pub publication) {
+		handler(pub.channel[len("chat:room:"):], pub.payload)
+	})
+}
+
+// listen delivers messages published to any of the channels until ctx is cancelled
+func (ps *PubSub) listen(ctx context.Context, channels []string, deliver func(publication)) {
+	ch := make(chan publication, subscriberBuffer)
+
+	ps.mu.Lock()
+	for _, channel := range channels {
+		if ps.subscribers[channel] == nil {
+			ps.subscribers[channel] = make(map[chan publication]bool)
+		}
+		ps.subscribers[channel][ch] = true
+	}
+	ps.mu.Unlock()
+
+	defer func() {
+		ps.mu.Lock()
+		for _, channel := range channels {
+			delete(ps.subscribers[channel], ch)
+			if len(ps.subscribers[channel]) == 0 {
+				delete(ps.subscribers, channel)
+			}
+		}
+		ps.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case pub := <-ch:
+			deliver(pub)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+// internal/db/memory/rate_limiter.go
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/mjxoro/sent/server/internal/repository"
+)
+
+var _ repository.RateLimiter = (*RateLimiter)(nil)
+
+// sweepInterval is how often buckets that have refilled are forgotten
+const sweepInterval = time.Minute
+
+// bucket is one key's tokens as of updated
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	period  time.Duration
+}
+
+// RateLimiter is an in-process token bucket rate limiter. Limits apply to this
+// process only; use the Redis-backed limiter to share them between instances.
+type RateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// NewRateLimiter creates a new in-process rate limiter
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow takes a token from key's bucket, which holds burst tokens and refills
+// completely over period
+func (l *RateLimiter) Allow(ctx context.Context, key string, burst int, period time.Duration) (bool, time.Duration, error) {
+	now := time.Now()
+	rate := float64(burst) / period.Seconds()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(burst), updated: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(float64(burst), b.tokens+now.Sub(b.updated).Seconds()*rate)
+	b.updated = now
+	b.period = period
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, nil
+	}
+	wait := time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	return false, wait, nil
+}
+
+// sweep forgets buckets that have had time to refill, as they are the same as
+// new ones. l.mu must be held.
+func (l *RateLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if now.Sub(b.updated) >= b.period {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
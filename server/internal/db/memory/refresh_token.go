@@ -0,0 +1,118 @@
+// internal/db/memory/refresh_token.go
+package memory
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mjxoro/sent/server/internal/models"
+	"github.com/mjxoro/sent/server/internal/repository"
+)
+
+var _ repository.RefreshToken = (*RefreshToken)(nil)
+
+// RefreshToken is an in-memory refresh token repository
+type RefreshToken struct {
+	store *Store
+}
+
+// Create stores a refresh token's hash
+func (r *RefreshToken) Create(ctx context.Context, token *models.RefreshToken) error {
+	return r.store.write(func(st *state) error {
+		for _, existing := range st.refreshTokens {
+			if existing.TokenHash == token.TokenHash {
+				return errors.New("duplicate key value violates unique constraint on refresh_tokens")
+			}
+		}
+
+		token.ID = uuid.NewString()
+		token.CreatedAt = time.Now()
+		st.refreshTokens[token.ID] = *token
+		return nil
+	})
+}
+
+// GetByHash finds a refresh token by its hash
+func (r *RefreshToken) GetByHash(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	var token *models.RefreshToken
+	err := r.store.read(func(st *state) error {
+		for _, existing := range st.refreshTokens {
+			if existing.TokenHash == tokenHash {
+				token = &existing
+				return nil
+			}
+		}
+		return errNotFound
+	})
+	return token, err
+}
+
+// MarkRotated records that a refresh token was exchanged for a new one
+func (r *RefreshToken) MarkRotated(ctx context.Context, id string) (bool, error) {
+	rotated := false
+	err := r.store.write(func(st *state) error {
+		token, ok := st.refreshTokens[id]
+		if !ok || token.RotatedAt != nil || token.IsRevoked {
+			return nil
+		}
+
+		now := time.Now()
+		token.RotatedAt = &now
+		st.refreshTokens[id] = token
+		rotated = true
+		return nil
+	})
+	return rotated, err
+}
+
+// Revoke marks a refresh token as revoked
+func (r *RefreshToken) Revoke(ctx context.Context, userID, tokenHash string) error {
+	return r.revokeWhere(func(existing models.RefreshToken) bool {
+		return existing.UserID == userID && existing.TokenHash == tokenHash
+	})
+}
+
+// RevokeFamily revokes every refresh token in a family
+func (r *RefreshToken) RevokeFamily(ctx context.Context, familyID string) error {
+	return r.revokeWhere(func(existing models.RefreshToken) bool {
+		return existing.FamilyID == familyID
+	})
+}
+
+// RevokeAllForUser revokes all refresh tokens for a user
+func (r *RefreshToken) RevokeAllForUser(ctx context.Context, userID string) error {
+	return r.revokeWhere(func(existing models.RefreshToken) bool {
+		return existing.UserID == userID
+	})
+}
+
+// DeleteExpired deletes refresh tokens that have expired
+func (r *RefreshToken) DeleteExpired(ctx context.Context) (int64, error) {
+	var deleted int64
+	err := r.store.write(func(st *state) error {
+		now := time.Now()
+		for id, existing := range st.refreshTokens {
+			if !existing.ExpiresAt.After(now) {
+				delete(st.refreshTokens, id)
+				deleted++
+			}
+		}
+		return nil
+	})
+	return deleted, err
+}
+
+// revokeWhere revokes every refresh token matching the predicate
+func (r *RefreshToken) revokeWhere(match func(models.RefreshToken) bool) error {
+	return r.store.write(func(st *state) error {
+		for id, existing := range st.refreshTokens {
+			if match(existing) {
+				existing.IsRevoked = true
+				st.refreshTokens[id] = existing
+			}
+		}
+		return nil
+	})
+}
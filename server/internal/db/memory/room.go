@@ -0,0 +1,171 @@
+// internal/db/memory/room.go
+package memory
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mjxoro/sent/server/internal/models"
+	"github.com/mjxoro/sent/server/internal/repository"
+)
+
+var _ repository.Room = (*Room)(nil)
+
+// Room is an in-memory room repository
+type Room struct {
+	store *Store
+}
+
+// FindByID finds a room by ID
+func (r *Room) FindByID(ctx context.Context, id string) (*models.Room, error) {
+	var room *models.Room
+	err := r.store.read(func(st *state) error {
+		found, ok := st.rooms[id]
+		if !ok {
+			return errNotFound
+		}
+		room = &found
+		return nil
+	})
+	return room, err
+}
+
+// FindRoomsByUserID finds all rooms a user is a member of
+func (r *Room) FindRoomsByUserID(ctx context.Context, userID string) ([]*models.Room, error) {
+	var rooms []*models.Room
+	err := r.store.read(func(st *state) error {
+		for roomID, members := range st.roomMembers {
+			if _, ok := members[userID]; !ok {
+				continue
+			}
+			if room, ok := st.rooms[roomID]; ok {
+				rooms = append(rooms, &room)
+			}
+		}
+		return nil
+	})
+
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].UpdatedAt.After(rooms[j].UpdatedAt)
+	})
+
+	return rooms, err
+}
+
+// Create creates a new room
+func (r *Room) Create(ctx context.Context, room *models.Room) error {
+	return r.store.write(func(st *state) error {
+		if room.MemberHash != nil && st.roomByMemberHash(*room.MemberHash) != nil {
+			return errors.New("duplicate key value violates unique constraint on rooms.member_hash")
+		}
+		st.insertRoom(room)
+		return nil
+	})
+}
+
+// CreateIfNotExists creates a new room unless a room with the same member hash
+// already exists. It reports whether the room was created.
+func (r *Room) CreateIfNotExists(ctx context.Context, room *models.Room) (bool, error) {
+	created := false
+	err := r.store.write(func(st *state) error {
+		if room.MemberHash != nil && st.roomByMemberHash(*room.MemberHash) != nil {
+			return nil
+		}
+		st.insertRoom(room)
+		created = true
+		return nil
+	})
+	return created, err
+}
+
+// FindByMemberHash finds a direct message room by the hash of its participants
+func (r *Room) FindByMemberHash(ctx context.Context, memberHash string) (*models.Room, error) {
+	var room *models.Room
+	err := r.store.read(func(st *state) error {
+		room = st.roomByMemberHash(memberHash)
+		if room == nil {
+			return errNotFound
+		}
+		return nil
+	})
+	return room, err
+}
+
+// AddMember adds a user to a room
+func (r *Room) AddMember(ctx context.Context, roomID, userID, role string) error {
+	return r.store.write(func(st *state) error {
+		if _, ok := st.rooms[roomID]; !ok {
+			return errors.New("insert violates foreign key constraint on room_members.room_id")
+		}
+		if _, ok := st.users[userID]; !ok {
+			return errors.New("insert violates foreign key constraint on room_members.user_id")
+		}
+		if _, ok := st.roomMembers[roomID][userID]; ok {
+			return errors.New("duplicate key value violates unique constraint on room_members")
+		}
+
+		if st.roomMembers[roomID] == nil {
+			st.roomMembers[roomID] = make(map[string]roomMember)
+		}
+		st.roomMembers[roomID][userID] = roomMember{Role: role, JoinedAt: time.Now()}
+		return nil
+	})
+}
+
+// GetRoomMembers gets all members of a room
+func (r *Room) GetRoomMembers(ctx context.Context, roomID string) ([]*models.User, error) {
+	var users []*models.User
+	err := r.store.read(func(st *state) error {
+		for userID := range st.roomMembers[roomID] {
+			if user := st.userPtr(userID); user != nil {
+				users = append(users, user)
+			}
+		}
+		return nil
+	})
+	sortUsersByName(users)
+	return users, err
+}
+
+// Delete deletes a room by ID along with its memberships and messages
+func (r *Room) Delete(ctx context.Context, id string) error {
+	return r.store.write(func(st *state) error {
+		delete(st.rooms, id)
+		delete(st.roomMembers, id)
+		for messageID, message := range st.messages {
+			if message.RoomID == id {
+				delete(st.messages, messageID)
+				delete(st.messageStatus, messageID)
+			}
+		}
+		return nil
+	})
+}
+
+// insertRoom assigns a room its ID and timestamps and stores it
+func (st *state) insertRoom(room *models.Room) {
+	now := time.Now()
+	room.ID = uuid.NewString()
+	room.CreatedAt = now
+	room.UpdatedAt = now
+
+	stored := *room
+	if room.MemberHash != nil {
+		memberHash := *room.MemberHash
+		stored.MemberHash = &memberHash
+	}
+	st.rooms[room.ID] = stored
+}
+
+// roomByMemberHash finds the room with the given member hash
+func (st *state) roomByMemberHash(memberHash string) *models.Room {
+	for _, room := range st.rooms {
+		if room.MemberHash != nil && *room.MemberHash == memberHash {
+			return &room
+		}
+	}
+	return nil
+}
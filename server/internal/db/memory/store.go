@@ -0,0 +1,182 @@
+// internal/db/memory/store.go
+package memory
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/mjxoro/sent/server/internal/models"
+	"github.com/mjxoro/sent/server/internal/repository"
+)
+
+// Store is an in-memory implementation of every repository, intended for tests
+// and for running the API without PostgreSQL. Not-found lookups return
+// sql.ErrNoRows, matching the postgres repositories.
+type Store struct {
+	// mu guards state. Transactions hold it for writing until they finish.
+	mu sync.RWMutex
+
+	state *state
+
+	// inTx is set on the store a transaction's repositories use, whose
+	// operations run with mu already held by WithTx
+	inTx bool
+}
+
+// roomMember represents a user's membership of a room
+type roomMember struct {
+	Role     string
+	JoinedAt time.Time
+}
+
+// state holds every table of the store
+type state struct {
+	users             map[string]models.User
+	rooms             map[string]models.Room
+	roomMembers       map[string]map[string]roomMember // room ID -> user ID -> membership
+	messages          map[string]models.Message
+	messageStatus     map[string]map[string]models.MessageStatus // message ID -> user ID -> status
+	friendships       map[string]models.Friendship
+	dismissals        map[string]map[string]bool // user ID -> dismissed user IDs
+	friendLists       map[string]models.FriendList
+	friendListMembers map[string]map[string]time.Time // list ID -> user ID -> added at
+	refreshTokens     map[string]models.RefreshToken
+}
+
+// NewStore creates a new, empty in-memory store
+func NewStore() *Store {
+	return &Store{
+		state: &state{
+			users:             make(map[string]models.User),
+			rooms:             make(map[string]models.Room),
+			roomMembers:       make(map[string]map[string]roomMember),
+			messages:          make(map[string]models.Message),
+			messageStatus:     make(map[string]map[string]models.MessageStatus),
+			friendships:       make(map[string]models.Friendship),
+			dismissals:        make(map[string]map[string]bool),
+			friendLists:       make(map[string]models.FriendList),
+			friendListMembers: make(map[string]map[string]time.Time),
+			refreshTokens:     make(map[string]models.RefreshToken),
+		},
+	}
+}
+
+// Repositories returns a set of repositories backed by the store
+func (s *Store) Repositories() *repository.Set {
+	return &repository.Set{
+		Users:         &User{store: s},
+		Rooms:         &Room{store: s},
+		Messages:      &Message{store: s},
+		Friendships:   &Friendship{store: s},
+		FriendLists:   &FriendList{store: s},
+		RefreshTokens: &RefreshToken{store: s},
+	}
+}
+
+// WithTx runs fn as a unit of work. The store is locked for the whole
+// transaction, so other operations wait for it to finish, and it is restored to
+// its state from before fn if fn returns an error or panics. fn must only use
+// the repositories it is given.
+func (s *Store) WithTx(ctx context.Context, fn func(tx *repository.Set) error) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot := s.state.clone()
+	defer func() {
+		if p := recover(); p != nil {
+			s.state = snapshot
+			panic(p)
+		}
+	}()
+
+	tx := &Store{state: s.state, inTx: true}
+	if err := fn(tx.Repositories()); err != nil {
+		s.state = snapshot
+		return err
+	}
+
+	return nil
+}
+
+// read runs fn with the store locked for reading
+func (s *Store) read(fn func(st *state) error) error {
+	if s.inTx {
+		return fn(s.state)
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return fn(s.state)
+}
+
+// write runs fn with the store locked for writing
+func (s *Store) write(fn func(st *state) error) error {
+	if s.inTx {
+		return fn(s.state)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return fn(s.state)
+}
+
+// clone makes a deep copy of the state
+func (st *state) clone() *state {
+	return &state{
+		users:             cloneMap(st.users),
+		rooms:             cloneMap(st.rooms),
+		roomMembers:       cloneNestedMap(st.roomMembers),
+		messages:          cloneMap(st.messages),
+		messageStatus:     cloneNestedMap(st.messageStatus),
+		friendships:       cloneMap(st.friendships),
+		dismissals:        cloneNestedMap(st.dismissals),
+		friendLists:       cloneMap(st.friendLists),
+		friendListMembers: cloneNestedMap(st.friendListMembers),
+		refreshTokens:     cloneMap(st.refreshTokens),
+	}
+}
+
+// userPtr returns a copy of a stored user, or nil if it doesn't exist
+func (st *state) userPtr(id string) *models.User {
+	user, ok := st.users[id]
+	if !ok {
+		return nil
+	}
+	return &user
+}
+
+// areFriends reports whether two users have an accepted friendship
+func (st *state) areFriends(userID, otherUserID string) bool {
+	friendship := st.findFriendship(userID, otherUserID)
+	return friendship != nil && friendship.Status == models.FriendshipStatusAccepted
+}
+
+// findFriendship finds the friendship between two users in either direction
+func (st *state) findFriendship(userID, otherUserID string) *models.Friendship {
+	for _, friendship := range st.friendships {
+		if (friendship.UserID == userID && friendship.FriendID == otherUserID) ||
+			(friendship.UserID == otherUserID && friendship.FriendID == userID) {
+			return &friendship
+		}
+	}
+	return nil
+}
+
+func cloneMap[K comparable, V any](m map[K]V) map[K]V {
+	result := make(map[K]V, len(m))
+	for k, v := range m {
+		result[k] = v
+	}
+	return result
+}
+
+func cloneNestedMap[K1, K2 comparable, V any](m map[K1]map[K2]V) map[K1]map[K2]V {
+	result := make(map[K1]map[K2]V, len(m))
+	for k, v := range m {
+		result[k] = cloneMap(v)
+	}
+	return result
+}
+
+// errNotFound matches the error returned by the postgres repositories for missing rows
+var errNotFound = sql.ErrNoRows
@@ -0,0 +1,125 @@
+// internal/db/memory/user.go
+package memory
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mjxoro/sent/server/internal/models"
+	"github.com/mjxoro/sent/server/internal/repository"
+)
+
+var _ repository.User = (*User)(nil)
+
+// User is an in-memory user repository
+type User struct {
+	store *Store
+}
+
+// FindByID finds a user by ID
+func (r *User) FindByID(ctx context.Context, id string) (*models.User, error) {
+	var user *models.User
+	err := r.store.read(func(st *state) error {
+		user = st.userPtr(id)
+		if user == nil {
+			return errNotFound
+		}
+		return nil
+	})
+	return user, err
+}
+
+// FindByIDs finds all users with the given IDs
+func (r *User) FindByIDs(ctx context.Context, ids []string) ([]*models.User, error) {
+	var users []*models.User
+	err := r.store.read(func(st *state) error {
+		seen := make(map[string]bool, len(ids))
+		for _, id := range ids {
+			if user := st.userPtr(id); user != nil && !seen[id] {
+				seen[id] = true
+				users = append(users, user)
+			}
+		}
+		return nil
+	})
+	sortUsersByName(users)
+	return users, err
+}
+
+// FindByEmail finds a user by email
+func (r *User) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	return r.findOne(func(user models.User) bool {
+		return user.Email == email
+	})
+}
+
+// FindByOAuthID finds a user by OAuth ID and provider
+func (r *User) FindByOAuthID(ctx context.Context, oauthID string, provider string) (*models.User, error) {
+	return r.findOne(func(user models.User) bool {
+		return user.OAuthID == oauthID && user.Provider == provider
+	})
+}
+
+// Create creates a new user
+func (r *User) Create(ctx context.Context, user *models.User) error {
+	return r.store.write(func(st *state) error {
+		for _, existing := range st.users {
+			if existing.Email == user.Email {
+				return errors.New("duplicate key value violates unique constraint on users.email")
+			}
+		}
+
+		now := time.Now()
+		user.ID = uuid.NewString()
+		user.CreatedAt = now
+		user.UpdatedAt = now
+		st.users[user.ID] = *user
+		return nil
+	})
+}
+
+// Update updates a user
+func (r *User) Update(ctx context.Context, user *models.User) error {
+	return r.store.write(func(st *state) error {
+		existing, ok := st.users[user.ID]
+		if !ok {
+			return nil
+		}
+
+		user.UpdatedAt = time.Now()
+		existing.Email = user.Email
+		existing.Name = user.Name
+		existing.Avatar = user.Avatar
+		existing.UpdatedAt = user.UpdatedAt
+		st.users[user.ID] = existing
+		return nil
+	})
+}
+
+// findOne finds the first user matching the predicate
+func (r *User) findOne(match func(user models.User) bool) (*models.User, error) {
+	var found *models.User
+	err := r.store.read(func(st *state) error {
+		for _, user := range st.users {
+			if match(user) {
+				found = &user
+				return nil
+			}
+		}
+		return errNotFound
+	})
+	return found, err
+}
+
+// sortUsersByName orders users by name, then ID for stability
+func sortUsersByName(users []*models.User) {
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].Name != users[j].Name {
+			return users[i].Name < users[j].Name
+		}
+		return users[i].ID < users[j].ID
+	})
+}
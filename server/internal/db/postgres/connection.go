@@ -2,14 +2,19 @@
 package postgres
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"time"
 
 	_ "github.com/jackc/pgx/v4/stdlib"
 	"github.com/jmoiron/sqlx"
 	"github.com/mjxoro/sent/server/internal/config"
 )
 
+// queryTimeout bounds how long a single repository operation may run
+const queryTimeout = 5 * time.Second
+
 // DB represents the PostgreSQL database connection
 type DB struct {
 	*sqlx.DB
@@ -47,3 +52,8 @@ func NewDB(cfg *config.Config) (*DB, error) {
 func (db *DB) Close() error {
 	return db.DB.Close()
 }
+
+// withTimeout derives a context bounded by queryTimeout for a single repository operation
+func withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, queryTimeout)
+}
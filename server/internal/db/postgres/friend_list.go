@@ -0,0 +1,160 @@
+// internal/db/postgres/friend_list.go
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/mjxoro/sent/server/internal/models"
+)
+
+// FriendList handles database operations for friend lists
+type FriendList struct {
+	db queryer
+}
+
+// NewFriendList creates a new friend list repository
+func NewFriendList(db *DB) *FriendList {
+	return &FriendList{
+		db: db,
+	}
+}
+
+// Create creates a new friend list
+func (r *FriendList) Create(ctx context.Context, list *models.FriendList) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		INSERT INTO friend_lists (owner_id, name, created_at, updated_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`
+
+	now := time.Now()
+	list.CreatedAt = now
+	list.UpdatedAt = now
+
+	return r.db.QueryRowContext(
+		ctx,
+		query,
+		list.OwnerID,
+		list.Name,
+		list.CreatedAt,
+		list.UpdatedAt,
+	).Scan(&list.ID)
+}
+
+// FindByID finds a friend list by ID
+func (r *FriendList) FindByID(ctx context.Context, id string) (*models.FriendList, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT * FROM friend_lists WHERE id = $1`
+
+	var list models.FriendList
+	err := r.db.GetContext(ctx, &list, query, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &list, nil
+}
+
+// FindByOwnerID finds all friend lists owned by a user
+func (r *FriendList) FindByOwnerID(ctx context.Context, ownerID string) ([]*models.FriendList, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT * FROM friend_lists
+		WHERE owner_id = $1
+		ORDER BY name ASC
+	`
+
+	var lists []*models.FriendList
+	err := r.db.SelectContext(ctx, &lists, query, ownerID)
+	if err != nil {
+		return nil, err
+	}
+
+	return lists, nil
+}
+
+// UpdateName renames a friend list
+func (r *FriendList) UpdateName(ctx context.Context, id, name string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		UPDATE friend_lists
+		SET name = $1, updated_at = $2
+		WHERE id = $3
+	`
+
+	_, err := r.db.ExecContext(ctx, query, name, time.Now(), id)
+	return err
+}
+
+// Delete deletes a friend list
+func (r *FriendList) Delete(ctx context.Context, id string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	query := `DELETE FROM friend_lists WHERE id = $1`
+	_, err := r.db.ExecContext(ctx, query, id)
+	return err
+}
+
+// AddMember adds a user to a friend list
+func (r *FriendList) AddMember(ctx context.Context, listID, userID string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		INSERT INTO friend_list_members (list_id, user_id, added_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (list_id, user_id) DO NOTHING
+	`
+
+	_, err := r.db.ExecContext(ctx, query, listID, userID, time.Now())
+	return err
+}
+
+// RemoveMember removes a user from a friend list
+func (r *FriendList) RemoveMember(ctx context.Context, listID, userID string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	query := `DELETE FROM friend_list_members WHERE list_id = $1 AND user_id = $2`
+	_, err := r.db.ExecContext(ctx, query, listID, userID)
+	return err
+}
+
+// GetMembers gets the members of a friend list who are still accepted friends of the owner
+func (r *FriendList) GetMembers(ctx context.Context, listID string) ([]*models.User, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT u.* FROM users u
+		JOIN friend_list_members flm ON u.id = flm.user_id
+		JOIN friend_lists fl ON fl.id = flm.list_id
+		WHERE flm.list_id = $1
+		AND EXISTS (
+			SELECT 1 FROM friendships f
+			WHERE f.status = 'accepted'
+			AND ((f.user_id = fl.owner_id AND f.friend_id = u.id)
+			OR (f.friend_id = fl.owner_id AND f.user_id = u.id))
+		)
+		ORDER BY u.name ASC
+	`
+
+	var users []*models.User
+	err := r.db.SelectContext(ctx, &users, query, listID)
+	if err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
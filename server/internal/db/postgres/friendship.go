@@ -2,6 +2,7 @@
 package postgres
 
 import (
+	"context"
 	"time"
 
 	"github.com/mjxoro/sent/server/internal/models"
@@ -9,7 +10,7 @@ import (
 
 // Friendship handles database operations for friendships
 type Friendship struct {
-	db *DB
+	db queryer
 }
 
 // NewFriendship creates a new friendship repository
@@ -20,7 +21,10 @@ func NewFriendship(db *DB) *Friendship {
 }
 
 // Create creates a new friendship request
-func (r *Friendship) Create(friendship *models.Friendship) error {
+func (r *Friendship) Create(ctx context.Context, friendship *models.Friendship) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
 	query := `
 		INSERT INTO friendships (user_id, friend_id, status, created_at, updated_at)
 		VALUES ($1, $2, $3, $4, $5)
@@ -31,7 +35,8 @@ func (r *Friendship) Create(friendship *models.Friendship) error {
 	friendship.CreatedAt = now
 	friendship.UpdatedAt = now
 
-	return r.db.QueryRow(
+	return r.db.QueryRowContext(
+		ctx,
 		query,
 		friendship.UserID,
 		friendship.FriendID,
@@ -42,11 +47,31 @@ func (r *Friendship) Create(friendship *models.Friendship) error {
 }
 
 // FindByID finds a friendship by ID
-func (r *Friendship) FindByID(id string) (*models.Friendship, error) {
+func (r *Friendship) FindByID(ctx context.Context, id string) (*models.Friendship, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
 	query := `SELECT * FROM friendships WHERE id = $1`
 
 	var friendship models.Friendship
-	err := r.db.Get(&friendship, query, id)
+	err := r.db.GetContext(ctx, &friendship, query, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &friendship, nil
+}
+
+// FindByIDForUpdate finds a friendship by ID and locks it until the surrounding
+// transaction ends. It must be called through a Tx.
+func (r *Friendship) FindByIDForUpdate(ctx context.Context, id string) (*models.Friendship, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT * FROM friendships WHERE id = $1 FOR UPDATE`
+
+	var friendship models.Friendship
+	err := r.db.GetContext(ctx, &friendship, query, id)
 	if err != nil {
 		return nil, err
 	}
@@ -55,14 +80,17 @@ func (r *Friendship) FindByID(id string) (*models.Friendship, error) {
 }
 
 // FindByUserAndFriend finds a friendship between two users
-func (r *Friendship) FindByUserAndFriend(userID, friendID string) (*models.Friendship, error) {
+func (r *Friendship) FindByUserAndFriend(ctx context.Context, userID, friendID string) (*models.Friendship, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
 	query := `
 		SELECT * FROM friendships 
 		WHERE (user_id = $1 AND friend_id = $2) OR (user_id = $2 AND friend_id = $1)
 	`
 
 	var friendship models.Friendship
-	err := r.db.Get(&friendship, query, userID, friendID)
+	err := r.db.GetContext(ctx, &friendship, query, userID, friendID)
 	if err != nil {
 		return nil, err
 	}
@@ -71,7 +99,10 @@ func (r *Friendship) FindByUserAndFriend(userID, friendID string) (*models.Frien
 }
 
 // FindFriendsByUserID finds all friends of a user with specified status
-func (r *Friendship) FindFriendsByUserID(userID string, status models.FriendshipStatus) ([]*models.FriendshipWithUser, error) {
+func (r *Friendship) FindFriendsByUserID(ctx context.Context, userID string, status models.FriendshipStatus) ([]*models.FriendshipWithUser, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
 	query := `
 		SELECT 
 			f.id, f.user_id, f.friend_id, f.status, f.created_at, f.updated_at,
@@ -88,7 +119,7 @@ func (r *Friendship) FindFriendsByUserID(userID string, status models.Friendship
 	`
 
 	var friends []*models.FriendshipWithUser
-	err := r.db.Select(&friends, query, userID, status)
+	err := r.db.SelectContext(ctx, &friends, query, userID, status)
 	if err != nil {
 		return nil, err
 	}
@@ -97,7 +128,10 @@ func (r *Friendship) FindFriendsByUserID(userID string, status models.Friendship
 }
 
 // FindAllUserRelationships finds all friendship relationships for a user
-func (r *Friendship) FindAllUserRelationships(userID string) ([]*models.FriendshipWithUser, error) {
+func (r *Friendship) FindAllUserRelationships(ctx context.Context, userID string) ([]*models.FriendshipWithUser, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
 	query := `
 		SELECT 
 			f.id, f.user_id, f.friend_id, f.status, f.created_at, f.updated_at,
@@ -114,7 +148,7 @@ func (r *Friendship) FindAllUserRelationships(userID string) ([]*models.Friendsh
 	`
 
 	var friends []*models.FriendshipWithUser
-	err := r.db.Select(&friends, query, userID)
+	err := r.db.SelectContext(ctx, &friends, query, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -123,7 +157,10 @@ func (r *Friendship) FindAllUserRelationships(userID string) ([]*models.Friendsh
 }
 
 // FindPendingRequests finds all pending friend requests for a user
-func (r *Friendship) FindPendingRequests(userID string) ([]*models.FriendshipWithUser, error) {
+func (r *Friendship) FindPendingRequests(ctx context.Context, userID string) ([]*models.FriendshipWithUser, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
 	query := `
 		SELECT 
 			f.id, f.user_id, f.friend_id, f.status, f.created_at, f.updated_at,
@@ -135,7 +172,7 @@ func (r *Friendship) FindPendingRequests(userID string) ([]*models.FriendshipWit
 	`
 
 	var requests []*models.FriendshipWithUser
-	err := r.db.Select(&requests, query, userID)
+	err := r.db.SelectContext(ctx, &requests, query, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -144,7 +181,10 @@ func (r *Friendship) FindPendingRequests(userID string) ([]*models.FriendshipWit
 }
 
 // UpdateStatus updates the status of a friendship
-func (r *Friendship) UpdateStatus(id string, status models.FriendshipStatus) error {
+func (r *Friendship) UpdateStatus(ctx context.Context, id string, status models.FriendshipStatus) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
 	query := `
 		UPDATE friendships
 		SET status = $1, updated_at = $2
@@ -153,36 +193,95 @@ func (r *Friendship) UpdateStatus(id string, status models.FriendshipStatus) err
 
 	now := time.Now()
 
-	_, err := r.db.Exec(query, status, now, id)
+	_, err := r.db.ExecContext(ctx, query, status, now, id)
 	return err
 }
 
 // Delete deletes a friendship
-func (r *Friendship) Delete(id string) error {
+func (r *Friendship) Delete(ctx context.Context, id string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
 	query := `DELETE FROM friendships WHERE id = $1`
-	_, err := r.db.Exec(query, id)
+	_, err := r.db.ExecContext(ctx, query, id)
 	return err
 }
 
-// FindNonFriends finds users who are not friends with the specified user
-func (r *Friendship) FindNonFriends(userID string, limit, offset int) ([]*models.User, error) {
+// FindSuggestions finds users who are not connected to the specified user, ranked by
+// mutual friends and then by shared group rooms. Any existing relationship (including
+// blocks in either direction) and dismissed suggestions are excluded.
+func (r *Friendship) FindSuggestions(ctx context.Context, userID string, limit, offset int) ([]*models.FriendSuggestion, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
 	query := `
-		SELECT u.* FROM users u
+		WITH my_friends AS (
+			SELECT CASE WHEN f.user_id = $1 THEN f.friend_id ELSE f.user_id END AS id
+			FROM friendships f
+			WHERE (f.user_id = $1 OR f.friend_id = $1) AND f.status = 'accepted'
+		),
+		mutuals AS (
+			SELECT
+				CASE WHEN f.user_id = mf.id THEN f.friend_id ELSE f.user_id END AS candidate_id,
+				COUNT(*) AS mutual_friends
+			FROM friendships f
+			JOIN my_friends mf ON f.user_id = mf.id OR f.friend_id = mf.id
+			WHERE f.status = 'accepted'
+			GROUP BY candidate_id
+		),
+		shared AS (
+			SELECT
+				rm2.user_id AS candidate_id,
+				COUNT(DISTINCT r.id) AS shared_rooms,
+				(ARRAY_AGG(r.name ORDER BY r.updated_at DESC))[1] AS shared_room_name
+			FROM room_members rm1
+			JOIN room_members rm2 ON rm1.room_id = rm2.room_id AND rm2.user_id != $1
+			JOIN rooms r ON r.id = rm1.room_id
+			WHERE rm1.user_id = $1 AND r.type = 'group'
+			GROUP BY rm2.user_id
+		)
+		SELECT
+			u.*,
+			COALESCE(m.mutual_friends, 0) AS mutual_friends,
+			COALESCE(s.shared_rooms, 0) AS shared_rooms,
+			COALESCE(s.shared_room_name, '') AS shared_room_name
+		FROM users u
+		LEFT JOIN mutuals m ON m.candidate_id = u.id
+		LEFT JOIN shared s ON s.candidate_id = u.id
 		WHERE u.id != $1
 		AND NOT EXISTS (
 			SELECT 1 FROM friendships f
-			WHERE (f.user_id = $1 AND f.friend_id = u.id) 
+			WHERE (f.user_id = $1 AND f.friend_id = u.id)
 			OR (f.friend_id = $1 AND f.user_id = u.id)
 		)
-		ORDER BY u.name ASC
+		AND NOT EXISTS (
+			SELECT 1 FROM friend_suggestion_dismissals d
+			WHERE d.user_id = $1 AND d.suggested_user_id = u.id
+		)
+		ORDER BY mutual_friends DESC, shared_rooms DESC, u.name ASC
 		LIMIT $2 OFFSET $3
 	`
 
-	var users []*models.User
-	err := r.db.Select(&users, query, userID, limit, offset)
+	var suggestions []*models.FriendSuggestion
+	err := r.db.SelectContext(ctx, &suggestions, query, userID, limit, offset)
 	if err != nil {
 		return nil, err
 	}
 
-	return users, nil
+	return suggestions, nil
+}
+
+// DismissSuggestion hides a user from another user's friend suggestions
+func (r *Friendship) DismissSuggestion(ctx context.Context, userID, suggestedUserID string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		INSERT INTO friend_suggestion_dismissals (user_id, suggested_user_id, created_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, suggested_user_id) DO NOTHING
+	`
+
+	_, err := r.db.ExecContext(ctx, query, userID, suggestedUserID, time.Now())
+	return err
 }
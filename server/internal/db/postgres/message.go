@@ -2,13 +2,16 @@
 package postgres
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"github.com/mjxoro/sent/server/internal/models"
 	"time"
 )
 
 // Message handles database operations for messages
 type Message struct {
-	db *DB
+	db queryer
 }
 
 // NewMessage creates a new message repository
@@ -19,10 +22,13 @@ func NewMessage(db *DB) *Message {
 }
 
 // Create creates a new message
-func (r *Message) Create(message *models.Message) error {
+func (r *Message) Create(ctx context.Context, message *models.Message) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
 	query := `
-		INSERT INTO messages (room_id, user_id, content, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5)
+		INSERT INTO messages (room_id, user_id, content, client_msg_id, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
 		RETURNING id
 	`
 
@@ -30,19 +36,77 @@ func (r *Message) Create(message *models.Message) error {
 	message.CreatedAt = now
 	message.UpdatedAt = now
 
-	return r.db.QueryRow(
+	return r.db.QueryRowContext(
+		ctx,
 		query,
 		message.RoomID,
 		message.UserID,
 		message.Content,
+		message.ClientMsgID,
 		message.CreatedAt,
 		message.UpdatedAt,
 	).Scan(&message.ID)
 }
 
+// CreateIfNotExists creates a new message unless the user already sent a message
+// with the same client message ID. It reports whether the message was created.
+func (r *Message) CreateIfNotExists(ctx context.Context, message *models.Message) (bool, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		INSERT INTO messages (room_id, user_id, content, client_msg_id, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (user_id, client_msg_id) WHERE client_msg_id IS NOT NULL DO NOTHING
+		RETURNING id
+	`
+
+	now := time.Now()
+	message.CreatedAt = now
+	message.UpdatedAt = now
+
+	err := r.db.QueryRowContext(
+		ctx,
+		query,
+		message.RoomID,
+		message.UserID,
+		message.Content,
+		message.ClientMsgID,
+		message.CreatedAt,
+		message.UpdatedAt,
+	).Scan(&message.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// FindByClientMsgID finds a message by its sender and client message ID
+func (r *Message) FindByClientMsgID(ctx context.Context, userID, clientMsgID string) (*models.Message, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT * FROM messages WHERE user_id = $1 AND client_msg_id = $2`
+
+	var message models.Message
+	err := r.db.GetContext(ctx, &message, query, userID, clientMsgID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &message, nil
+}
+
 // FindByRoomID finds messages in a room with pagination
 // Now returns MessageDTO with user information and in chronological order (oldest first)
-func (r *Message) FindByRoomID(roomID string, limit, offset int) ([]*models.MessageDTO, error) {
+func (r *Message) FindByRoomID(ctx context.Context, roomID string, limit, offset int) ([]*models.MessageDTO, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
 	query := `
 		SELECT m.id, m.room_id, m.user_id, m.content, m.created_at, m.updated_at,
 		       u.name as user_name, u.avatar as user_avatar
@@ -54,7 +118,7 @@ func (r *Message) FindByRoomID(roomID string, limit, offset int) ([]*models.Mess
 	`
 
 	var messages []*models.MessageDTO
-	err := r.db.Select(&messages, query, roomID, limit, offset)
+	err := r.db.SelectContext(ctx, &messages, query, roomID, limit, offset)
 	if err != nil {
 		return nil, err
 	}
@@ -63,11 +127,14 @@ func (r *Message) FindByRoomID(roomID string, limit, offset int) ([]*models.Mess
 }
 
 // FindByID finds a message by ID
-func (r *Message) FindByID(id string) (*models.Message, error) {
+func (r *Message) FindByID(ctx context.Context, id string) (*models.Message, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
 	query := `SELECT * FROM messages WHERE id = $1`
 
 	var message models.Message
-	err := r.db.Get(&message, query, id)
+	err := r.db.GetContext(ctx, &message, query, id)
 	if err != nil {
 		return nil, err
 	}
@@ -76,7 +143,10 @@ func (r *Message) FindByID(id string) (*models.Message, error) {
 }
 
 // MarkAsRead marks a message as read by a user
-func (r *Message) MarkAsRead(messageID, userID string) error {
+func (r *Message) MarkAsRead(ctx context.Context, messageID, userID string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
 	query := `
 		INSERT INTO message_status (message_id, user_id, is_read, read_at)
 		VALUES ($1, $2, true, $3)
@@ -86,12 +156,15 @@ func (r *Message) MarkAsRead(messageID, userID string) error {
 
 	now := time.Now()
 
-	_, err := r.db.Exec(query, messageID, userID, now)
+	_, err := r.db.ExecContext(ctx, query, messageID, userID, now)
 	return err
 }
 
 // GetUnreadCount gets the count of unread messages in a room for a user
-func (r *Message) GetUnreadCount(roomID, userID string) (int, error) {
+func (r *Message) GetUnreadCount(ctx context.Context, roomID, userID string) (int, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
 	query := `
 		SELECT COUNT(m.id)
 		FROM messages m
@@ -101,7 +174,7 @@ func (r *Message) GetUnreadCount(roomID, userID string) (int, error) {
 	`
 
 	var count int
-	err := r.db.QueryRow(query, roomID, userID).Scan(&count)
+	err := r.db.QueryRowContext(ctx, query, roomID, userID).Scan(&count)
 	if err != nil {
 		return 0, err
 	}
@@ -2,12 +2,15 @@
 package postgres
 
 import (
+	"context"
 	"time"
+
+	"github.com/mjxoro/sent/server/internal/models"
 )
 
 // RefreshToken handles database operations for refresh tokens
 type RefreshToken struct {
-	db *DB
+	db queryer
 }
 
 // NewRefreshToken creates a new refresh token repository
@@ -17,50 +20,119 @@ func NewRefreshToken(db *DB) *RefreshToken {
 	}
 }
 
-// Store stores a refresh token for a user
-func (r *RefreshToken) Store(userID, token string, expiresAt time.Time) error {
+// Create stores a refresh token's hash
+func (r *RefreshToken) Create(ctx context.Context, token *models.RefreshToken) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
 	query := `
-		INSERT INTO refresh_tokens (user_id, token, expires_at)
-		VALUES ($1, $2, $3)
+		INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
 	`
-	_, err := r.db.Exec(query, userID, token, expiresAt)
-	return err
+
+	token.CreatedAt = time.Now()
+
+	return r.db.QueryRowContext(
+		ctx,
+		query,
+		token.UserID,
+		token.FamilyID,
+		token.TokenHash,
+		token.ExpiresAt,
+		token.CreatedAt,
+	).Scan(&token.ID)
 }
 
-// Validate checks if a refresh token is valid for a user
-func (r *RefreshToken) Validate(userID, token string) (bool, error) {
+// GetByHash finds a refresh token by its hash
+func (r *RefreshToken) GetByHash(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
 	query := `
-		SELECT EXISTS(
-			SELECT 1 FROM refresh_tokens 
-			WHERE user_id = $1 
-			AND token = $2 
-			AND expires_at > NOW() 
-			AND is_revoked = false
-		)
+		SELECT id, user_id, family_id, token_hash, expires_at, is_revoked, rotated_at, created_at
+		FROM refresh_tokens
+		WHERE token_hash = $1
 	`
-	var exists bool
-	err := r.db.QueryRow(query, userID, token).Scan(&exists)
-	return exists, err
+
+	var token models.RefreshToken
+	if err := r.db.GetContext(ctx, &token, query, tokenHash); err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// MarkRotated records that a refresh token was exchanged for a new one. Only one
+// of several concurrent rotations of the same token succeeds.
+func (r *RefreshToken) MarkRotated(ctx context.Context, id string) (bool, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		UPDATE refresh_tokens
+		SET rotated_at = NOW()
+		WHERE id = $1 AND rotated_at IS NULL AND is_revoked = false
+	`
+	result, err := r.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	return rows == 1, err
 }
 
 // Revoke marks a refresh token as revoked
-func (r *RefreshToken) Revoke(userID, token string) error {
+func (r *RefreshToken) Revoke(ctx context.Context, userID, tokenHash string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
 	query := `
 		UPDATE refresh_tokens
 		SET is_revoked = true
-		WHERE user_id = $1 AND token = $2
+		WHERE user_id = $1 AND token_hash = $2
 	`
-	_, err := r.db.Exec(query, userID, token)
+	_, err := r.db.ExecContext(ctx, query, userID, tokenHash)
+	return err
+}
+
+// RevokeFamily revokes every refresh token in a family
+func (r *RefreshToken) RevokeFamily(ctx context.Context, familyID string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		UPDATE refresh_tokens
+		SET is_revoked = true
+		WHERE family_id = $1
+	`
+	_, err := r.db.ExecContext(ctx, query, familyID)
 	return err
 }
 
 // RevokeAllForUser revokes all refresh tokens for a user
-func (r *RefreshToken) RevokeAllForUser(userID string) error {
+func (r *RefreshToken) RevokeAllForUser(ctx context.Context, userID string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
 	query := `
 		UPDATE refresh_tokens
 		SET is_revoked = true
 		WHERE user_id = $1
 	`
-	_, err := r.db.Exec(query, userID)
+	_, err := r.db.ExecContext(ctx, query, userID)
 	return err
 }
+
+// DeleteExpired deletes refresh tokens that have expired, returning how many were deleted
+func (r *RefreshToken) DeleteExpired(ctx context.Context) (int64, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	result, err := r.db.ExecContext(ctx, `DELETE FROM refresh_tokens WHERE expires_at <= NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
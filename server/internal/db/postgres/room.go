@@ -2,6 +2,9 @@
 package postgres
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"time"
 
 	"github.com/mjxoro/sent/server/internal/models"
@@ -9,7 +12,7 @@ import (
 
 // Room handles database operations for rooms
 type Room struct {
-	db *DB
+	db queryer
 }
 
 // NewRoom creates a new room repository
@@ -20,11 +23,14 @@ func NewRoom(db *DB) *Room {
 }
 
 // FindByID finds a room by ID
-func (r *Room) FindByID(id string) (*models.Room, error) {
+func (r *Room) FindByID(ctx context.Context, id string) (*models.Room, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
 	query := `SELECT * FROM rooms WHERE id = $1`
 
 	var room models.Room
-	err := r.db.Get(&room, query, id)
+	err := r.db.GetContext(ctx, &room, query, id)
 	if err != nil {
 		return nil, err
 	}
@@ -33,7 +39,10 @@ func (r *Room) FindByID(id string) (*models.Room, error) {
 }
 
 // FindRoomsByUserID finds all rooms a user is a member of
-func (r *Room) FindRoomsByUserID(userID string) ([]*models.Room, error) {
+func (r *Room) FindRoomsByUserID(ctx context.Context, userID string) ([]*models.Room, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
 	query := `
 		SELECT r.* FROM rooms r
 		JOIN room_members rm ON r.id = rm.room_id
@@ -42,7 +51,7 @@ func (r *Room) FindRoomsByUserID(userID string) ([]*models.Room, error) {
 	`
 
 	var rooms []*models.Room
-	err := r.db.Select(&rooms, query, userID)
+	err := r.db.SelectContext(ctx, &rooms, query, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -51,10 +60,13 @@ func (r *Room) FindRoomsByUserID(userID string) ([]*models.Room, error) {
 }
 
 // Create creates a new room
-func (r *Room) Create(room *models.Room) error {
+func (r *Room) Create(ctx context.Context, room *models.Room) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
 	query := `
-		INSERT INTO rooms (name, description, creator_id, is_private, type, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		INSERT INTO rooms (name, description, creator_id, is_private, type, member_hash, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 		RETURNING id
 	`
 
@@ -62,32 +74,68 @@ func (r *Room) Create(room *models.Room) error {
 	room.CreatedAt = now
 	room.UpdatedAt = now
 
-	return r.db.QueryRow(
+	return r.db.QueryRowContext(
+		ctx,
 		query,
 		room.Name,
 		room.Description,
 		room.CreatorID,
 		room.IsPrivate,
 		room.Type,
+		room.MemberHash,
 		room.CreatedAt,
 		room.UpdatedAt,
 	).Scan(&room.ID)
 }
 
-// FindDMRoom finds a direct message room between two users
-func (r *Room) FindDMRoom(user1ID, user2ID string) (*models.Room, error) {
+// CreateIfNotExists creates a new room unless a room with the same member hash
+// already exists. It reports whether the room was created.
+func (r *Room) CreateIfNotExists(ctx context.Context, room *models.Room) (bool, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
 	query := `
-		SELECT r.* FROM rooms r
-		JOIN room_members rm1 ON r.id = rm1.room_id
-		JOIN room_members rm2 ON r.id = rm2.room_id
-		WHERE r.type = 'direct'
-		AND rm1.user_id = $1
-		AND rm2.user_id = $2
-		LIMIT 1
+		INSERT INTO rooms (name, description, creator_id, is_private, type, member_hash, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (member_hash) WHERE member_hash IS NOT NULL DO NOTHING
+		RETURNING id
 	`
 
+	now := time.Now()
+	room.CreatedAt = now
+	room.UpdatedAt = now
+
+	err := r.db.QueryRowContext(
+		ctx,
+		query,
+		room.Name,
+		room.Description,
+		room.CreatorID,
+		room.IsPrivate,
+		room.Type,
+		room.MemberHash,
+		room.CreatedAt,
+		room.UpdatedAt,
+	).Scan(&room.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// FindByMemberHash finds a direct message room by the hash of its participants
+func (r *Room) FindByMemberHash(ctx context.Context, memberHash string) (*models.Room, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT * FROM rooms WHERE member_hash = $1 LIMIT 1`
+
 	var room models.Room
-	err := r.db.Get(&room, query, user1ID, user2ID)
+	err := r.db.GetContext(ctx, &room, query, memberHash)
 	if err != nil {
 		return nil, err
 	}
@@ -96,7 +144,10 @@ func (r *Room) FindDMRoom(user1ID, user2ID string) (*models.Room, error) {
 }
 
 // AddMember adds a user to a room
-func (r *Room) AddMember(roomID, userID, role string) error {
+func (r *Room) AddMember(ctx context.Context, roomID, userID, role string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
 	query := `
 		INSERT INTO room_members (room_id, user_id, role, joined_at, created_at, updated_at)
 		VALUES ($1, $2, $3, $4, $5, $6)
@@ -104,7 +155,8 @@ func (r *Room) AddMember(roomID, userID, role string) error {
 
 	now := time.Now()
 
-	_, err := r.db.Exec(
+	_, err := r.db.ExecContext(
+		ctx,
 		query,
 		roomID,
 		userID,
@@ -118,7 +170,10 @@ func (r *Room) AddMember(roomID, userID, role string) error {
 }
 
 // GetRoomMembers gets all members of a room
-func (r *Room) GetRoomMembers(roomID string) ([]*models.User, error) {
+func (r *Room) GetRoomMembers(ctx context.Context, roomID string) ([]*models.User, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
 	query := `
 		SELECT u.* FROM users u
 		JOIN room_members rm ON u.id = rm.user_id
@@ -126,7 +181,7 @@ func (r *Room) GetRoomMembers(roomID string) ([]*models.User, error) {
 	`
 
 	var users []*models.User
-	err := r.db.Select(&users, query, roomID)
+	err := r.db.SelectContext(ctx, &users, query, roomID)
 	if err != nil {
 		return nil, err
 	}
@@ -135,8 +190,11 @@ func (r *Room) GetRoomMembers(roomID string) ([]*models.User, error) {
 }
 
 // Delete deletes a room by ID
-func (r *Room) Delete(id string) error {
+func (r *Room) Delete(ctx context.Context, id string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
 	query := `DELETE FROM rooms WHERE id = $1`
-	_, err := r.db.Exec(query, id)
+	_, err := r.db.ExecContext(ctx, query, id)
 	return err
 }
@@ -0,0 +1,67 @@
+// internal/db/postgres/tx.go
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+
+	"github.com/mjxoro/sent/server/internal/repository"
+)
+
+// queryer is implemented by both *DB and *sqlx.Tx, so repositories can run
+// their statements either directly or inside a transaction
+type queryer interface {
+	GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// NewRepositories creates a set of repositories that run directly against the database
+func NewRepositories(db *DB) *repository.Set {
+	return newRepositories(db)
+}
+
+// newRepositories creates a set of repositories that run against q
+func newRepositories(q queryer) *repository.Set {
+	return &repository.Set{
+		Users:         &User{db: q},
+		Rooms:         &Room{db: q},
+		Messages:      &Message{db: q},
+		Friendships:   &Friendship{db: q},
+		FriendLists:   &FriendList{db: q},
+		RefreshTokens: &RefreshToken{db: q},
+	}
+}
+
+// WithTx runs fn inside a transaction, passing it repositories bound to that
+// transaction. The transaction is committed if fn returns nil and rolled back
+// if it returns an error or panics.
+func (db *DB) WithTx(ctx context.Context, fn func(tx *repository.Set) error) error {
+	sqlTx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			sqlTx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(newRepositories(sqlTx)); err != nil {
+		if rbErr := sqlTx.Rollback(); rbErr != nil {
+			log.Printf("Failed to roll back transaction: %v", rbErr)
+		}
+		return err
+	}
+
+	if err := sqlTx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
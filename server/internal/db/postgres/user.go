@@ -2,13 +2,14 @@
 package postgres
 
 import (
+	"context"
 	"github.com/mjxoro/sent/server/internal/models"
 	"time"
 )
 
 // User handles database operations for users
 type User struct {
-	db *DB
+	db queryer
 }
 
 // NewUser creates a new user
@@ -19,21 +20,41 @@ func NewUser(db *DB) *User {
 }
 
 // FindByID finds a user by ID
-func (r *User) FindByID(id string) (*models.User, error) {
+func (r *User) FindByID(ctx context.Context, id string) (*models.User, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
 	query := `SELECT * FROM users WHERE id = $1`
 	var user models.User
-	err := r.db.Get(&user, query, id)
+	err := r.db.GetContext(ctx, &user, query, id)
 	if err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
 
+// FindByIDs finds all users with the given IDs
+func (r *User) FindByIDs(ctx context.Context, ids []string) ([]*models.User, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT * FROM users WHERE id = ANY($1) ORDER BY name ASC`
+	var users []*models.User
+	err := r.db.SelectContext(ctx, &users, query, ids)
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
 // FindByEmail finds a user by email
-func (r *User) FindByEmail(email string) (*models.User, error) {
+func (r *User) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
 	query := `SELECT * FROM users WHERE email = $1`
 	var user models.User
-	err := r.db.Get(&user, query, email)
+	err := r.db.GetContext(ctx, &user, query, email)
 	if err != nil {
 		return nil, err
 	}
@@ -41,10 +62,13 @@ func (r *User) FindByEmail(email string) (*models.User, error) {
 }
 
 // FindByOAuthID finds a user by OAuth ID and provider
-func (r *User) FindByOAuthID(oauthID string, provider string) (*models.User, error) {
+func (r *User) FindByOAuthID(ctx context.Context, oauthID string, provider string) (*models.User, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
 	query := `SELECT * FROM users WHERE oauth_id = $1 AND provider = $2`
 	var user models.User
-	err := r.db.Get(&user, query, oauthID, provider)
+	err := r.db.GetContext(ctx, &user, query, oauthID, provider)
 	if err != nil {
 		return nil, err
 	}
@@ -52,7 +76,10 @@ func (r *User) FindByOAuthID(oauthID string, provider string) (*models.User, err
 }
 
 // Create creates a new user
-func (r *User) Create(user *models.User) error {
+func (r *User) Create(ctx context.Context, user *models.User) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
 	query := `
 		INSERT INTO users (email, name, oauth_id, provider, avatar, created_at, updated_at)
 		VALUES ($1, $2, $3, $4, $5, $6, $7)
@@ -61,7 +88,8 @@ func (r *User) Create(user *models.User) error {
 	now := time.Now()
 	user.CreatedAt = now
 	user.UpdatedAt = now
-	return r.db.QueryRow(
+	return r.db.QueryRowContext(
+		ctx,
 		query,
 		user.Email,
 		user.Name,
@@ -74,14 +102,18 @@ func (r *User) Create(user *models.User) error {
 }
 
 // Update updates a user
-func (r *User) Update(user *models.User) error {
+func (r *User) Update(ctx context.Context, user *models.User) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
 	query := `
 		UPDATE users
 		SET email = $1, name = $2, avatar = $3, updated_at = $4
 		WHERE id = $5
 	`
 	user.UpdatedAt = time.Now()
-	_, err := r.db.Exec(
+	_, err := r.db.ExecContext(
+		ctx,
 		query,
 		user.Email,
 		user.Name,
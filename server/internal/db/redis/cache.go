@@ -4,7 +4,11 @@ package redis
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/mjxoro/sent/server/internal/repository"
 )
 
 // Cache handles Redis caching operations
@@ -20,8 +24,9 @@ func NewCache(client *Client) *Cache {
 }
 
 // Set stores a value in the cache with an expiration time
-func (c *Cache) Set(key string, value interface{}, expiration time.Duration) error {
-	ctx := context.Background()
+func (c *Cache) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
 
 	// Convert value to JSON
 	jsonData, err := json.Marshal(value)
@@ -34,35 +39,61 @@ func (c *Cache) Set(key string, value interface{}, expiration time.Duration) err
 }
 
 // Get retrieves a value from the cache and unmarshals it into the result
-func (c *Cache) Get(key string, result interface{}) error {
-	ctx := context.Background()
+func (c *Cache) Get(ctx context.Context, key string, result interface{}) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
 
 	// Get from Redis
 	data, err := c.client.Get(ctx, key).Bytes()
 	if err != nil {
-		return err
+		return cacheError(err)
 	}
 
 	// Unmarshal into result
 	return json.Unmarshal(data, result)
 }
 
+// Take retrieves a value and removes it from the cache in one command, so a
+// value is only ever returned once
+func (c *Cache) Take(ctx context.Context, key string, result interface{}) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	data, err := c.client.GetDel(ctx, key).Bytes()
+	if err != nil {
+		return cacheError(err)
+	}
+
+	return json.Unmarshal(data, result)
+}
+
+// cacheError maps Redis's missing key error to repository.ErrCacheMiss
+func cacheError(err error) error {
+	if errors.Is(err, redis.Nil) {
+		return repository.ErrCacheMiss
+	}
+	return err
+}
+
 // Delete removes a key from the cache
-func (c *Cache) Delete(key string) error {
-	ctx := context.Background()
+func (c *Cache) Delete(ctx context.Context, key string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
 	return c.client.Del(ctx, key).Err()
 }
 
 // Exists checks if a key exists in the cache
-func (c *Cache) Exists(key string) (bool, error) {
-	ctx := context.Background()
+func (c *Cache) Exists(ctx context.Context, key string) (bool, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
 	result, err := c.client.Exists(ctx, key).Result()
 	return result > 0, err
 }
 
 // GetUserOnlineStatus gets the online status of users
-func (c *Cache) GetUserOnlineStatus(userIDs []string) (map[string]bool, error) {
-	ctx := context.Background()
+func (c *Cache) GetUserOnlineStatus(ctx context.Context, userIDs []string) (map[string]bool, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
 	result := make(map[string]bool)
 
 	for _, userID := range userIDs {
@@ -78,15 +109,17 @@ func (c *Cache) GetUserOnlineStatus(userIDs []string) (map[string]bool, error) {
 }
 
 // SetUserOnline marks a user as online with a TTL
-func (c *Cache) SetUserOnline(userID string, duration time.Duration) error {
-	ctx := context.Background()
+func (c *Cache) SetUserOnline(ctx context.Context, userID string, duration time.Duration) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
 	key := "user:online:" + userID
 	return c.client.Set(ctx, key, "1", duration).Err()
 }
 
 // GetUnreadMessageCount gets the cached unread message count for a user in a room
-func (c *Cache) GetUnreadMessageCount(userID, roomID string) (int, error) {
-	ctx := context.Background()
+func (c *Cache) GetUnreadMessageCount(ctx context.Context, userID, roomID string) (int, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
 	key := "unread:" + userID + ":" + roomID
 
 	count, err := c.client.Get(ctx, key).Int()
@@ -98,16 +131,18 @@ func (c *Cache) GetUnreadMessageCount(userID, roomID string) (int, error) {
 }
 
 // IncrementUnreadCount increments the unread message count for a user in a room
-func (c *Cache) IncrementUnreadCount(userID, roomID string) error {
-	ctx := context.Background()
+func (c *Cache) IncrementUnreadCount(ctx context.Context, userID, roomID string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
 	key := "unread:" + userID + ":" + roomID
 
 	return c.client.Incr(ctx, key).Err()
 }
 
 // ResetUnreadCount resets the unread message count for a user in a room
-func (c *Cache) ResetUnreadCount(userID, roomID string) error {
-	ctx := context.Background()
+func (c *Cache) ResetUnreadCount(ctx context.Context, userID, roomID string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
 	key := "unread:" + userID + ":" + roomID
 
 	return c.client.Set(ctx, key, 0, 0).Err()
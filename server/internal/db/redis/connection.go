@@ -5,11 +5,15 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/mjxoro/sent/server/internal/config"
 )
 
+// commandTimeout bounds how long a single Redis operation may run
+const commandTimeout = 2 * time.Second
+
 // Client represents the Redis client
 type Client struct {
 	*redis.Client
@@ -38,3 +42,8 @@ func NewClient(cfg *config.Config) (*Client, error) {
 func (c *Client) Close() error {
 	return c.Client.Close()
 }
+
+// withTimeout derives a context bounded by commandTimeout for a single Redis operation
+func withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, commandTimeout)
+}
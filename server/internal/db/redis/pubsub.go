@@ -20,8 +20,9 @@ func NewPubSub(client *Client) *PubSub {
 }
 
 // PublishMessage publishes a message to a channel
-func (ps *PubSub) PublishMessage(channel string, message interface{}) error {
-	ctx := context.Background()
+func (ps *PubSub) PublishMessage(ctx context.Context, channel string, message interface{}) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
 
 	// Convert message to JSON
 	jsonData, err := json.Marshal(message)
@@ -33,9 +34,8 @@ func (ps *PubSub) PublishMessage(channel string, message interface{}) error {
 	return ps.client.Publish(ctx, channel, jsonData).Err()
 }
 
-// Subscribe subscribes to a channel and handles incoming messages
-func (ps *PubSub) Subscribe(channel string, handler func([]byte)) {
-	ctx := context.Background()
+// Subscribe subscribes to a channel and handles incoming messages until ctx is cancelled
+func (ps *PubSub) Subscribe(ctx context.Context, channel string, handler func([]byte)) {
 
 	// Subscribe to the channel
 	pubsub := ps.client.Subscribe(ctx, channel)
@@ -46,14 +46,21 @@ func (ps *PubSub) Subscribe(channel string, handler func([]byte)) {
 
 	log.Printf("Subscribed to Redis channel: %s", channel)
 
-	for msg := range ch {
-		handler([]byte(msg.Payload))
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			handler([]byte(msg.Payload))
+		}
 	}
 }
 
-// SubscribeToRooms subscribes to multiple room channels
-func (ps *PubSub) SubscribeToRooms(roomIDs []string, handler func(string, []byte)) {
-	ctx := context.Background()
+// SubscribeToRooms subscribes to multiple room channels until ctx is cancelled
+func (ps *PubSub) SubscribeToRooms(ctx context.Context, roomIDs []string, handler func(string, []byte)) {
 
 	channels := make([]string, len(roomIDs))
 	for i, roomID := range roomIDs {
@@ -69,11 +76,20 @@ func (ps *PubSub) SubscribeToRooms(roomIDs []string, handler func(string, []byte
 
 	log.Printf("Subscribed to %d Redis channels", len(channels))
 
-	for msg := range ch {
-		// Extract room ID from channel
-		roomChannel := msg.Channel
-		roomID := roomChannel[10:] // Skip "chat:room:"
-
-		handler(roomID, []byte(msg.Payload))
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+
+			// Extract room ID from channel
+			roomChannel := msg.Channel
+			roomID := roomChannel[10:] // Skip "chat:room:"
+
+			handler(roomID, []byte(msg.Payload))
+		}
 	}
 }
@@ -0,0 +1,65 @@
+// internal/db/redis/rate_limiter.go
+package redis
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// tokenBucket takes a token from the bucket at KEYS[1], which holds ARGV[1]
+// tokens and refills over ARGV[2] milliseconds. It uses the Redis server's clock,
+// so instances with skewed clocks agree. It returns whether a token was taken and,
+// if not, how many milliseconds until one is available.
+var tokenBucket = redis.NewScript(`
+local burst = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local rate = burst / period
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'updated')
+local tokens = tonumber(state[1]) or burst
+local updated = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - updated) * rate)
+
+local allowed, wait = 0, 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) / rate)
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'updated', now)
+redis.call('PEXPIRE', KEYS[1], period)
+return {allowed, wait}
+`)
+
+// RateLimiter is a token bucket rate limiter whose buckets are shared by every
+// instance using the same Redis
+type RateLimiter struct {
+	client *Client
+}
+
+// NewRateLimiter creates a new RateLimiter instance
+func NewRateLimiter(client *Client) *RateLimiter {
+	return &RateLimiter{
+		client: client,
+	}
+}
+
+// Allow takes a token from key's bucket, which holds burst tokens and refills
+// completely over period
+func (l *RateLimiter) Allow(ctx context.Context, key string, burst int, period time.Duration) (bool, time.Duration, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	result, err := tokenBucket.Run(ctx, l.client, []string{"ratelimit:" + key}, burst, period.Milliseconds()).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	return result[0] == 1, time.Duration(result[1]) * time.Millisecond, nil
+}
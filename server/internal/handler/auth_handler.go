@@ -2,11 +2,14 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/mjxoro/sent/server/internal/auth"
 	"github.com/mjxoro/sent/server/internal/models"
 	"github.com/mjxoro/sent/server/internal/service"
+	"io"
+	"log"
 	"net/http"
 	"os"
 )
@@ -17,15 +20,20 @@ type AuthHandler struct {
 	jwtService          *auth.JWTService
 	userService         *service.UserService
 	refreshTokenService *service.RefreshTokenService
+	revocations         *service.TokenRevocationService
+	ws                  *WSHandler
 }
 
-// NewAuthHandler creates a new auth handler
-func NewAuthHandler(oauthService *auth.OAuthService, jwtService *auth.JWTService, userService *service.UserService, refreshTokenService *service.RefreshTokenService) *AuthHandler {
+// NewAuthHandler creates a new auth handler. Logging out closes the user's
+// connections through ws.
+func NewAuthHandler(oauthService *auth.OAuthService, jwtService *auth.JWTService, userService *service.UserService, refreshTokenService *service.RefreshTokenService, revocations *service.TokenRevocationService, ws *WSHandler) *AuthHandler {
 	return &AuthHandler{
 		oauthService:        oauthService,
 		jwtService:          jwtService,
 		userService:         userService,
 		refreshTokenService: refreshTokenService,
+		revocations:         revocations,
+		ws:                  ws,
 	}
 }
 
@@ -63,7 +71,7 @@ func (h *AuthHandler) Callback(c *gin.Context) {
 	}
 
 	// Exchange the code for a token
-	token, err := h.oauthService.Exchange(code)
+	token, err := h.oauthService.Exchange(c.Request.Context(), code)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "failed to exchange token",
@@ -72,7 +80,7 @@ func (h *AuthHandler) Callback(c *gin.Context) {
 	}
 
 	// Get user info from the token
-	userInfo, err := h.oauthService.GetUserInfo(token)
+	userInfo, err := h.oauthService.GetUserInfo(c.Request.Context(), token)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "failed to get user info",
@@ -81,7 +89,7 @@ func (h *AuthHandler) Callback(c *gin.Context) {
 	}
 
 	// Check if user exists and create if not
-	user, err := h.userService.FindOrCreateFromOAuth(&models.User{
+	user, err := h.userService.FindOrCreateFromOAuth(c.Request.Context(), &models.User{
 		OAuthID: userInfo.ID,
 		Email:   userInfo.Email,
 		Name:    userInfo.Name,
@@ -114,7 +122,7 @@ func (h *AuthHandler) Callback(c *gin.Context) {
 
 	// Store refresh token in database
 	refreshExpiry := h.jwtService.GetRefreshTokenExpiry()
-	err = h.refreshTokenService.Store(user.ID, refreshToken, refreshExpiry)
+	err = h.refreshTokenService.Store(c.Request.Context(), user.ID, refreshToken, refreshExpiry)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "failed to store refresh token",
@@ -122,28 +130,7 @@ func (h *AuthHandler) Callback(c *gin.Context) {
 		return
 	}
 
-	cookieDomain := os.Getenv("COOKIE_DOMAIN")
-	secureFlag := os.Getenv("COOKIE_SECURE") == "true"
-
-	c.SetCookie(
-		"auth_token",
-		jwtToken,
-		3600*24,      // max age (24 hours)
-		"/",          // path
-		cookieDomain, // domain from environment
-		secureFlag,   // secure flag from environment
-		true,         // HTTP only
-	)
-
-	c.SetCookie(
-		"refresh_token",
-		refreshToken,
-		3600*24*30,   // max age (30 days)
-		"/",          // path
-		cookieDomain, // domain from environment
-		secureFlag,   // secure flag from environment
-		true,         // HTTP only
-	)
+	setAuthCookies(c, jwtToken, refreshToken)
 
 	// Redirect to frontend
 	redirectURI := os.Getenv("FRONTEND_URI")
@@ -153,7 +140,9 @@ func (h *AuthHandler) Callback(c *gin.Context) {
 	c.Redirect(http.StatusFound, fmt.Sprintf("%s/", redirectURI))
 }
 
-// RefreshToken handles refreshing an expired access token
+// RefreshToken exchanges a refresh token for a new access token and a new
+// refresh token. The old refresh token stops working, and presenting it again
+// revokes every refresh token descended from the same login.
 func (h *AuthHandler) RefreshToken(c *gin.Context) {
 	// Struct to bind JSON body
 	var req struct {
@@ -166,34 +155,151 @@ func (h *AuthHandler) RefreshToken(c *gin.Context) {
 		return
 	}
 
-	fmt.Println(req.RefreshToken)
 	// Validate refresh token
-	claims, err := h.jwtService.ValidateToken(req.RefreshToken)
+	claims, err := h.jwtService.ValidateRefreshToken(req.RefreshToken)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh token"})
 		return
 	}
 
-	// Check if refresh token exists in database and is valid
-	isValid, err := h.refreshTokenService.Validate(claims.UserID, req.RefreshToken)
-	if err != nil || !isValid {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh token not valid"})
-		return
-	}
-
 	// Get user information
-	user, err := h.userService.GetByID(claims.UserID)
+	user, err := h.userService.GetByID(c.Request.Context(), claims.UserID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get user"})
 		return
 	}
 
-	// Generate new access token
+	// Generate the new tokens before rotating, so a failure doesn't use up the old one
 	newAccessToken, err := h.jwtService.GenerateToken(user.ID, user.Email, user.Name, user.Avatar)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "token refreshed successfully", "auth_token": newAccessToken})
+	newRefreshToken, err := h.jwtService.GenerateRefreshToken(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate refresh token"})
+		return
+	}
+
+	err = h.refreshTokenService.Rotate(c.Request.Context(), user.ID, req.RefreshToken, newRefreshToken, h.jwtService.GetRefreshTokenExpiry())
+	switch {
+	case errors.Is(err, service.ErrRefreshTokenReused):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh token reused"})
+		return
+	case errors.Is(err, service.ErrInvalidRefreshToken):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh token not valid"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to rotate refresh token"})
+		return
+	}
+
+	setAuthCookies(c, newAccessToken, newRefreshToken)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "token refreshed successfully",
+		"auth_token":    newAccessToken,
+		"refresh_token": newRefreshToken,
+	})
+}
+
+// Logout ends the current session: its access token stops working straight away
+// and the connections opened with it are closed, its refresh token is revoked,
+// and the auth cookies are cleared. The refresh token is read from the body's
+// refreshToken or the refresh_token cookie. Logging out succeeds even if the
+// tokens are already invalid, so clients can always clear their session.
+func (h *AuthHandler) Logout(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	// The body is optional, as browsers send the refresh token cookie instead,
+	// but a body that can't be read must not leave its refresh token valid
+	var req struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	if req.RefreshToken == "" {
+		req.RefreshToken, _ = c.Cookie("refresh_token")
+	}
+
+	if claims, err := h.jwtService.ValidateToken(auth.TokenFromRequest(c)); err == nil && claims.ID != "" {
+		if err := h.revocations.RevokeToken(ctx, claims.ID, claims.ExpiresAtTime()); err != nil {
+			log.Printf("Failed to revoke access token: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke token"})
+			return
+		}
+		h.ws.closeSessions(claims.UserID, claims.ID)
+	}
+
+	if claims, err := h.jwtService.ValidateRefreshToken(req.RefreshToken); err == nil {
+		if err := h.refreshTokenService.Revoke(ctx, claims.UserID, req.RefreshToken); err != nil {
+			log.Printf("Failed to revoke refresh token: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke refresh token"})
+			return
+		}
+	}
+
+	clearAuthCookies(c)
+	c.JSON(http.StatusOK, gin.H{"message": "logged out successfully"})
+}
+
+// LogoutAll ends every one of the current user's sessions: all of their access
+// and refresh tokens are revoked, all of their connections are closed, and the
+// auth cookies are cleared
+func (h *AuthHandler) LogoutAll(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.GetString("userID")
+
+	if err := h.refreshTokenService.RevokeAllForUser(ctx, userID); err != nil {
+		log.Printf("Failed to revoke refresh tokens: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke refresh tokens"})
+		return
+	}
+	if err := h.revocations.RevokeAllForUser(ctx, userID); err != nil {
+		log.Printf("Failed to revoke access tokens: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke tokens"})
+		return
+	}
+	h.ws.closeSessions(userID, "")
+
+	clearAuthCookies(c)
+	c.JSON(http.StatusOK, gin.H{"message": "logged out of all sessions"})
+}
+
+// setAuthCookies sets the access and refresh token cookies
+func setAuthCookies(c *gin.Context, accessToken, refreshToken string) {
+	cookieDomain := os.Getenv("COOKIE_DOMAIN")
+	secureFlag := os.Getenv("COOKIE_SECURE") == "true"
+
+	c.SetCookie(
+		"auth_token",
+		accessToken,
+		3600*24,      // max age (24 hours)
+		"/",          // path
+		cookieDomain, // domain from environment
+		secureFlag,   // secure flag from environment
+		true,         // HTTP only
+	)
+
+	c.SetCookie(
+		"refresh_token",
+		refreshToken,
+		3600*24*30,   // max age (30 days)
+		"/",          // path
+		cookieDomain, // domain from environment
+		secureFlag,   // secure flag from environment
+		true,         // HTTP only
+	)
+}
+
+// clearAuthCookies deletes the access and refresh token cookies
+func clearAuthCookies(c *gin.Context) {
+	cookieDomain := os.Getenv("COOKIE_DOMAIN")
+	secureFlag := os.Getenv("COOKIE_SECURE") == "true"
+
+	c.SetCookie("auth_token", "", -1, "/", cookieDomain, secureFlag, true)
+	c.SetCookie("refresh_token", "", -1, "/", cookieDomain, secureFlag, true)
 }
@@ -0,0 +1,287 @@
+// internal/handler/events_handler.go
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/mjxoro/sent/server/internal/models"
+	"github.com/mjxoro/sent/server/pkg/websocket"
+)
+
+const (
+	// sseKeepAlive is how often an idle event stream gets a comment, so proxies keep it open
+	sseKeepAlive = 25 * time.Second
+
+	// pollTimeout is how long a long poll waits for frames
+	pollTimeout = 25 * time.Second
+
+	// pollExpiry is how long a long-polling connection lives between polls
+	pollExpiry = time.Minute
+
+	// maxPostedFrameSize is the largest frame a client may post, as for WebSocket frames
+	maxPostedFrameSize = 16 * 1024
+)
+
+// EventsHandler serves the WebSocket protocol over plain HTTP, for clients behind
+// proxies that block WebSockets. Server frames are delivered over Server-Sent
+// Events or long polling, and client frames are posted to the connection. The
+// connections are hub clients like WebSocket connections, and get the same frames.
+type EventsHandler struct {
+	ws *WSHandler
+
+	mu          sync.Mutex
+	connections map[string]*eventConnection
+}
+
+// eventConnection is a hub client whose frames are delivered over HTTP
+type eventConnection struct {
+	id     string
+	client *websocket.Client
+	user   *models.User
+
+	// ctx is used for frames posted to the connection, and is cancelled once it closes
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// dispatchMu handles posted frames one at a time, as a WebSocket's are read in order
+	dispatchMu sync.Mutex
+
+	// expiry closes a long-polling connection that stops polling; nil for event streams
+	expiry *time.Timer
+
+	closeOnce sync.Once
+}
+
+// NewEventsHandler creates a new events handler that handles frames with the WebSocket handler's router
+func NewEventsHandler(ws *WSHandler) *EventsHandler {
+	return &EventsHandler{
+		ws:          ws,
+		connections: make(map[string]*eventConnection),
+	}
+}
+
+// Stream delivers server frames as Server-Sent Events, one frame per event.
+// Rooms given as room_id query parameters are subscribed to on connect.
+func (h *EventsHandler) Stream(c *gin.Context) {
+	conn, ok := h.connect(c, false)
+	if !ok {
+		return
+	}
+	defer h.close(conn)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	writeFrames := func(frames [][]byte) error {
+		for _, frame := range frames {
+			if _, err := fmt.Fprintf(c.Writer, "data: %s\n\n", frame); err != nil {
+				return err
+			}
+		}
+		c.Writer.Flush()
+		return nil
+	}
+
+	keepAlive := func() error {
+		if _, err := io.WriteString(c.Writer, ": keep-alive\n\n"); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	}
+
+	conn.client.Pump(c.Request.Context(), sseKeepAlive, writeFrames, keepAlive)
+}
+
+// Poll delivers server frames by long polling, as a JSON array of frames. Without
+// a connection_id it opens a connection, subscribing to any room_id query
+// parameters, and returns its connected frame. A connection is closed if it isn't
+// polled for a minute, and polling a closed connection returns 404.
+func (h *EventsHandler) Poll(c *gin.Context) {
+	var conn *eventConnection
+	if id := c.Query("connection_id"); id != "" {
+		var ok bool
+		if conn, ok = h.lookup(c, id); !ok {
+			return
+		}
+	} else {
+		var ok bool
+		if conn, ok = h.connect(c, true); !ok {
+			return
+		}
+	}
+
+	if conn.expiry == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "connection is an event stream"})
+		return
+	}
+
+	// A connection that expired, or is already being polled, can't be polled
+	if !conn.expiry.Stop() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "connection not found"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), pollTimeout)
+	defer cancel()
+
+	frames, closed, _ := conn.client.Poll(ctx)
+	if closed {
+		h.close(conn)
+	} else {
+		conn.expiry.Reset(pollExpiry)
+	}
+
+	batch := append([]byte{'['}, bytes.Join(frames, []byte{','})...)
+	c.Data(http.StatusOK, "application/json", append(batch, ']'))
+}
+
+// PostFrame handles a client frame sent to a connection, exactly as if it had
+// been sent over a WebSocket. Responses are delivered on the connection.
+func (h *EventsHandler) PostFrame(c *gin.Context) {
+	conn, ok := h.lookup(c, c.Param("connectionId"))
+	if !ok {
+		return
+	}
+
+	frame, err := io.ReadAll(io.LimitReader(c.Request.Body, maxPostedFrameSize+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read frame"})
+		return
+	}
+	if len(frame) > maxPostedFrameSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "frame too large"})
+		return
+	}
+
+	conn.dispatch(h.ws.router, frame)
+	c.Status(http.StatusAccepted)
+}
+
+// Close closes a connection, for long-polling clients that are leaving
+func (h *EventsHandler) Close(c *gin.Context) {
+	conn, ok := h.lookup(c, c.Param("connectionId"))
+	if !ok {
+		return
+	}
+
+	h.close(conn)
+	c.Status(http.StatusNoContent)
+}
+
+// connect registers a new connection for the current user, queues its connected
+// frame and subscribes it to the requested rooms
+func (h *EventsHandler) connect(c *gin.Context, poll bool) (*eventConnection, bool) {
+	userID := c.GetString("userID")
+	user, err := h.ws.userService.GetByID(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user"})
+		return nil, false
+	}
+
+	// Long-polling connections outlive the request that opens them
+	ctx, cancel := context.WithCancel(context.WithoutCancel(c.Request.Context()))
+
+	client := websocket.NewClient(h.ws.hub, nil, userID)
+	ctx = h.ws.trackConnAuth(ctx, client, connToken{
+		userID:    userID,
+		id:        c.GetString("tokenID"),
+		expiresAt: c.GetTime("tokenExpiresAt"),
+	})
+
+	conn := &eventConnection{
+		id:     uuid.NewString(),
+		client: client,
+		user:   user,
+		ctx:    withUser(ctx, user),
+		cancel: cancel,
+	}
+	transport := "sse"
+	if poll {
+		transport = "poll"
+	}
+	client.Info = clientInfo(c, conn.id, transport)
+
+	if poll {
+		conn.expiry = time.AfterFunc(pollExpiry, func() {
+			h.close(conn)
+		})
+	}
+
+	h.mu.Lock()
+	h.connections[conn.id] = conn
+	h.mu.Unlock()
+
+	h.ws.hub.Register(conn.client)
+	log.Printf("Event connection established for user: %s (%s)", user.Name, userID)
+
+	// The first frame tells the client where to post its frames
+	connected, _ := json.Marshal(map[string]string{
+		"type":          "connected",
+		"connection_id": conn.id,
+	})
+	conn.client.Send(connected)
+
+	for _, roomID := range c.QueryArray("room_id") {
+		subscribe, _ := json.Marshal(map[string]string{
+			"type":    "subscribe",
+			"room_id": roomID,
+		})
+		conn.dispatch(h.ws.router, subscribe)
+	}
+
+	return conn, true
+}
+
+// lookup returns the current user's connection with id, responding with 404 if there is none
+func (h *EventsHandler) lookup(c *gin.Context, id string) (*eventConnection, bool) {
+	conn := h.connection(id)
+	if conn == nil || conn.user.ID != c.GetString("userID") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "connection not found"})
+		return nil, false
+	}
+	return conn, true
+}
+
+// connection returns the open connection with id, or nil
+func (h *EventsHandler) connection(id string) *eventConnection {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.connections[id]
+}
+
+// close removes a connection and disconnects its client. It is safe to call more than once.
+func (h *EventsHandler) close(conn *eventConnection) {
+	conn.closeOnce.Do(func() {
+		h.mu.Lock()
+		delete(h.connections, conn.id)
+		h.mu.Unlock()
+
+		if conn.expiry != nil {
+			conn.expiry.Stop()
+		}
+		conn.cancel()
+		h.ws.disconnect(conn.client, conn.user)
+	})
+}
+
+// dispatch handles a client frame sent to the connection
+func (conn *eventConnection) dispatch(router *websocket.Router, frame []byte) {
+	conn.dispatchMu.Lock()
+	defer conn.dispatchMu.Unlock()
+	router.Dispatch(conn.ctx, conn.client, frame)
+}
@@ -0,0 +1,136 @@
+// internal/handler/friend_list_handler.go
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mjxoro/sent/server/internal/service"
+)
+
+// FriendListHandler handles friend list requests
+type FriendListHandler struct {
+	friendListService *service.FriendListService
+}
+
+// NewFriendListHandler creates a new friend list handler
+func NewFriendListHandler(friendListService *service.FriendListService) *FriendListHandler {
+	return &FriendListHandler{
+		friendListService: friendListService,
+	}
+}
+
+// friendListRequest is the request body for creating or renaming a friend list
+type friendListRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+// GetLists gets all friend lists for the current user
+func (h *FriendListHandler) GetLists(c *gin.Context) {
+	userID := c.GetString("userID")
+
+	lists, err := h.friendListService.GetLists(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get friend lists"})
+		return
+	}
+
+	c.JSON(http.StatusOK, lists)
+}
+
+// CreateList creates a new friend list for the current user
+func (h *FriendListHandler) CreateList(c *gin.Context) {
+	userID := c.GetString("userID")
+
+	var req friendListRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+
+	list, err := h.friendListService.CreateList(c.Request.Context(), userID, req.Name)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, list)
+}
+
+// GetList gets a single friend list with its members
+func (h *FriendListHandler) GetList(c *gin.Context) {
+	userID := c.GetString("userID")
+	listID := c.Param("listId")
+
+	list, err := h.friendListService.GetList(c.Request.Context(), listID, userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+// RenameList renames a friend list
+func (h *FriendListHandler) RenameList(c *gin.Context) {
+	userID := c.GetString("userID")
+	listID := c.Param("listId")
+
+	var req friendListRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+
+	list, err := h.friendListService.RenameList(c.Request.Context(), listID, userID, req.Name)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+// DeleteList deletes a friend list
+func (h *FriendListHandler) DeleteList(c *gin.Context) {
+	userID := c.GetString("userID")
+	listID := c.Param("listId")
+
+	err := h.friendListService.DeleteList(c.Request.Context(), listID, userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Friend list deleted"})
+}
+
+// AddMember adds a friend to a friend list
+func (h *FriendListHandler) AddMember(c *gin.Context) {
+	userID := c.GetString("userID")
+	listID := c.Param("listId")
+	friendID := c.Param("userId")
+
+	err := h.friendListService.AddMember(c.Request.Context(), listID, userID, friendID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Friend added to list"})
+}
+
+// RemoveMember removes a friend from a friend list
+func (h *FriendListHandler) RemoveMember(c *gin.Context) {
+	userID := c.GetString("userID")
+	listID := c.Param("listId")
+	friendID := c.Param("userId")
+
+	err := h.friendListService.RemoveMember(c.Request.Context(), listID, userID, friendID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Friend removed from list"})
+}
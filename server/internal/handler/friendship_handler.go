@@ -25,7 +25,7 @@ func NewFriendshipHandler(friendshipService *service.FriendshipService) *Friends
 func (h *FriendshipHandler) GetFriends(c *gin.Context) {
 	userID := c.GetString("userID")
 
-	friends, err := h.friendshipService.GetFriends(userID)
+	friends, err := h.friendshipService.GetFriends(c.Request.Context(), userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get friends"})
 		return
@@ -38,7 +38,7 @@ func (h *FriendshipHandler) GetFriends(c *gin.Context) {
 func (h *FriendshipHandler) GetFriendRequests(c *gin.Context) {
 	userID := c.GetString("userID")
 
-	requests, err := h.friendshipService.GetPendingRequests(userID)
+	requests, err := h.friendshipService.GetPendingRequests(c.Request.Context(), userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get friend requests"})
 		return
@@ -51,7 +51,7 @@ func (h *FriendshipHandler) GetFriendRequests(c *gin.Context) {
 func (h *FriendshipHandler) GetAllRelationships(c *gin.Context) {
 	userID := c.GetString("userID")
 
-	relationships, err := h.friendshipService.GetAllRelationships(userID)
+	relationships, err := h.friendshipService.GetAllRelationships(c.Request.Context(), userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get relationships"})
 		return
@@ -70,7 +70,7 @@ func (h *FriendshipHandler) SendFriendRequest(c *gin.Context) {
 		return
 	}
 
-	friendship, err := h.friendshipService.SendFriendRequest(userID, friendID)
+	friendship, err := h.friendshipService.SendFriendRequest(c.Request.Context(), userID, friendID)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -84,7 +84,19 @@ func (h *FriendshipHandler) AcceptFriendRequest(c *gin.Context) {
 	userID := c.GetString("userID")
 	friendshipID := c.Param("friendshipId")
 
-	err := h.friendshipService.AcceptFriendRequest(friendshipID, userID)
+	// Optionally open the direct message room with the new friend in the same step
+	if c.Query("open_dm") == "true" {
+		room, err := h.friendshipService.AcceptFriendRequestAndOpenDM(c.Request.Context(), friendshipID, userID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Friend request accepted", "room": room})
+		return
+	}
+
+	err := h.friendshipService.AcceptFriendRequest(c.Request.Context(), friendshipID, userID)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -98,7 +110,7 @@ func (h *FriendshipHandler) RejectFriendRequest(c *gin.Context) {
 	userID := c.GetString("userID")
 	friendshipID := c.Param("friendshipId")
 
-	err := h.friendshipService.RejectFriendRequest(friendshipID, userID)
+	err := h.friendshipService.RejectFriendRequest(c.Request.Context(), friendshipID, userID)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -112,7 +124,7 @@ func (h *FriendshipHandler) RemoveFriend(c *gin.Context) {
 	userID := c.GetString("userID")
 	friendID := c.Param("userId")
 
-	err := h.friendshipService.RemoveFriend(userID, friendID)
+	err := h.friendshipService.RemoveFriend(c.Request.Context(), userID, friendID)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -126,7 +138,7 @@ func (h *FriendshipHandler) BlockUser(c *gin.Context) {
 	userID := c.GetString("userID")
 	blockUserID := c.Param("userId")
 
-	err := h.friendshipService.BlockUser(userID, blockUserID)
+	err := h.friendshipService.BlockUser(c.Request.Context(), userID, blockUserID)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -140,7 +152,7 @@ func (h *FriendshipHandler) UnblockUser(c *gin.Context) {
 	userID := c.GetString("userID")
 	blockedUserID := c.Param("userId")
 
-	err := h.friendshipService.UnblockUser(userID, blockedUserID)
+	err := h.friendshipService.UnblockUser(c.Request.Context(), userID, blockedUserID)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -149,7 +161,7 @@ func (h *FriendshipHandler) UnblockUser(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "User unblocked"})
 }
 
-// GetPotentialFriends gets users who are not yet friends with the current user
+// GetPotentialFriends gets ranked friend suggestions for the current user
 func (h *FriendshipHandler) GetPotentialFriends(c *gin.Context) {
 	userID := c.GetString("userID")
 
@@ -169,13 +181,27 @@ func (h *FriendshipHandler) GetPotentialFriends(c *gin.Context) {
 		}
 	}
 
-	users, err := h.friendshipService.GetNonFriends(userID, limit, offset)
+	suggestions, err := h.friendshipService.GetFriendSuggestions(c.Request.Context(), userID, limit, offset)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get potential friends"})
 		return
 	}
 
-	c.JSON(http.StatusOK, users)
+	c.JSON(http.StatusOK, suggestions)
+}
+
+// DismissSuggestion hides a user from the current user's friend suggestions
+func (h *FriendshipHandler) DismissSuggestion(c *gin.Context) {
+	userID := c.GetString("userID")
+	suggestedUserID := c.Param("userId")
+
+	err := h.friendshipService.DismissSuggestion(c.Request.Context(), userID, suggestedUserID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Suggestion dismissed"})
 }
 
 // GetFriendshipStatus gets the status of friendship between the current user and another user
@@ -183,7 +209,7 @@ func (h *FriendshipHandler) GetFriendshipStatus(c *gin.Context) {
 	userID := c.GetString("userID")
 	otherUserID := c.Param("userId")
 
-	status, err := h.friendshipService.GetFriendshipStatus(userID, otherUserID)
+	status, err := h.friendshipService.GetFriendshipStatus(c.Request.Context(), userID, otherUserID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"status": "none"})
 		return
@@ -0,0 +1,105 @@
+// internal/handler/message_handler.go
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mjxoro/sent/server/internal/service"
+	"github.com/mjxoro/sent/server/pkg/websocket"
+)
+
+// MessageHandler handles sending messages over HTTP, for scripts, bots and
+// integrations, and for clients using a fallback transport
+type MessageHandler struct {
+	ws     *WSHandler
+	events *EventsHandler
+}
+
+// NewMessageHandler creates a new message handler that sends messages like the WebSocket handler
+func NewMessageHandler(ws *WSHandler, events *EventsHandler) *MessageHandler {
+	return &MessageHandler{
+		ws:     ws,
+		events: events,
+	}
+}
+
+// SendMessage sends a chat message to a room. It is saved and broadcast to the
+// room exactly like a message sent over a WebSocket, except that it isn't sent
+// back to the sender's own event connection if connection_id is given.
+//
+// The Idempotency-Key header, or client_msg_id in the body, makes retries safe:
+// a retried request returns the original message with status 200 instead of 201.
+func (h *MessageHandler) SendMessage(c *gin.Context) {
+	userID := c.GetString("userID")
+	roomID := c.Param("roomId")
+
+	var req struct {
+		Content      string `json:"content" binding:"required"`
+		ClientMsgID  string `json:"client_msg_id"`
+		ConnectionID string `json:"connection_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if key := c.GetHeader("Idempotency-Key"); key != "" {
+		if req.ClientMsgID != "" && req.ClientMsgID != key {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key and client_msg_id differ"})
+			return
+		}
+		req.ClientMsgID = key
+	}
+
+	isMember, err := h.ws.chatService.IsUserMemberOfRoom(c.Request.Context(), userID, roomID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error checking membership"})
+		return
+	}
+	if !isMember {
+		c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
+		return
+	}
+
+	user, err := h.ws.userService.GetByID(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user"})
+		return
+	}
+
+	var sender *websocket.Client
+	if conn := h.events.connection(req.ConnectionID); conn != nil && conn.user.ID == userID {
+		sender = conn.client
+	}
+
+	message, created, err := h.ws.sendChatMessage(c.Request.Context(), user, roomID, req.Content, req.ClientMsgID, sender)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidClientMsgID):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrClientMsgIDReused):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			log.Printf("Failed to send message: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to send message"})
+		}
+		return
+	}
+
+	status := http.StatusCreated
+	if !created {
+		status = http.StatusOK
+		c.Header("Idempotent-Replayed", "true")
+	}
+	c.JSON(status, ServerResponse{
+		Type:        "message_sent",
+		Success:     true,
+		RoomID:      roomID,
+		MessageID:   message.ID,
+		ClientMsgID: req.ClientMsgID,
+		Duplicate:   !created,
+	})
+}
@@ -0,0 +1,64 @@
+// internal/handler/sessions.go
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mjxoro/sent/server/pkg/websocket"
+)
+
+// maxDeviceIDLength bounds the device_id clients may name themselves with
+const maxDeviceIDLength = 128
+
+// clientInfo describes the device opening a connection, identified by sessionID.
+// Clients may pass a stable device_id query parameter to recognise themselves
+// in the session list.
+func clientInfo(c *gin.Context, sessionID, transport string) websocket.ClientInfo {
+	deviceID := c.Query("device_id")
+	if len(deviceID) > maxDeviceIDLength {
+		deviceID = deviceID[:maxDeviceIDLength]
+	}
+
+	return websocket.ClientInfo{
+		SessionID:   sessionID,
+		DeviceID:    deviceID,
+		Transport:   transport,
+		UserAgent:   c.Request.UserAgent(),
+		RemoteAddr:  c.ClientIP(),
+		ConnectedAt: time.Now(),
+	}
+}
+
+// ListSessions lists the current user's connected devices, oldest first
+func (h *WSHandler) ListSessions(c *gin.Context) {
+	clients := h.hub.UserClients(c.GetString("userID"))
+
+	sessions := make([]websocket.ClientInfo, len(clients))
+	for i, client := range clients {
+		sessions[i] = client.Info
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].ConnectedAt.Before(sessions[j].ConnectedAt)
+	})
+
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+// sendSelfSync tells a user's other devices about something they did on client,
+// such as reading messages, so every device shows the same state
+func (h *WSHandler) sendSelfSync(client *websocket.Client, action, roomID string, data interface{}) {
+	syncBytes, _ := json.Marshal(map[string]interface{}{
+		"type":       "self_sync",
+		"action":     action,
+		"room_id":    roomID,
+		"session_id": client.Info.SessionID,
+		"timestamp":  time.Now(),
+		"data":       data,
+	})
+
+	h.hub.SendToUser(client.ID, syncBytes, client)
+}
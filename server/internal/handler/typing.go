@@ -0,0 +1,205 @@
+// internal/handler/typing.go
+package handler
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/mjxoro/sent/server/internal/models"
+	"github.com/mjxoro/sent/server/pkg/websocket"
+)
+
+// defaultTypingTimeout is how long a user shows as typing after their last typing frame
+const defaultTypingTimeout = 6 * time.Second
+
+// typingTracker coalesces typing frames into per-room typing state. A user's
+// first typing frame is broadcast straight away, repeats are rebroadcast at most
+// every half timeout, and a user that stops sending them, unsubscribes or
+// disconnects is broadcast as no longer typing.
+type typingTracker struct {
+	hub     *websocket.Hub
+	timeout time.Duration
+
+	// mu guards rooms. Each room has its own lock, so a room waiting on a busy
+	// hub shard doesn't hold up typing in other rooms.
+	mu    sync.Mutex
+	rooms map[string]*typingRoom
+}
+
+// typingRoom is the typing state of one room
+type typingRoom struct {
+	// mu is held while broadcasting, so the room's typing frames go out in order
+	mu      sync.Mutex
+	typists map[string]*typist
+
+	// removed is set once the room is empty and no longer in the tracker
+	removed bool
+}
+
+// typist is a user typing in a room
+type typist struct {
+	user          *models.User
+	client        *websocket.Client
+	lastBroadcast time.Time
+	expiry        *time.Timer
+}
+
+// newTypingTracker creates a typing tracker that expires typing state after timeout
+func newTypingTracker(hub *websocket.Hub, timeout time.Duration) *typingTracker {
+	if timeout <= 0 {
+		timeout = defaultTypingTimeout
+	}
+	return &typingTracker{
+		hub:     hub,
+		timeout: timeout,
+		rooms:   make(map[string]*typingRoom),
+	}
+}
+
+// update records that a user sent a typing frame from client
+func (t *typingTracker) update(client *websocket.Client, user *models.User, roomID string, isTyping bool) {
+	room := t.lockRoom(roomID, isTyping)
+	if room == nil {
+		return
+	}
+	defer t.unlockRoom(roomID, room)
+
+	tp := room.typists[user.ID]
+	if !isTyping {
+		if tp != nil {
+			t.remove(room, roomID, tp)
+		}
+		return
+	}
+
+	// A typist whose expiry already fired is on its way out, so start afresh
+	if tp != nil && tp.expiry.Stop() {
+		tp.client = client
+		tp.expiry.Reset(t.timeout)
+		if time.Since(tp.lastBroadcast) >= t.timeout/2 {
+			tp.lastBroadcast = time.Now()
+			t.broadcast(roomID, tp, true)
+		}
+		return
+	}
+
+	tp = &typist{
+		user:          user,
+		client:        client,
+		lastBroadcast: time.Now(),
+	}
+	tp.expiry = time.AfterFunc(t.timeout, func() {
+		room := t.lockRoom(roomID, false)
+		if room == nil {
+			return
+		}
+		defer t.unlockRoom(roomID, room)
+		if room.typists[user.ID] == tp {
+			t.remove(room, roomID, tp)
+		}
+	})
+
+	room.typists[user.ID] = tp
+	t.broadcast(roomID, tp, true)
+}
+
+// stop clears client's typing state in a room, for clients that unsubscribe or disconnect
+func (t *typingTracker) stop(client *websocket.Client, roomID string) {
+	room := t.lockRoom(roomID, false)
+	if room == nil {
+		return
+	}
+	defer t.unlockRoom(roomID, room)
+
+	for _, tp := range room.typists {
+		if tp.client == client {
+			t.remove(room, roomID, tp)
+		}
+	}
+}
+
+// typers returns the users typing in a room
+func (t *typingTracker) typers(roomID string) []*models.User {
+	room := t.lockRoom(roomID, false)
+	if room == nil {
+		return nil
+	}
+	defer t.unlockRoom(roomID, room)
+
+	users := make([]*models.User, 0, len(room.typists))
+	for _, tp := range room.typists {
+		users = append(users, tp.user)
+	}
+	return users
+}
+
+// lockRoom locks and returns a room's typing state. If nobody is typing in the
+// room, it is created when create is set and nil is returned otherwise.
+func (t *typingTracker) lockRoom(roomID string, create bool) *typingRoom {
+	for {
+		t.mu.Lock()
+		room := t.rooms[roomID]
+		if room == nil {
+			if !create {
+				t.mu.Unlock()
+				return nil
+			}
+			room = &typingRoom{typists: make(map[string]*typist)}
+			t.rooms[roomID] = room
+		}
+		t.mu.Unlock()
+
+		// The room may have emptied and been removed while waiting for its lock
+		room.mu.Lock()
+		if !room.removed {
+			return room
+		}
+		room.mu.Unlock()
+	}
+}
+
+// unlockRoom unlocks a room locked by lockRoom, removing it from the tracker if
+// nobody is typing in it any more
+func (t *typingTracker) unlockRoom(roomID string, room *typingRoom) {
+	if len(room.typists) == 0 {
+		t.mu.Lock()
+		delete(t.rooms, roomID)
+		t.mu.Unlock()
+		room.removed = true
+	}
+	room.mu.Unlock()
+}
+
+// remove forgets a typist and broadcasts that they stopped typing. room.mu must be held.
+func (t *typingTracker) remove(room *typingRoom, roomID string, tp *typist) {
+	tp.expiry.Stop()
+	delete(room.typists, tp.user.ID)
+	t.broadcast(roomID, tp, false)
+}
+
+// broadcast sends a typist's typing state to the room's clients, other than the
+// typist's own. The room's lock must be held.
+func (t *typingTracker) broadcast(roomID string, tp *typist, isTyping bool) {
+	typingObj := map[string]interface{}{
+		"type":      "typing",
+		"room_id":   roomID,
+		"user_id":   tp.user.ID,
+		"timestamp": time.Now(),
+		"data": map[string]interface{}{
+			"user_name": tp.user.Name,
+			"is_typing": isTyping,
+		},
+	}
+
+	typingBytes, _ := json.Marshal(typingObj)
+
+	// Broadcast to all clients in the room, except the typist's other devices
+	t.hub.Broadcast(&websocket.Message{
+		RoomID:     roomID,
+		Data:       typingBytes,
+		Client:     tp.client,
+		ExceptUser: true,
+		Ephemeral:  true,
+	})
+}
@@ -0,0 +1,316 @@
+// internal/handler/ws_auth.go
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	gorillaWs "github.com/gorilla/websocket"
+	"github.com/mjxoro/sent/server/internal/auth"
+	"github.com/mjxoro/sent/server/internal/service"
+	"github.com/mjxoro/sent/server/pkg/websocket"
+)
+
+const (
+	// CloseUnauthorized is the close code for connections that failed to
+	// authenticate, or whose token expired without being refreshed
+	CloseUnauthorized = 4401
+
+	// authTimeout is how long a connection without credentials has to send its auth frame
+	authTimeout = 10 * time.Second
+
+	// tokenExpiryWarning is how long before its token expires a connection is sent token_expiring
+	tokenExpiryWarning = time.Minute
+)
+
+var (
+	// errInvalidToken is returned when an auth frame's token is invalid, expired or revoked
+	errInvalidToken = errors.New("invalid token")
+
+	// errTokenUserMismatch is returned when a connection is re-authenticated as a different user
+	errTokenUserMismatch = errors.New("token is for a different user")
+
+	// errNotAuthenticated is returned when a connection's first frame isn't an auth frame
+	errNotAuthenticated = errors.New("first frame must be auth")
+)
+
+// IssueTicket creates a single-use ticket for opening a WebSocket as the current
+// user, for clients that can't send a cookie or header with the upgrade
+func (h *WSHandler) IssueTicket(c *gin.Context) {
+	ticket, err := h.tickets.Issue(c.Request.Context(), service.WSTicket{
+		UserID:         c.GetString("userID"),
+		TokenID:        c.GetString("tokenID"),
+		TokenIssuedAt:  c.GetTime("tokenIssuedAt"),
+		TokenExpiresAt: c.GetTime("tokenExpiresAt"),
+	})
+	if err != nil {
+		log.Printf("Failed to issue WebSocket ticket: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to issue ticket"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ticket":     ticket,
+		"expires_in": int(service.WSTicketTTL.Seconds()),
+	})
+}
+
+// connToken identifies the access token a connection authenticated with
+type connToken struct {
+	userID    string
+	id        string
+	expiresAt time.Time
+}
+
+// tokenOf returns the connToken for a token's claims
+func tokenOf(claims *auth.TokenClaims) connToken {
+	return connToken{
+		userID:    claims.UserID,
+		id:        claims.ID,
+		expiresAt: claims.ExpiresAtTime(),
+	}
+}
+
+// upgradeCredentials authenticates a WebSocket upgrade from a ?ticket=, the
+// deprecated ?token=, the Authorization header or the auth_token cookie, in that
+// order, responding with 401 if they are invalid. It returns an empty user ID if
+// the request has none, in which case the client must send an auth frame first.
+func (h *WSHandler) upgradeCredentials(c *gin.Context) (connToken, bool) {
+	ctx := c.Request.Context()
+
+	if ticket := c.Query("ticket"); ticket != "" {
+		t, err := h.tickets.Redeem(ctx, ticket)
+		if err == nil {
+			// The token the ticket was issued for may have been revoked since
+			var revoked bool
+			if revoked, err = h.revocations.IsRevoked(ctx, t.UserID, t.TokenID, t.TokenIssuedAt); err == nil && revoked {
+				err = errInvalidToken
+			}
+		}
+		if err != nil {
+			log.Printf("Invalid WebSocket ticket: %v", err)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid ticket"})
+			return connToken{}, false
+		}
+		return connToken{userID: t.UserID, id: t.TokenID, expiresAt: t.TokenExpiresAt}, true
+	}
+
+	// Tokens in the query string end up in access logs, so ?token= is only kept
+	// for older clients
+	token := c.Query("token")
+	if token == "" {
+		token = auth.TokenFromRequest(c)
+	}
+	if token == "" {
+		return connToken{}, true
+	}
+
+	claims, err := h.validateToken(ctx, token)
+	if err != nil {
+		log.Printf("Invalid token: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+		return connToken{}, false
+	}
+	return tokenOf(claims), true
+}
+
+// validateToken validates an access token and checks it hasn't been revoked
+func (h *WSHandler) validateToken(ctx context.Context, token string) (*auth.TokenClaims, error) {
+	claims, err := h.jwtService.ValidateToken(token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidToken, err)
+	}
+
+	revoked, err := h.revocations.IsRevoked(ctx, claims.UserID, claims.ID, claims.IssuedAtTime())
+	if err != nil {
+		return nil, fmt.Errorf("checking token revocation: %w", err)
+	}
+	if revoked {
+		return nil, fmt.Errorf("%w: token revoked", errInvalidToken)
+	}
+	return claims, nil
+}
+
+// readAuthFrame waits for a connection's first frame, which must be an auth frame,
+// and returns it with the claims of its token
+func (h *WSHandler) readAuthFrame(ctx context.Context, conn *gorillaWs.Conn) (*websocket.Request, *auth.TokenClaims, error) {
+	frame, err := websocket.ReadFrame(conn, authTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var req websocket.Request
+	if err := json.Unmarshal(frame, &req); err != nil || req.Type != "auth" {
+		return nil, nil, errNotAuthenticated
+	}
+
+	claims, err := h.authFrameClaims(ctx, &req)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &req, claims, nil
+}
+
+// rejectConnection closes a connection that failed to authenticate
+func rejectConnection(conn *gorillaWs.Conn) {
+	closeMessage := gorillaWs.FormatCloseMessage(CloseUnauthorized, "authentication failed")
+	conn.WriteControl(gorillaWs.CloseMessage, closeMessage, time.Now().Add(authTimeout))
+	conn.Close()
+}
+
+// authFrameClaims validates the token in an auth frame
+func (h *WSHandler) authFrameClaims(ctx context.Context, req *websocket.Request) (*auth.TokenClaims, error) {
+	var authData struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(req.Data, &authData); err != nil || authData.Token == "" {
+		return nil, errInvalidFrameData
+	}
+
+	return h.validateToken(ctx, authData.Token)
+}
+
+// handleAuth re-authenticates a connection with a refreshed token, so it isn't
+// closed when its current token expires
+func (h *WSHandler) handleAuth(req *websocket.Request) error {
+	claims, err := h.authFrameClaims(req.Ctx, req)
+	if err != nil {
+		return err
+	}
+	if claims.UserID != wsUser(req).ID {
+		return errTokenUserMismatch
+	}
+
+	token := tokenOf(claims)
+	connAuthFrom(req.Ctx).extend(token)
+
+	reply(req, authenticated(token.expiresAt))
+	return nil
+}
+
+// authenticated acknowledges an auth frame
+func authenticated(expiresAt time.Time) ServerResponse {
+	resp := ServerResponse{
+		Type:    "authenticated",
+		Success: true,
+	}
+	if !expiresAt.IsZero() {
+		resp.ExpiresAt = &expiresAt
+	}
+	return resp
+}
+
+// connAuth tracks the token a connection authenticated with. The client is sent
+// token_expiring shortly before it expires, and is closed once it has expired
+// unless an auth frame with a fresh token extends it.
+type connAuth struct {
+	client *websocket.Client
+
+	mu      sync.Mutex
+	tokenID string
+	warn    *time.Timer
+	expire  *time.Timer
+	stopped bool
+}
+
+// trackConnAuth tracks client's token until ctx is done, so the connection
+// expires with the token and is closed by closeSessions if it is revoked. It
+// returns ctx carrying the tracker, for auth frames to extend.
+func (h *WSHandler) trackConnAuth(ctx context.Context, client *websocket.Client, token connToken) context.Context {
+	a := &connAuth{client: client}
+	a.extend(token)
+
+	h.conns.Store(client, a)
+	context.AfterFunc(ctx, func() {
+		a.stop()
+		h.conns.Delete(client)
+	})
+
+	return withConnAuth(ctx, a)
+}
+
+// closeSessions closes a user's connections that authenticated with the access
+// token tokenID, or all of their connections if tokenID is empty. Their clients
+// are told with the CloseUnauthorized close code.
+func (h *WSHandler) closeSessions(userID, tokenID string) {
+	for _, client := range h.hub.UserClients(userID) {
+		a, ok := h.conns.Load(client)
+		if !ok {
+			continue
+		}
+		if tokenID == "" || a.(*connAuth).currentTokenID() == tokenID {
+			log.Printf("Closing connection for user %s: token revoked", userID)
+			client.Close(CloseUnauthorized)
+		}
+	}
+}
+
+// currentTokenID returns the ID of the token the connection is authenticated with
+func (a *connAuth) currentTokenID() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.tokenID
+}
+
+// extend replaces the connection's token, and so its expiry. A token with a
+// zero expiry never expires.
+func (a *connAuth) extend(token connToken) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.tokenID = token.id
+	expiresAt := token.expiresAt
+
+	a.stopTimers()
+	if a.stopped || expiresAt.IsZero() {
+		return
+	}
+
+	a.warn = time.AfterFunc(time.Until(expiresAt)-tokenExpiryWarning, func() {
+		frame, _ := json.Marshal(map[string]any{
+			"type":       "token_expiring",
+			"expires_at": expiresAt,
+		})
+		a.client.Send(frame)
+	})
+	a.expire = time.AfterFunc(time.Until(expiresAt), func() {
+		log.Printf("Closing connection for user %s: token expired", a.client.ID)
+		a.client.Close(CloseUnauthorized)
+	})
+}
+
+// stop stops tracking the connection's expiry
+func (a *connAuth) stop() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.stopped = true
+	a.stopTimers()
+}
+
+// stopTimers stops any pending warning and expiry. a.mu must be held.
+func (a *connAuth) stopTimers() {
+	if a.warn != nil {
+		a.warn.Stop()
+		a.expire.Stop()
+	}
+}
+
+// connAuthContextKey is the context key for the connection's connAuth
+type connAuthContextKey struct{}
+
+// withConnAuth returns a connection context that carries the connection's token tracker
+func withConnAuth(ctx context.Context, a *connAuth) context.Context {
+	return context.WithValue(ctx, connAuthContextKey{}, a)
+}
+
+// connAuthFrom returns the token tracker of the connection a frame was sent on
+func connAuthFrom(ctx context.Context) *connAuth {
+	return ctx.Value(connAuthContextKey{}).(*connAuth)
+}
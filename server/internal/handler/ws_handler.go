@@ -2,15 +2,20 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	gorillaWs "github.com/gorilla/websocket"
 	"github.com/mjxoro/sent/server/internal/auth"
+	"github.com/mjxoro/sent/server/internal/config"
 	"github.com/mjxoro/sent/server/internal/models"
 	"github.com/mjxoro/sent/server/internal/service"
 	"github.com/mjxoro/sent/server/pkg/websocket"
@@ -22,6 +27,22 @@ type WSHandler struct {
 	chatService *service.ChatService
 	userService *service.UserService
 	jwtService  *auth.JWTService
+	tickets     *service.WSTicketService
+	revocations *service.TokenRevocationService
+	origins     *auth.OriginAllowlist
+
+	// conns tracks each connected client's token, so revoked tokens' connections can be closed
+	conns sync.Map // *websocket.Client -> *connAuth
+
+	// compressionLevel is the permessage-deflate level offered to clients; 0 disables it
+	compressionLevel int
+
+	// typing coalesces typing frames per room
+	typing *typingTracker
+
+	// router dispatches client frames by type
+	router       *websocket.Router
+	frameMetrics *websocket.FrameMetrics
 }
 
 // NewWSHandler creates a new WebSocket handler
@@ -30,68 +51,84 @@ func NewWSHandler(
 	chatService *service.ChatService,
 	userService *service.UserService,
 	jwtService *auth.JWTService,
+	tickets *service.WSTicketService,
+	revocations *service.TokenRevocationService,
+	origins *auth.OriginAllowlist,
+	frameLimit websocket.Middleware,
+	cfg config.WebSocketConfig,
 ) *WSHandler {
-	return &WSHandler{
-		hub:         hub,
-		chatService: chatService,
-		userService: userService,
-		jwtService:  jwtService,
+	h := &WSHandler{
+		hub:              hub,
+		chatService:      chatService,
+		userService:      userService,
+		jwtService:       jwtService,
+		tickets:          tickets,
+		revocations:      revocations,
+		origins:          origins,
+		compressionLevel: cfg.CompressionLevel,
+		typing:           newTypingTracker(hub, cfg.TypingTimeout),
+		frameMetrics:     &websocket.FrameMetrics{},
 	}
-}
-
-// ClientMessage represents a message from the client
-type ClientMessage struct {
-	Type    string          `json:"type"`
-	RoomID  string          `json:"room_id"`
-	Content string          `json:"content,omitempty"`
-	Data    json.RawMessage `json:"data,omitempty"`
+	h.router = h.newRouter(frameLimit)
+	return h
 }
 
 // ServerResponse represents responses to the client
 type ServerResponse struct {
-	Type      string          `json:"type"`
-	Success   bool            `json:"success"`
-	Message   string          `json:"message,omitempty"`
-	RoomID    string          `json:"room_id,omitempty"`
-	ThreadID  string          `json:"thread_id,omitempty"`
-	MessageID string          `json:"message_id,omitempty"`
-	Data      json.RawMessage `json:"data,omitempty"`
+	Type        string          `json:"type"`
+	Success     bool            `json:"success"`
+	Message     string          `json:"message,omitempty"`
+	RequestID   string          `json:"request_id,omitempty"`
+	RoomID      string          `json:"room_id,omitempty"`
+	ThreadID    string          `json:"thread_id,omitempty"`
+	MessageID   string          `json:"message_id,omitempty"`
+	ClientMsgID string          `json:"client_msg_id,omitempty"`
+	Duplicate   bool            `json:"duplicate,omitempty"`
+	ExpiresAt   *time.Time      `json:"expires_at,omitempty"`
+	RetryAfter  int64           `json:"retry_after_ms,omitempty"`
+	Data        json.RawMessage `json:"data,omitempty"`
 }
 
-// HandleConnection handles WebSocket connections
-func (h *WSHandler) HandleConnection(c *gin.Context) {
-	// Grab token from params
-	token := c.Query("token")
-	if token == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
-		return // Added return statement
-	}
+// AckTimeout is how long the server takes at most to acknowledge a frame that
+// gets a response. Clients should treat a frame as failed once it has passed.
+const AckTimeout = 10 * time.Second
 
-	// Validate Token then decode to get user id
-	claims, err := h.jwtService.ValidateToken(token)
-	if err != nil {
-		log.Printf("Invalid token: %v", err)
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
-		return // Added return statement
-	}
+var (
+	// errInvalidFrameData is returned when a frame's data doesn't match its type
+	errInvalidFrameData = errors.New("invalid frame data")
 
-	userID := claims.UserID
+	// errMissingContent is returned when a message frame has no content
+	errMissingContent = errors.New("message missing content")
+)
 
-	// Get user info
-	user, err := h.userService.GetByID(userID)
-	if err != nil {
-		log.Printf("User not found: %v", err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user"})
+// HandleConnection handles WebSocket connections. A client that has no
+// credentials to send with the upgrade must authenticate with an auth frame
+// first; see upgradeCredentials.
+func (h *WSHandler) HandleConnection(c *gin.Context) {
+	token, ok := h.upgradeCredentials(c)
+	if !ok {
 		return
 	}
 
+	// Get user info, unless it has to wait for the auth frame
+	var user *models.User
+	if token.userID != "" {
+		var err error
+		if user, err = h.userService.GetByID(c.Request.Context(), token.userID); err != nil {
+			log.Printf("User not found: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user"})
+			return
+		}
+	}
+
 	upgrader := gorillaWs.Upgrader{
-		ReadBufferSize:  1024,
-		WriteBufferSize: 1024,
-		CheckOrigin: func(r *http.Request) bool {
-			// For development, allow all origins
-			return true
-		},
+		ReadBufferSize:    1024,
+		WriteBufferSize:   1024,
+		EnableCompression: h.compressionLevel != 0,
+		Subprotocols:      websocket.Subprotocols,
+		// Browsers send cookies with cross-site upgrades, so other sites must not be
+		// able to open sockets as the user
+		CheckOrigin: h.origins.CheckOrigin,
 	}
 
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
@@ -100,383 +137,471 @@ func (h *WSHandler) HandleConnection(c *gin.Context) {
 		return
 	}
 
-	// Create client and register with hub
-	client := websocket.NewClient(h.hub, conn, userID)
-	h.hub.Register <- client
+	if h.compressionLevel != 0 {
+		if err := conn.SetCompressionLevel(h.compressionLevel); err != nil {
+			log.Printf("Error setting WebSocket compression level: %v", err)
+		}
+	}
+
+	var authReq *websocket.Request
+	if user == nil {
+		var claims *auth.TokenClaims
+		if authReq, claims, err = h.readAuthFrame(c.Request.Context(), conn); err == nil {
+			user, err = h.userService.GetByID(c.Request.Context(), claims.UserID)
+		}
+		if err != nil {
+			log.Printf("WebSocket authentication failed: %v", err)
+			rejectConnection(conn)
+			return
+		}
+		token = tokenOf(claims)
+	}
+
+	// Create client and register with hub. The writer starts first, so a hub
+	// stopping in between still waits for it to send the going away frame.
+	client := websocket.NewClient(h.hub, conn, token.userID)
+	client.Info = clientInfo(c, uuid.NewString(), "websocket")
+	go client.WritePump()
+	h.hub.Register(client)
 
 	// Log the successful connection
-	log.Printf("WebSocket connection established for user: %s (%s)", user.Name, userID)
+	log.Printf("WebSocket connection established for user: %s (%s)", user.Name, token.userID)
+
+	if authReq != nil {
+		authReq.Client = client
+		reply(authReq, authenticated(token.expiresAt))
+	}
+
+	// The request context ends as soon as this handler returns, so the connection
+	// gets its own context that is cancelled when its read loop exits
+	ctx, cancel := context.WithCancel(context.WithoutCancel(c.Request.Context()))
+	ctx = h.trackConnAuth(ctx, client, token)
 
 	// Start server-side goroutines
-	go h.handleMessages(client, user)
-	go client.WritePump()
+	go h.handleMessages(ctx, cancel, client, user)
 }
 
-// handleMessages handles incoming messages from a client
-func (h *WSHandler) handleMessages(client *websocket.Client, user *models.User) {
+// handleMessages handles incoming messages from a client. Queries made on behalf of
+// the client use ctx, which is cancelled once the connection is closed.
+func (h *WSHandler) handleMessages(ctx context.Context, cancel context.CancelFunc, client *websocket.Client, user *models.User) {
 	defer func() {
+		cancel()
+
 		// Recover from any panics
 		if r := recover(); r != nil {
 			log.Printf("Recovered from panic in handleMessages: %v", r)
 		}
 
-		h.hub.Unregister <- client
-		client.Conn.Close()
-
-		// For each room the client was in, send a left message
-		for roomID := range client.Rooms {
-			leftMsg := websocket.Message{
-				Type:      "system",
-				RoomID:    roomID,
-				UserID:    user.ID,
-				Action:    "left",
-				Timestamp: time.Now(),
-				Data:      json.RawMessage(fmt.Sprintf(`{"user_name":"%s"}`, user.Name)),
-				Client:    client,
-			}
+		h.disconnect(client, user)
+	}()
 
-			leftMsgBytes, _ := json.Marshal(leftMsg)
+	ctx = withUser(ctx, user)
 
-			h.hub.Broadcast <- &websocket.Message{
-				Type:   "system",
-				RoomID: roomID,
-				Data:   leftMsgBytes,
-				Client: client,
-			}
-		}
-	}()
+	client.ReadPump(func(msgBytes []byte) {
+		h.router.Dispatch(ctx, client, msgBytes)
+	})
+}
 
-	for {
-		_, msgBytes, err := client.Conn.ReadMessage()
-		if err != nil {
-			if gorillaWs.IsUnexpectedCloseError(err, gorillaWs.CloseGoingAway, gorillaWs.CloseAbnormalClosure) {
-				log.Printf("Error: %v", err)
-			}
-			break
-		}
+// disconnect unregisters a client and tells the rooms it was the user's last
+// client in that the user left
+func (h *WSHandler) disconnect(client *websocket.Client, user *models.User) {
+	// Unregistering removes the client from its rooms, so note them first
+	roomIDs := client.RoomIDs()
 
-		// Log the raw message for debugging
-		log.Printf("Received raw message from client %s: %s", client.ID, string(msgBytes))
-
-		// Parse the raw client message
-		var clientMsg ClientMessage
-		if err := json.Unmarshal(msgBytes, &clientMsg); err != nil {
-			log.Printf("Error parsing message: %v, raw message: %s", err, string(msgBytes))
-			// Send error response to client
-			errResp := ServerResponse{
-				Type:    "error",
-				Success: false,
-				Message: "Invalid message format",
-			}
-			errRespBytes, _ := json.Marshal(errResp)
-			client.Send <- errRespBytes
-			continue
-		}
+	left := h.hub.Unregister(client)
 
-		log.Printf("Parsed message from client %s: %+v", client.ID, clientMsg)
+	for _, roomID := range roomIDs {
+		h.typing.stop(client, roomID)
+	}
+	for _, roomID := range left {
+		h.broadcastPresence(client, user, roomID, "left")
+	}
+}
 
-		// Process different message types
-		switch clientMsg.Type {
-		case "create_thread":
-			// Handle thread creation with server-side UUID
-			var createData struct {
-				Title string `json:"title"`
-			}
+// userContextKey is the context key for the connection's user
+type userContextKey struct{}
 
-			if err := json.Unmarshal(clientMsg.Data, &createData); err != nil {
-				log.Printf("Error parsing thread creation data: %v", err)
-				// Send error response
-				errResp := ServerResponse{
-					Type:    "thread_created",
-					Success: false,
-					Message: "Invalid thread creation data",
-				}
-				errRespBytes, _ := json.Marshal(errResp)
-				client.Send <- errRespBytes
-				continue
-			}
+// withUser returns a connection context for frames sent by user
+func withUser(ctx context.Context, user *models.User) context.Context {
+	return context.WithValue(ctx, userContextKey{}, user)
+}
 
-			// Create the thread in database
-			// UUID is generated inside CreateRoom method
-			room, err := h.chatService.CreateRoom(createData.Title, "", false, user.ID)
-			if err != nil {
-				log.Printf("Error creating room: %v", err)
-
-				// Send error response
-				errResp := ServerResponse{
-					Type:    "thread_created",
-					Success: false,
-					Message: "Failed to create thread",
-				}
-				errRespBytes, _ := json.Marshal(errResp)
-				client.Send <- errRespBytes
-				continue
-			}
+// wsUser returns the user a frame was sent by
+func wsUser(req *websocket.Request) *models.User {
+	return req.Ctx.Value(userContextKey{}).(*models.User)
+}
 
-			// Send response with the new thread ID
-			response := ServerResponse{
-				Type:     "thread_created",
-				Success:  true,
-				ThreadID: room.ID,
-				RoomID:   room.ID, // Same as thread ID in this case
-				Data:     json.RawMessage(fmt.Sprintf(`{"title":"%s"}`, createData.Title)),
-			}
+// reply sends a response to a frame, echoing its request ID
+func reply(req *websocket.Request, resp ServerResponse) {
+	resp.RequestID = req.RequestID
+	respBytes, _ := json.Marshal(resp)
+	req.Client.Send(respBytes)
+}
 
-			responseBytes, _ := json.Marshal(response)
-			client.Send <- responseBytes
-			log.Printf("Thread created: %s", room.ID)
+// Router returns the frame router, so feature packages can register their own frame types
+func (h *WSHandler) Router() *websocket.Router {
+	return h.router
+}
 
-		case "subscribe":
-			// Handle room subscription
-			if clientMsg.RoomID == "" {
-				log.Printf("Subscribe message missing room_id from client %s", client.ID)
-				continue
-			}
+// FrameMetrics returns counts of frames handled by type
+func (h *WSHandler) FrameMetrics() map[string]websocket.FrameStats {
+	return h.frameMetrics.Snapshot()
+}
 
-			log.Printf("Client %s subscribing to room %s", client.ID, clientMsg.RoomID)
+// newRouter registers the built-in frame types, with every frame passing
+// frameLimit, which rate limits frames
+func (h *WSHandler) newRouter(frameLimit websocket.Middleware) *websocket.Router {
+	router := websocket.NewRouter()
+	router.Use(
+		websocket.Logging(),
+		h.frameMetrics.Middleware(),
+		websocket.Recovery(),
+		frameLimit,
+	)
+	router.OnError(h.handleFrameError)
+
+	router.Handle("auth", h.handleAuth)
+	router.Handle("create_thread", h.handleCreateThread, websocket.Timeout(AckTimeout))
+	router.Handle("subscribe", h.handleSubscribe, websocket.RequireRoomID(), h.requireRoomMember())
+	router.Handle("unsubscribe", h.handleUnsubscribe, websocket.RequireRoomID())
+	router.Handle("message", h.handleChatMessage, websocket.RequireSubscription(), websocket.Timeout(AckTimeout))
+	router.Handle("typing", h.handleTyping, websocket.RequireSubscription())
+	router.Handle("read", h.handleRead, websocket.RequireSubscription())
+
+	return router
+}
 
-			// Subscribe client to room
-			h.hub.Subscribe <- &websocket.Subscription{
-				Client: client,
-				Room:   clientMsg.RoomID,
+// requireRoomMember rejects frames for rooms the user is not a member of
+func (h *WSHandler) requireRoomMember() websocket.Middleware {
+	return func(next websocket.HandlerFunc) websocket.HandlerFunc {
+		return func(req *websocket.Request) error {
+			isMember, err := h.chatService.IsUserMemberOfRoom(req.Ctx, wsUser(req).ID, req.RoomID)
+			if err != nil {
+				return err
 			}
-
-			// Send a joined message to the room
-			joinedMsg := websocket.Message{
-				Type:      "system",
-				RoomID:    clientMsg.RoomID,
-				UserID:    user.ID,
-				Action:    "joined",
-				Timestamp: time.Now(),
-				Data:      json.RawMessage(fmt.Sprintf(`{"user_name":"%s"}`, user.Name)),
+			if !isMember {
+				return service.ErrNotRoomMember
 			}
+			return next(req)
+		}
+	}
+}
 
-			joinedBytes, _ := json.Marshal(joinedMsg)
+// handleFrameError tells the client about frames that failed. Frames that are
+// acknowledged get a failed acknowledgement; other frames get an error frame if
+// the client asked for a response by setting a request ID. Rate limited frames
+// get a rate_limited frame instead, on the same terms.
+func (h *WSHandler) handleFrameError(req *websocket.Request, err error) {
+	acknowledged := req.Type == "message" || req.Type == "create_thread" || req.Type == "auth"
+
+	var limited *websocket.RateLimitError
+	switch {
+	case errors.As(err, &limited) && (acknowledged || req.RequestID != ""):
+		reply(req, ServerResponse{
+			Type:        "rate_limited",
+			Success:     false,
+			RoomID:      req.RoomID,
+			ClientMsgID: req.ClientMsgID,
+			Message:     fmt.Sprintf("Too many %s frames", req.Type),
+			RetryAfter:  max(limited.RetryAfter.Milliseconds(), 1),
+		})
+
+	case errors.Is(err, websocket.ErrInvalidFrame):
+		log.Printf("Error parsing message from client %s, raw message: %s", req.Client.ID, string(req.Raw))
+		reply(req, ServerResponse{
+			Type:    "error",
+			Success: false,
+			Message: "Invalid message format",
+		})
+
+	case req.Type == "message":
+		reply(req, ServerResponse{
+			Type:        "message_sent",
+			Success:     false,
+			RoomID:      req.RoomID,
+			ClientMsgID: req.ClientMsgID,
+			Message:     frameErrorMessage(err, "Failed to save message"),
+		})
+
+	case req.Type == "auth":
+		reply(req, ServerResponse{
+			Type:    "authenticated",
+			Success: false,
+			Message: frameErrorMessage(err, "Authentication failed"),
+		})
+
+	case req.Type == "create_thread":
+		reply(req, ServerResponse{
+			Type:    "thread_created",
+			Success: false,
+			Message: frameErrorMessage(err, "Failed to create thread"),
+		})
+
+	case errors.Is(err, service.ErrNotRoomMember) || req.RequestID != "":
+		reply(req, ServerResponse{
+			Type:    "error",
+			Success: false,
+			RoomID:  req.RoomID,
+			Message: frameErrorMessage(err, "Request failed"),
+		})
+	}
+}
 
-			// Broadcast to all clients in the room
-			h.hub.Broadcast <- &websocket.Message{
-				RoomID: clientMsg.RoomID,
-				Data:   joinedBytes,
-				Client: client,
-			}
+// frameErrorMessage describes a frame error to the client, or returns fallback
+// for errors the client can't do anything about
+func frameErrorMessage(err error, fallback string) string {
+	switch {
+	case errors.Is(err, websocket.ErrUnknownFrameType):
+		return "Unknown frame type"
+	case errors.Is(err, websocket.ErrMissingRoomID):
+		return "Missing room_id"
+	case errors.Is(err, websocket.ErrNotSubscribed):
+		return "Not subscribed to room"
+	case errors.Is(err, websocket.ErrRateLimited):
+		return "Rate limited"
+	case errors.Is(err, service.ErrNotRoomMember):
+		return "Not a member of this room"
+	case errors.Is(err, service.ErrInvalidClientMsgID):
+		return "Invalid client_msg_id"
+	case errors.Is(err, service.ErrClientMsgIDReused):
+		return "client_msg_id already used for a different message"
+	case errors.Is(err, errInvalidFrameData):
+		return "Invalid frame data"
+	case errors.Is(err, errMissingContent):
+		return "Missing content"
+	case errors.Is(err, errInvalidToken):
+		return "Invalid token"
+	case errors.Is(err, errTokenUserMismatch):
+		return "Token is for a different user"
+	default:
+		return fallback
+	}
+}
 
-			// Send recent messages history to the client
-			go h.sendRoomHistory(client, clientMsg.RoomID)
+// handleCreateThread creates a room with a server-side ID
+func (h *WSHandler) handleCreateThread(req *websocket.Request) error {
+	var createData struct {
+		Title string `json:"title"`
+	}
 
-		case "unsubscribe":
-			// Handle room unsubscription
-			if clientMsg.RoomID == "" {
-				log.Printf("Unsubscribe message missing room_id from client %s", client.ID)
-				continue
-			}
+	if err := json.Unmarshal(req.Data, &createData); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidFrameData, err)
+	}
 
-			log.Printf("Client %s unsubscribing from room %s", client.ID, clientMsg.RoomID)
+	// Create the thread in database
+	// UUID is generated inside CreateRoom method
+	room, err := h.chatService.CreateRoom(req.Ctx, createData.Title, "", false, wsUser(req).ID)
+	if err != nil {
+		return fmt.Errorf("creating room: %w", err)
+	}
 
-			// Unsubscribe client from room
-			h.hub.Unsubscribe <- &websocket.Subscription{
-				Client: client,
-				Room:   clientMsg.RoomID,
-			}
+	// Send response with the new thread ID
+	title, _ := json.Marshal(map[string]string{"title": createData.Title})
+	reply(req, ServerResponse{
+		Type:     "thread_created",
+		Success:  true,
+		ThreadID: room.ID,
+		RoomID:   room.ID, // Same as thread ID in this case
+		Data:     title,
+	})
+
+	return nil
+}
 
-			// Send a left message to the room
-			leftMsg := websocket.Message{
-				Type:      "system",
-				RoomID:    clientMsg.RoomID,
-				UserID:    user.ID,
-				Action:    "left",
-				Timestamp: time.Now(),
-				Data:      json.RawMessage(fmt.Sprintf(`{"user_name":"%s"}`, user.Name)),
-			}
+// handleSubscribe subscribes the client to a room and sends it the room's recent
+// history. The room is only told the user joined if no other device of theirs is in it.
+func (h *WSHandler) handleSubscribe(req *websocket.Request) error {
+	first := h.hub.Subscribe(&websocket.Subscription{
+		Client: req.Client,
+		Room:   req.RoomID,
+	})
 
-			leftBytes, _ := json.Marshal(leftMsg)
+	if first {
+		h.broadcastPresence(req.Client, wsUser(req), req.RoomID, "joined")
+	}
 
-			// Broadcast to all clients in the room
-			h.hub.Broadcast <- &websocket.Message{
-				RoomID: clientMsg.RoomID,
-				Data:   leftBytes,
-				Client: client,
-			}
+	// Tell the client who is already typing, as it missed their typing frames
+	if typers := h.typing.typers(req.RoomID); len(typers) > 0 {
+		users := make([]map[string]string, len(typers))
+		for i, typer := range typers {
+			users[i] = map[string]string{"user_id": typer.ID, "user_name": typer.Name}
+		}
+		state, _ := json.Marshal(map[string]interface{}{
+			"type":    "typing_state",
+			"room_id": req.RoomID,
+			"data":    map[string]interface{}{"users": users},
+		})
+		req.Client.Send(state)
+	}
 
-		case "message":
-			// Handle chat message
-			if clientMsg.RoomID == "" || clientMsg.Content == "" {
-				log.Printf("Message missing room_id or content from client %s", client.ID)
-				continue
-			}
+	// Send recent messages history to the client
+	go h.sendRoomHistory(req.Ctx, req.Client, req.RoomID)
 
-			// Verify client is in the room
-			if !client.IsInRoom(clientMsg.RoomID) {
-				log.Printf("Client %s attempted to send message to room %s without subscription", client.ID, clientMsg.RoomID)
-				// Send error response
-				errResp := ServerResponse{
-					Type:    "message_sent",
-					Success: false,
-					RoomID:  clientMsg.RoomID,
-					Message: "Not subscribed to room",
-				}
-				errRespBytes, _ := json.Marshal(errResp)
-				client.Send <- errRespBytes
-				continue
-			}
+	return nil
+}
 
-			log.Printf("Client %s sending message to room %s: %s", client.ID, clientMsg.RoomID, clientMsg.Content)
+// handleUnsubscribe unsubscribes the client from a room. The room is only told
+// the user left if it was their last device in it.
+func (h *WSHandler) handleUnsubscribe(req *websocket.Request) error {
+	last := h.hub.Unsubscribe(&websocket.Subscription{
+		Client: req.Client,
+		Room:   req.RoomID,
+	})
+
+	h.typing.stop(req.Client, req.RoomID)
+	if last {
+		h.broadcastPresence(req.Client, wsUser(req), req.RoomID, "left")
+	}
 
-			// Save message to database
-			dbMsg, err := h.chatService.SendMessage(clientMsg.RoomID, user.ID, clientMsg.Content)
-			if err != nil {
-				log.Printf("Error saving message: %v", err)
-				// Send error response
-				errResp := ServerResponse{
-					Type:    "message_sent",
-					Success: false,
-					RoomID:  clientMsg.RoomID,
-					Message: "Failed to save message",
-				}
-				errRespBytes, _ := json.Marshal(errResp)
-				client.Send <- errRespBytes
-				continue
-			}
+	return nil
+}
 
-			// FIX: Create a proper message object with all fields directly in the main structure
-			// Don't nest important fields in the Data property
-			messageObj := map[string]interface{}{
-				"type":        "message",
-				"id":          dbMsg.ID,
-				"room_id":     clientMsg.RoomID,
-				"user_id":     user.ID,
-				"content":     clientMsg.Content,
-				"created_at":  dbMsg.CreatedAt,
-				"updated_at":  dbMsg.UpdatedAt,
-				"user_name":   user.Name,
-				"user_avatar": user.Avatar,
-			}
+// broadcastPresence tells a room's other clients that a user joined or left
+func (h *WSHandler) broadcastPresence(client *websocket.Client, user *models.User, roomID, action string) {
+	userName, _ := json.Marshal(map[string]string{"user_name": user.Name})
+	presenceMsg := websocket.Message{
+		Type:      "system",
+		RoomID:    roomID,
+		UserID:    user.ID,
+		Action:    action,
+		Timestamp: time.Now(),
+		Data:      userName,
+	}
 
-			respBytes, _ := json.Marshal(messageObj)
+	presenceBytes, _ := json.Marshal(presenceMsg)
 
-			// Send confirmation back to the sender with the message ID
-			confirmMsg := ServerResponse{
-				Type:      "message_sent",
-				Success:   true,
-				RoomID:    clientMsg.RoomID,
-				MessageID: dbMsg.ID,
-			}
-			confirmBytes, _ := json.Marshal(confirmMsg)
-			client.Send <- confirmBytes
-
-			// Broadcast to all clients in the room
-			h.hub.Broadcast <- &websocket.Message{
-				RoomID: clientMsg.RoomID,
-				Data:   respBytes,
-				Client: client,
-			}
+	// Broadcast to all clients in the room
+	h.hub.Broadcast(&websocket.Message{
+		RoomID:    roomID,
+		Data:      presenceBytes,
+		Client:    client,
+		Ephemeral: true,
+	})
+}
 
-			log.Printf("Message broadcast to room %s, message ID: %s", clientMsg.RoomID, dbMsg.ID)
+// handleChatMessage saves a chat message and broadcasts it to the room
+func (h *WSHandler) handleChatMessage(req *websocket.Request) error {
+	if req.Content == "" {
+		return errMissingContent
+	}
 
-		case "typing":
-			// Handle typing indicator
-			if clientMsg.RoomID == "" {
-				log.Printf("Typing message missing room_id from client %s", client.ID)
-				continue
-			}
+	dbMsg, created, err := h.sendChatMessage(req.Ctx, wsUser(req), req.RoomID, req.Content, req.ClientMsgID, req.Client)
+	if err != nil {
+		return fmt.Errorf("saving message: %w", err)
+	}
 
-			// Verify client is in the room
-			if !client.IsInRoom(clientMsg.RoomID) {
-				log.Printf("Client %s attempted to send typing indicator to room %s without subscription", client.ID, clientMsg.RoomID)
-				continue
-			}
+	reply(req, ServerResponse{
+		Type:        "message_sent",
+		Success:     true,
+		RoomID:      req.RoomID,
+		MessageID:   dbMsg.ID,
+		ClientMsgID: req.ClientMsgID,
+		Duplicate:   !created,
+	})
 
-			// Extract typing status from data
-			var typingData struct {
-				IsTyping bool `json:"is_typing"`
-			}
+	return nil
+}
 
-			if err := json.Unmarshal(clientMsg.Data, &typingData); err != nil {
-				log.Printf("Error parsing typing data: %v", err)
-				continue
-			}
+// sendChatMessage saves a chat message and broadcasts it to the room's clients
+// other than sender, which may be nil. A retried send with the same client
+// message ID returns the saved message with created false, and isn't broadcast again.
+func (h *WSHandler) sendChatMessage(ctx context.Context, user *models.User, roomID, content, clientMsgID string, sender *websocket.Client) (*models.Message, bool, error) {
+	dbMsg, created, err := h.chatService.SendMessageOnce(ctx, roomID, user.ID, content, clientMsgID)
+	if err != nil || !created {
+		return dbMsg, created, err
+	}
 
-			// Create typing message
-			typingObj := map[string]interface{}{
-				"type":      "typing",
-				"room_id":   clientMsg.RoomID,
-				"user_id":   user.ID,
-				"timestamp": time.Now(),
-				"data": map[string]interface{}{
-					"user_name": user.Name,
-					"is_typing": typingData.IsTyping,
-				},
-			}
+	// FIX: Create a proper message object with all fields directly in the main structure
+	// Don't nest important fields in the Data property
+	messageObj := map[string]interface{}{
+		"type":        "message",
+		"id":          dbMsg.ID,
+		"room_id":     roomID,
+		"user_id":     user.ID,
+		"content":     content,
+		"created_at":  dbMsg.CreatedAt,
+		"updated_at":  dbMsg.UpdatedAt,
+		"user_name":   user.Name,
+		"user_avatar": user.Avatar,
+	}
 
-			typingBytes, _ := json.Marshal(typingObj)
+	respBytes, _ := json.Marshal(messageObj)
 
-			// Broadcast to all clients in the room
-			h.hub.Broadcast <- &websocket.Message{
-				RoomID: clientMsg.RoomID,
-				Data:   typingBytes,
-				Client: client,
-			}
+	// Broadcast to all clients in the room
+	h.hub.Broadcast(&websocket.Message{
+		RoomID: roomID,
+		Data:   respBytes,
+		Client: sender,
+	})
 
-		case "read":
-			// Handle read receipts
-			if clientMsg.RoomID == "" {
-				log.Printf("Read message missing room_id from client %s", client.ID)
-				continue
-			}
+	return dbMsg, true, nil
+}
 
-			// Verify client is in the room
-			if !client.IsInRoom(clientMsg.RoomID) {
-				log.Printf("Client %s attempted to send read receipt to room %s without subscription", client.ID, clientMsg.RoomID)
-				continue
-			}
+// handleTyping updates the user's typing state in the room, which is broadcast
+// to the room when it changes
+func (h *WSHandler) handleTyping(req *websocket.Request) error {
+	var typingData struct {
+		IsTyping bool `json:"is_typing"`
+	}
 
-			// Extract message IDs from data
-			var readData struct {
-				MessageIDs []string `json:"message_ids"`
-			}
+	if err := json.Unmarshal(req.Data, &typingData); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidFrameData, err)
+	}
 
-			if err := json.Unmarshal(clientMsg.Data, &readData); err != nil {
-				log.Printf("Error parsing read data: %v", err)
-				continue
-			}
+	h.typing.update(req.Client, wsUser(req), req.RoomID, typingData.IsTyping)
 
-			// Mark each message as read
-			for _, msgID := range readData.MessageIDs {
-				if err := h.chatService.MarkMessageAsRead(msgID, user.ID); err != nil {
-					log.Printf("Error marking message as read: %v", err)
-				}
-			}
+	return nil
+}
 
-			// Create read message
-			readObj := map[string]interface{}{
-				"type":        "read",
-				"room_id":     clientMsg.RoomID,
-				"user_id":     user.ID,
-				"timestamp":   time.Now(),
-				"message_ids": readData.MessageIDs,
-			}
+// handleRead marks messages as read, broadcasts the read receipt to the room and
+// syncs it to the user's other devices
+func (h *WSHandler) handleRead(req *websocket.Request) error {
+	var readData struct {
+		MessageIDs []string `json:"message_ids"`
+	}
 
-			readBytes, _ := json.Marshal(readObj)
+	if err := json.Unmarshal(req.Data, &readData); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidFrameData, err)
+	}
 
-			// Broadcast to all clients in the room
-			h.hub.Broadcast <- &websocket.Message{
-				RoomID: clientMsg.RoomID,
-				Data:   readBytes,
-				Client: client,
-			}
+	user := wsUser(req)
 
-		default:
-			log.Printf("Unknown message type from client %s: %s", client.ID, clientMsg.Type)
+	// Mark each message as read
+	for _, msgID := range readData.MessageIDs {
+		if err := h.chatService.MarkMessageAsRead(req.Ctx, msgID, user.ID); err != nil {
+			log.Printf("Error marking message as read: %v", err)
 		}
 	}
-}
 
-// sendRoomHistory sends recent message history to a new client
-// server/internal/handler/ws_handler.go
+	// Create read message
+	readObj := map[string]interface{}{
+		"type":        "read",
+		"room_id":     req.RoomID,
+		"user_id":     user.ID,
+		"timestamp":   time.Now(),
+		"message_ids": readData.MessageIDs,
+	}
+
+	readBytes, _ := json.Marshal(readObj)
+
+	// Broadcast to all clients in the room
+	h.hub.Broadcast(&websocket.Message{
+		RoomID: req.RoomID,
+		Data:   readBytes,
+		Client: req.Client,
+	})
+
+	h.sendSelfSync(req.Client, "read", req.RoomID, map[string]interface{}{
+		"message_ids": readData.MessageIDs,
+	})
+
+	return nil
+}
 
 // sendRoomHistory sends recent message history to a new client
-func (h *WSHandler) sendRoomHistory(client *websocket.Client, roomID string) {
+func (h *WSHandler) sendRoomHistory(ctx context.Context, client *websocket.Client, roomID string) {
 	// Get recent messages for the room (e.g., last 50)
-	messages, err := h.chatService.GetRoomMessages(roomID, 50, 0)
+	messages, err := h.chatService.GetRoomMessages(ctx, roomID, 50, 0)
 	if err != nil {
 		log.Printf("Error fetching room messages: %v", err)
 		return
@@ -506,14 +631,16 @@ func (h *WSHandler) sendRoomHistory(client *websocket.Client, roomID string) {
 		historyBytes, _ := json.Marshal(historyObj)
 
 		// Send directly to the client
-		select {
-		case client.Send <- historyBytes:
-		default:
-			// If client's buffer is full, stop sending history
+		if !client.Send(historyBytes) {
+			// If client's queue is full, stop sending history
 			return
 		}
 
-		// Small delay to avoid flooding the client
-		time.Sleep(5 * time.Millisecond)
+		// Small delay to avoid flooding the client, stopping if the connection closes
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Millisecond):
+		}
 	}
 }
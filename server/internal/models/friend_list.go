@@ -0,0 +1,19 @@
+// internal/models/friend_list.go
+package models
+
+import "time"
+
+// FriendList represents a named list a user organises their friends into
+type FriendList struct {
+	ID        string    `json:"id" db:"id"`
+	OwnerID   string    `json:"owner_id" db:"owner_id"`
+	Name      string    `json:"name" db:"name"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// FriendListWithMembers represents a friend list along with its members
+type FriendListWithMembers struct {
+	FriendList
+	Members []*User `json:"members"`
+}
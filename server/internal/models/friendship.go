@@ -31,3 +31,12 @@ type FriendshipWithUser struct {
 	FriendEmail  string `json:"friend_email" db:"friend_email"`
 	FriendAvatar string `json:"friend_avatar" db:"friend_avatar"`
 }
+
+// FriendSuggestion represents a suggested friend and the reason they were suggested
+type FriendSuggestion struct {
+	User
+	MutualFriends  int    `json:"mutual_friends" db:"mutual_friends"`
+	SharedRooms    int    `json:"shared_rooms" db:"shared_rooms"`
+	SharedRoomName string `json:"-" db:"shared_room_name"`
+	Reason         string `json:"reason,omitempty" db:"-"`
+}
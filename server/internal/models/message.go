@@ -11,6 +11,9 @@ type Message struct {
 	Content   string    `json:"content" db:"content"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+
+	// ClientMsgID is the sender's own ID for the message, used to deduplicate retried sends
+	ClientMsgID *string `json:"client_msg_id,omitempty" db:"client_msg_id"`
 }
 
 // MessageDTO represents a message with user information
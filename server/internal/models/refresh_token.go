@@ -3,12 +3,16 @@ package models
 
 import "time"
 
-// RefreshToken represents a refresh token in the system
+// RefreshToken represents a refresh token in the system. Only a hash of the
+// token is stored. Tokens issued by rotating another share its family, so a
+// replayed token can revoke every token descended from the same login.
 type RefreshToken struct {
-	ID        string    `json:"id" db:"id"`
-	UserID    string    `json:"user_id" db:"user_id"`
-	Token     string    `json:"token" db:"token"`
-	ExpiresAt time.Time `json:"expires_at" db:"expires_at"`
-	IsRevoked bool      `json:"is_revoked" db:"is_revoked"`
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	ID        string     `json:"id" db:"id"`
+	UserID    string     `json:"user_id" db:"user_id"`
+	FamilyID  string     `json:"family_id" db:"family_id"`
+	TokenHash string     `json:"-" db:"token_hash"`
+	ExpiresAt time.Time  `json:"expires_at" db:"expires_at"`
+	IsRevoked bool       `json:"is_revoked" db:"is_revoked"`
+	RotatedAt *time.Time `json:"rotated_at,omitempty" db:"rotated_at"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
 }
@@ -9,7 +9,8 @@ type Room struct {
 	Description string    `json:"description" db:"description"`
 	CreatorID   string    `json:"creator_id" db:"creator_id"`
 	IsPrivate   bool      `json:"is_private" db:"is_private"`
-	Type        string    `json:"type" db:"type"` // "group" or "direct"
+	Type        string    `json:"type" db:"type"` // "group", "direct" or "group_dm"
+	MemberHash  *string   `json:"-" db:"member_hash"`
 	CreatedAt   time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
 }
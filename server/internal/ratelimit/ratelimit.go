@@ -0,0 +1,103 @@
+// internal/ratelimit/ratelimit.go
+
+// Package ratelimit applies the configured token bucket limits to HTTP endpoints
+// and WebSocket frames
+package ratelimit
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mjxoro/sent/server/internal/config"
+	"github.com/mjxoro/sent/server/internal/repository"
+	"github.com/mjxoro/sent/server/pkg/websocket"
+)
+
+// Limiter checks requests and frames against the configured limits
+type Limiter struct {
+	buckets repository.RateLimiter
+	limits  config.RateLimitConfig
+}
+
+// New creates a limiter that keeps its buckets in buckets
+func New(buckets repository.RateLimiter, limits config.RateLimitConfig) *Limiter {
+	return &Limiter{
+		buckets: buckets,
+		limits:  limits,
+	}
+}
+
+// Endpoint returns Gin middleware that limits requests to the named endpoint per
+// user, or per client IP for requests without a user. Rejected requests get 429
+// with a Retry-After header. Endpoints without a configured limit aren't limited.
+func (l *Limiter) Endpoint(name string) gin.HandlerFunc {
+	limit, ok := l.limits.Endpoints[name]
+	if !ok {
+		return func(c *gin.Context) {
+			c.Next()
+		}
+	}
+
+	return func(c *gin.Context) {
+		who := c.GetString("userID")
+		if who == "" {
+			who = "ip:" + c.ClientIP()
+		}
+
+		allowed, retryAfter := l.allow(c.Request.Context(), "endpoint:"+name+":"+who, limit)
+		if !allowed {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+				"error":          "rate limited",
+				"retry_after_ms": retryAfter.Milliseconds(),
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// Frames returns WebSocket router middleware that limits each user's frames by
+// type, and each room's frames by type across all of its subscribers. Rejected
+// frames fail with a websocket.RateLimitError.
+func (l *Limiter) Frames() websocket.Middleware {
+	return websocket.RateLimit(func(req *websocket.Request) (bool, time.Duration) {
+		// Types without their own limit share one bucket, so inventing types doesn't help
+		frameType := req.Type
+		limit, ok := l.limits.Frames[frameType]
+		if !ok {
+			frameType = "*"
+			limit, ok = l.limits.Frames[frameType]
+		}
+		if ok {
+			if allowed, retryAfter := l.allow(req.Ctx, "frame:"+frameType+":"+req.Client.ID, limit); !allowed {
+				return false, retryAfter
+			}
+		}
+
+		// Only frames from the room's subscribers count against it, so outsiders
+		// can't use up a room's limit
+		if limit, ok := l.limits.RoomFrames[req.Type]; ok && req.RoomID != "" && req.Client.IsInRoom(req.RoomID) {
+			if allowed, retryAfter := l.allow(req.Ctx, "room:"+req.Type+":"+req.RoomID, limit); !allowed {
+				return false, retryAfter
+			}
+		}
+		return true, 0
+	})
+}
+
+// allow takes a token from key's bucket. If the buckets can't be reached, the
+// request is allowed rather than failing everything.
+func (l *Limiter) allow(ctx context.Context, key string, limit config.RateLimit) (bool, time.Duration) {
+	allowed, retryAfter, err := l.buckets.Allow(ctx, key, limit.Burst, limit.Period)
+	if err != nil {
+		log.Printf("Rate limiter unavailable, allowing %s: %v", key, err)
+		return true, 0
+	}
+	return allowed, retryAfter
+}
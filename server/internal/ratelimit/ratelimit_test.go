@@ -0,0 +1,53 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mjxoro/sent/server/internal/config"
+	"github.com/mjxoro/sent/server/internal/db/memory"
+)
+
+func TestEndpointRefillsOverPeriod(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	limiter := New(memory.NewRateLimiter(), config.RateLimitConfig{
+		Endpoints: map[string]config.RateLimit{"ping": {Burst: 2, Period: 200 * time.Millisecond}},
+	})
+
+	r := gin.New()
+	r.GET("/ping", limiter.Endpoint("ping"), func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	r.GET("/free", limiter.Endpoint("free"), func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := get("/ping"); w.Code != http.StatusNoContent {
+			t.Fatalf("request %d: expected 204, got %d", i, w.Code)
+		}
+	}
+
+	w := get("/ping")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" {
+		t.Fatalf("expected 429 with Retry-After 1, got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	// One token refills every 100ms
+	time.Sleep(110 * time.Millisecond)
+	if w := get("/ping"); w.Code != http.StatusNoContent {
+		t.Fatalf("expected a token to have refilled, got %d", w.Code)
+	}
+
+	// Endpoints without a limit aren't limited
+	for i := 0; i < 5; i++ {
+		if w := get("/free"); w.Code != http.StatusNoContent {
+			t.Fatalf("expected an unlimited endpoint to allow request %d, got %d", i, w.Code)
+		}
+	}
+}
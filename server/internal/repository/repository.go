@@ -0,0 +1,133 @@
+// internal/repository/repository.go
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/mjxoro/sent/server/internal/models"
+)
+
+// User stores users
+type User interface {
+	FindByID(ctx context.Context, id string) (*models.User, error)
+	FindByIDs(ctx context.Context, ids []string) ([]*models.User, error)
+	FindByEmail(ctx context.Context, email string) (*models.User, error)
+	FindByOAuthID(ctx context.Context, oauthID string, provider string) (*models.User, error)
+	Create(ctx context.Context, user *models.User) error
+	Update(ctx context.Context, user *models.User) error
+}
+
+// Room stores rooms and their memberships
+type Room interface {
+	FindByID(ctx context.Context, id string) (*models.Room, error)
+	FindRoomsByUserID(ctx context.Context, userID string) ([]*models.Room, error)
+	Create(ctx context.Context, room *models.Room) error
+	CreateIfNotExists(ctx context.Context, room *models.Room) (bool, error)
+	FindByMemberHash(ctx context.Context, memberHash string) (*models.Room, error)
+	AddMember(ctx context.Context, roomID, userID, role string) error
+	GetRoomMembers(ctx context.Context, roomID string) ([]*models.User, error)
+	Delete(ctx context.Context, id string) error
+}
+
+// Message stores messages and their read status
+type Message interface {
+	Create(ctx context.Context, message *models.Message) error
+	CreateIfNotExists(ctx context.Context, message *models.Message) (bool, error)
+	FindByClientMsgID(ctx context.Context, userID, clientMsgID string) (*models.Message, error)
+	FindByRoomID(ctx context.Context, roomID string, limit, offset int) ([]*models.MessageDTO, error)
+	FindByID(ctx context.Context, id string) (*models.Message, error)
+	MarkAsRead(ctx context.Context, messageID, userID string) error
+	GetUnreadCount(ctx context.Context, roomID, userID string) (int, error)
+}
+
+// Friendship stores friendships and friend suggestion dismissals
+type Friendship interface {
+	Create(ctx context.Context, friendship *models.Friendship) error
+	FindByID(ctx context.Context, id string) (*models.Friendship, error)
+	FindByIDForUpdate(ctx context.Context, id string) (*models.Friendship, error)
+	FindByUserAndFriend(ctx context.Context, userID, friendID string) (*models.Friendship, error)
+	FindFriendsByUserID(ctx context.Context, userID string, status models.FriendshipStatus) ([]*models.FriendshipWithUser, error)
+	FindAllUserRelationships(ctx context.Context, userID string) ([]*models.FriendshipWithUser, error)
+	FindPendingRequests(ctx context.Context, userID string) ([]*models.FriendshipWithUser, error)
+	UpdateStatus(ctx context.Context, id string, status models.FriendshipStatus) error
+	Delete(ctx context.Context, id string) error
+	FindSuggestions(ctx context.Context, userID string, limit, offset int) ([]*models.FriendSuggestion, error)
+	DismissSuggestion(ctx context.Context, userID, suggestedUserID string) error
+}
+
+// FriendList stores friend lists and their members
+type FriendList interface {
+	Create(ctx context.Context, list *models.FriendList) error
+	FindByID(ctx context.Context, id string) (*models.FriendList, error)
+	FindByOwnerID(ctx context.Context, ownerID string) ([]*models.FriendList, error)
+	UpdateName(ctx context.Context, id, name string) error
+	Delete(ctx context.Context, id string) error
+	AddMember(ctx context.Context, listID, userID string) error
+	RemoveMember(ctx context.Context, listID, userID string) error
+	GetMembers(ctx context.Context, listID string) ([]*models.User, error)
+}
+
+// RefreshToken stores refresh tokens by their hash
+type RefreshToken interface {
+	Create(ctx context.Context, token *models.RefreshToken) error
+	GetByHash(ctx context.Context, tokenHash string) (*models.RefreshToken, error)
+	// MarkRotated records that a token was exchanged for a new one. It reports
+	// false if the token was already rotated or revoked.
+	MarkRotated(ctx context.Context, id string) (bool, error)
+	Revoke(ctx context.Context, userID, tokenHash string) error
+	RevokeFamily(ctx context.Context, familyID string) error
+	RevokeAllForUser(ctx context.Context, userID string) error
+	DeleteExpired(ctx context.Context) (int64, error)
+}
+
+// Set groups one of each repository, bound either to the store directly or to a transaction
+type Set struct {
+	Users         User
+	Rooms         Room
+	Messages      Message
+	Friendships   Friendship
+	FriendLists   FriendList
+	RefreshTokens RefreshToken
+}
+
+// Transactor runs a unit of work. Statements run through the repositories passed
+// to fn commit if it returns nil and roll back if it returns an error.
+type Transactor interface {
+	WithTx(ctx context.Context, fn func(tx *Set) error) error
+}
+
+// ErrCacheMiss is returned by Cache.Get and Cache.Take for keys that don't exist or have expired
+var ErrCacheMiss = errors.New("cache miss")
+
+// Cache stores short-lived values such as presence and unread counts
+type Cache interface {
+	Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error
+	// Get returns ErrCacheMiss if key doesn't exist
+	Get(ctx context.Context, key string, result interface{}) error
+	// Take is Get that also deletes the key, atomically, so only one caller gets the value
+	Take(ctx context.Context, key string, result interface{}) error
+	Delete(ctx context.Context, key string) error
+	Exists(ctx context.Context, key string) (bool, error)
+	GetUserOnlineStatus(ctx context.Context, userIDs []string) (map[string]bool, error)
+	SetUserOnline(ctx context.Context, userID string, duration time.Duration) error
+	GetUnreadMessageCount(ctx context.Context, userID, roomID string) (int, error)
+	IncrementUnreadCount(ctx context.Context, userID, roomID string) error
+	ResetUnreadCount(ctx context.Context, userID, roomID string) error
+}
+
+// RateLimiter keeps a token bucket per key
+type RateLimiter interface {
+	// Allow takes a token from key's bucket, which holds burst tokens and refills
+	// completely over period. If the bucket is empty it returns false and how long
+	// until a token is available.
+	Allow(ctx context.Context, key string, burst int, period time.Duration) (bool, time.Duration, error)
+}
+
+// PubSub publishes JSON messages to channels and delivers them to subscribers
+type PubSub interface {
+	PublishMessage(ctx context.Context, channel string, message interface{}) error
+	Subscribe(ctx context.Context, channel string, handler func([]byte))
+	SubscribeToRooms(ctx context.Context, roomIDs []string, handler func(string, []byte))
+}
@@ -0,0 +1,347 @@
+// internal/server/router.go
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+	"github.com/mjxoro/sent/server/internal/auth"
+	"github.com/mjxoro/sent/server/internal/config"
+	"github.com/mjxoro/sent/server/internal/handler"
+	"github.com/mjxoro/sent/server/internal/ratelimit"
+	"github.com/mjxoro/sent/server/internal/service"
+	"github.com/mjxoro/sent/server/pkg/websocket"
+)
+
+// Dependencies holds everything the router's handlers need
+type Dependencies struct {
+	UserService         *service.UserService
+	ChatService         *service.ChatService
+	RefreshTokenService *service.RefreshTokenService
+	FriendshipService   *service.FriendshipService
+	FriendListService   *service.FriendListService
+	OAuthService        *auth.OAuthService
+	JWTService          *auth.JWTService
+	WSTicketService     *service.WSTicketService
+	TokenRevocations    *service.TokenRevocationService
+	Hub                 *websocket.Hub
+	RateLimiter         *ratelimit.Limiter
+
+	// WebSocket configures WebSocket compression and typing indicators
+	WebSocket config.WebSocketConfig
+
+	// Origins are the browser origins allowed to make cross-origin requests and open WebSockets
+	Origins *auth.OriginAllowlist
+}
+
+// NewRouter creates the Gin router with every API route registered
+func NewRouter(deps Dependencies) *gin.Engine {
+	// Initialize handlers
+	wsHandler := handler.NewWSHandler(deps.Hub, deps.ChatService, deps.UserService, deps.JWTService, deps.WSTicketService, deps.TokenRevocations, deps.Origins, deps.RateLimiter.Frames(), deps.WebSocket)
+	authHandler := handler.NewAuthHandler(deps.OAuthService, deps.JWTService, deps.UserService, deps.RefreshTokenService, deps.TokenRevocations, wsHandler)
+	eventsHandler := handler.NewEventsHandler(wsHandler)
+	messageHandler := handler.NewMessageHandler(wsHandler, eventsHandler)
+	friendshipHandler := handler.NewFriendshipHandler(deps.FriendshipService)
+	friendListHandler := handler.NewFriendListHandler(deps.FriendListService)
+
+	// Create router
+	r := gin.Default()
+
+	// Add CORS middleware
+	r.Use(cors.New(cors.Config{
+		AllowOriginFunc:  deps.Origins.AllowCORSOrigin,
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "Idempotency-Key"},
+		ExposeHeaders:    []string{"Content-Length", "Idempotent-Replayed"},
+		AllowCredentials: true,
+		MaxAge:           12 * time.Hour,
+	}))
+
+	// Health check endpoint
+	r.GET("/health", func(c *gin.Context) {
+		c.JSON(200, gin.H{
+			"status":    "ok",
+			"websocket": deps.Hub.Metrics(),
+			"frames":    wsHandler.FrameMetrics(),
+		})
+	})
+
+	requireAuth := auth.AuthMiddleware(deps.JWTService, deps.TokenRevocations)
+
+	// API routes
+	api := r.Group("/api")
+	{
+		// Auth routes
+		authRoutes := api.Group("/auth")
+		{
+			authRoutes.GET("/login", authHandler.Login)
+			authRoutes.GET("/callback", authHandler.Callback)
+			authRoutes.POST("/refresh_token", deps.RateLimiter.Endpoint("refresh_token"), authHandler.RefreshToken)
+
+			// Logging out works with expired tokens, but logging out everywhere needs a valid one
+			authRoutes.POST("/logout", authHandler.Logout)
+			authRoutes.POST("/logout-all", requireAuth, authHandler.LogoutAll)
+		}
+
+		// WebSocket endpoint - Single connection for all rooms. It authenticates
+		// itself, as clients may only be able to do so once connected.
+		api.GET("/ws", wsHandler.HandleConnection)
+
+		// Protected routes
+		protected := api.Group("/")
+		protected.Use(requireAuth)
+		{
+			// User routes
+			protected.GET("/user/profile", func(c *gin.Context) {
+				userID := c.GetString("userID")
+				user, err := deps.UserService.GetByID(c.Request.Context(), userID)
+				if err != nil {
+					c.JSON(404, gin.H{"error": "user not found"})
+					return
+				}
+				c.JSON(200, user)
+			})
+
+			// Room routes
+			protected.GET("/rooms", func(c *gin.Context) {
+				userID := c.GetString("userID")
+				rooms, err := deps.ChatService.GetUserRooms(c.Request.Context(), userID)
+				if err != nil {
+					c.JSON(500, gin.H{"error": "failed to get rooms"})
+					return
+				}
+				c.JSON(200, rooms)
+			})
+
+			protected.POST("/rooms", func(c *gin.Context) {
+				userID := c.GetString("userID")
+				var req struct {
+					Name        string   `json:"name" binding:"required"`
+					Description string   `json:"description"`
+					IsPrivate   bool     `json:"is_private"`
+					MemberIDs   []string `json:"member_ids"`
+					ListID      string   `json:"list_id"`
+				}
+				if err := c.ShouldBindJSON(&req); err != nil {
+					c.JSON(400, gin.H{"error": err.Error()})
+					return
+				}
+
+				// Seed membership from a friend list if specified
+				if req.ListID != "" {
+					listMemberIDs, err := deps.FriendListService.GetMemberIDs(c.Request.Context(), req.ListID, userID)
+					if err != nil {
+						c.JSON(400, gin.H{"error": err.Error()})
+						return
+					}
+					req.MemberIDs = append(req.MemberIDs, listMemberIDs...)
+				}
+
+				room, err := deps.ChatService.CreateRoom(c.Request.Context(), req.Name, req.Description, req.IsPrivate, userID, req.MemberIDs...)
+				if err != nil {
+					log.Printf("Failed to create room: %v", err)
+					c.JSON(500, gin.H{"error": "failed to create room"})
+					return
+				}
+
+				c.JSON(201, room)
+			})
+
+			protected.POST("/dm/:userId", func(c *gin.Context) {
+				userID := c.GetString("userID")
+				targetUserID := c.Param("userId")
+
+				room, err := deps.ChatService.CreateDirectMessageRoom(c.Request.Context(), userID, targetUserID)
+				if err != nil {
+					if errors.Is(err, service.ErrSelfDirectMessage) {
+						c.JSON(400, gin.H{"error": err.Error()})
+					} else {
+						c.JSON(500, gin.H{"error": "failed to create DM room"})
+					}
+					return
+				}
+				c.JSON(201, room)
+			})
+
+			protected.POST("/dm/group", func(c *gin.Context) {
+				userID := c.GetString("userID")
+				var req struct {
+					UserIDs []string `json:"user_ids" binding:"required"`
+				}
+				if err := c.ShouldBindJSON(&req); err != nil {
+					c.JSON(400, gin.H{"error": err.Error()})
+					return
+				}
+
+				room, err := deps.ChatService.CreateGroupDMRoom(c.Request.Context(), userID, req.UserIDs)
+				if err != nil {
+					if errors.Is(err, service.ErrInvalidParticipants) {
+						c.JSON(400, gin.H{"error": err.Error()})
+					} else {
+						c.JSON(500, gin.H{"error": "failed to create group DM room"})
+					}
+					return
+				}
+				c.JSON(201, room)
+			})
+
+			protected.POST("/rooms/:roomId/participants", func(c *gin.Context) {
+				userID := c.GetString("userID")
+				roomID := c.Param("roomId")
+				var req struct {
+					UserIDs []string `json:"user_ids" binding:"required"`
+				}
+				if err := c.ShouldBindJSON(&req); err != nil {
+					c.JSON(400, gin.H{"error": err.Error()})
+					return
+				}
+
+				room, err := deps.ChatService.AddGroupDMParticipants(c.Request.Context(), roomID, userID, req.UserIDs)
+				if err != nil {
+					switch {
+					case errors.Is(err, service.ErrInvalidParticipants), errors.Is(err, service.ErrNotDirectRoom):
+						c.JSON(400, gin.H{"error": err.Error()})
+					case errors.Is(err, service.ErrNotRoomMember):
+						c.JSON(403, gin.H{"error": "access denied"})
+					default:
+						c.JSON(500, gin.H{"error": "failed to add participants"})
+					}
+					return
+				}
+				c.JSON(201, room)
+			})
+
+			protected.GET("/rooms/:roomId/messages", func(c *gin.Context) {
+				roomID := c.Param("roomId")
+				limit := 50
+				offset := 0
+
+				if limitParam := c.Query("limit"); limitParam != "" {
+					if _, err := fmt.Sscanf(limitParam, "%d", &limit); err != nil {
+						limit = 50
+					}
+				}
+
+				if offsetParam := c.Query("offset"); offsetParam != "" {
+					if _, err := fmt.Sscanf(offsetParam, "%d", &offset); err != nil {
+						offset = 0
+					}
+				}
+
+				messages, err := deps.ChatService.GetRoomMessages(c.Request.Context(), roomID, limit, offset)
+				if err != nil {
+					c.JSON(500, gin.H{"error": "failed to get messages"})
+					return
+				}
+				c.JSON(200, messages)
+			})
+
+			// Send a message without a WebSocket, for integrations and fallback clients
+			protected.POST("/rooms/:roomId/messages", deps.RateLimiter.Endpoint("send_message"), messageHandler.SendMessage)
+
+			protected.DELETE("/rooms/:roomId", func(c *gin.Context) {
+				userID := c.GetString("userID")
+				roomID := c.Param("roomId")
+
+				// Check if user is a member of the room
+				isMember, err := deps.ChatService.IsUserMemberOfRoom(c.Request.Context(), userID, roomID)
+				if err != nil {
+					c.JSON(500, gin.H{"error": "error checking membership"})
+					return
+				}
+
+				if !isMember {
+					c.JSON(403, gin.H{"error": "access denied"})
+					return
+				}
+
+				// Delete the room
+				if err := deps.ChatService.DeleteRoom(c.Request.Context(), roomID, userID); err != nil {
+					if err.Error() == "unauthorized: only the room creator can delete this room" {
+						c.JSON(403, gin.H{"error": err.Error()})
+					} else {
+						c.JSON(500, gin.H{"error": "failed to delete room"})
+					}
+					return
+				}
+
+				c.JSON(200, gin.H{"message": "room deleted successfully"})
+			})
+			// Friendship routes
+			friendRoutes := protected.Group("/friends")
+			{
+				friendRoutes.GET("", friendshipHandler.GetFriends)
+				friendRoutes.GET("/requests", friendshipHandler.GetFriendRequests)
+				friendRoutes.GET("/relationships", friendshipHandler.GetAllRelationships)
+				friendRoutes.GET("/potential", friendshipHandler.GetPotentialFriends)
+				friendRoutes.POST("/potential/:userId/dismiss", friendshipHandler.DismissSuggestion)
+				friendRoutes.GET("/status/:userId", friendshipHandler.GetFriendshipStatus)
+
+				friendRoutes.POST("/requests/:userId", deps.RateLimiter.Endpoint("friend_request"), friendshipHandler.SendFriendRequest)
+				friendRoutes.POST("/accept/:friendshipId", friendshipHandler.AcceptFriendRequest)
+				friendRoutes.POST("/reject/:friendshipId", friendshipHandler.RejectFriendRequest)
+				friendRoutes.DELETE("/:userId", friendshipHandler.RemoveFriend)
+
+				friendRoutes.POST("/block/:userId", friendshipHandler.BlockUser)
+				friendRoutes.POST("/unblock/:userId", friendshipHandler.UnblockUser)
+
+				// Friend list routes
+				listRoutes := friendRoutes.Group("/lists")
+				{
+					listRoutes.GET("", friendListHandler.GetLists)
+					listRoutes.POST("", friendListHandler.CreateList)
+					listRoutes.GET("/:listId", friendListHandler.GetList)
+					listRoutes.PATCH("/:listId", friendListHandler.RenameList)
+					listRoutes.DELETE("/:listId", friendListHandler.DeleteList)
+					listRoutes.POST("/:listId/members/:userId", friendListHandler.AddMember)
+					listRoutes.DELETE("/:listId/members/:userId", friendListHandler.RemoveMember)
+				}
+			}
+
+			// The user's connected devices
+			protected.GET("/sessions", wsHandler.ListSessions)
+
+			// Single-use ticket for opening a WebSocket without a token in the URL
+			protected.POST("/ws/ticket", deps.RateLimiter.Endpoint("ws_ticket"), wsHandler.IssueTicket)
+
+			// Fallback for clients that can't use WebSockets: the same frames over
+			// Server-Sent Events or long polling, with client frames posted back
+			eventRoutes := protected.Group("/events")
+			{
+				eventRoutes.GET("", eventsHandler.Stream)
+				eventRoutes.GET("/poll", eventsHandler.Poll)
+				eventRoutes.POST("/:connectionId", eventsHandler.PostFrame)
+				eventRoutes.DELETE("/:connectionId", eventsHandler.Close)
+			}
+		}
+
+		// Get room details - with auth check
+		protected.GET("/rooms/:roomId", func(c *gin.Context) {
+			userID := c.GetString("userID")
+			roomID := c.Param("roomId")
+
+			// Get room details
+			room, err := deps.ChatService.GetRoomDetails(c.Request.Context(), roomID)
+			if err != nil {
+				c.JSON(404, gin.H{"error": "room not found"})
+				return
+			}
+
+			// Check if user is a member of the room
+			isMember, err := deps.ChatService.IsUserMemberOfRoom(c.Request.Context(), userID, roomID)
+			if err != nil || !isMember {
+				c.JSON(403, gin.H{"error": "access denied"})
+				return
+			}
+
+			// Return room details
+			c.JSON(200, room)
+		})
+	}
+
+	return r
+}
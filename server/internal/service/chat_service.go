@@ -2,31 +2,71 @@
 package service
 
 import (
-	"encoding/json"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"github.com/mjxoro/sent/server/internal/db/postgres"
-	"github.com/mjxoro/sent/server/internal/db/redis"
+	"log"
+	"sort"
+	"strings"
+
 	"github.com/mjxoro/sent/server/internal/models"
+	"github.com/mjxoro/sent/server/internal/repository"
+)
+
+// Group direct message participant limits, including the creator
+const (
+	minGroupDMParticipants = 3
+	maxGroupDMParticipants = 10
+)
+
+// maxClientMsgIDLength is the longest client message ID the messages table stores
+const maxClientMsgIDLength = 64
+
+var (
+	// ErrInvalidParticipants is returned when a group DM's participant set is not allowed
+	ErrInvalidParticipants = fmt.Errorf("group direct messages must have between %d and %d existing participants", minGroupDMParticipants, maxGroupDMParticipants)
+
+	// ErrNotDirectRoom is returned when participants are added to a room that is not a DM
+	ErrNotDirectRoom = errors.New("participants can only be added to direct message rooms")
+
+	// ErrNotRoomMember is returned when a user acts on a room they are not a member of
+	ErrNotRoomMember = errors.New("not a member of this room")
+
+	// ErrSelfDirectMessage is returned when a user tries to open a DM with themselves
+	ErrSelfDirectMessage = errors.New("cannot create a direct message room with yourself")
+
+	// ErrInvalidClientMsgID is returned when a client message ID is too long
+	ErrInvalidClientMsgID = fmt.Errorf("client_msg_id must be at most %d characters", maxClientMsgIDLength)
+
+	// ErrClientMsgIDReused is returned when a client message ID was already used for a different message
+	ErrClientMsgIDReused = errors.New("client_msg_id was already used for a different message")
 )
 
 // ChatService handles chat-related business logic
 type ChatService struct {
-	pgRoom      *postgres.Room
-	pgMessage   *postgres.Message
-	redisClient *redis.Client
+	transactor repository.Transactor
+	rooms      repository.Room
+	messages   repository.Message
+	users      repository.User
+	pubsub     repository.PubSub
 }
 
 // NewChatService creates a new chat service
-func NewChatService(pgRoom *postgres.Room, pgMessage *postgres.Message, redisClient *redis.Client) *ChatService {
+func NewChatService(transactor repository.Transactor, rooms repository.Room, messages repository.Message, users repository.User, pubsub repository.PubSub) *ChatService {
 	return &ChatService{
-		pgRoom:      pgRoom,
-		pgMessage:   pgMessage,
-		redisClient: redisClient,
+		transactor: transactor,
+		rooms:      rooms,
+		messages:   messages,
+		users:      users,
+		pubsub:     pubsub,
 	}
 }
 
-// CreateRoom creates a new chat room
-func (s *ChatService) CreateRoom(name, description string, isPrivate bool, creatorID string) (*models.Room, error) {
+// CreateRoom creates a new chat room with the creator as admin and any additional
+// members. The room and all of its memberships are created atomically.
+func (s *ChatService) CreateRoom(ctx context.Context, name, description string, isPrivate bool, creatorID string, memberIDs ...string) (*models.Room, error) {
 	room := &models.Room{
 		Name:        name,
 		Description: description,
@@ -35,118 +75,263 @@ func (s *ChatService) CreateRoom(name, description string, isPrivate bool, creat
 		CreatorID:   creatorID,
 	}
 
-	if err := s.pgRoom.Create(room); err != nil {
-		return nil, err
-	}
+	err := s.transactor.WithTx(ctx, func(tx *repository.Set) error {
+		if err := tx.Rooms.Create(ctx, room); err != nil {
+			return err
+		}
+
+		// Add creator as member with admin role
+		if err := tx.Rooms.AddMember(ctx, room.ID, creatorID, "admin"); err != nil {
+			return err
+		}
 
-	// Add creator as member with admin role
-	if err := s.pgRoom.AddMember(room.ID, creatorID, "admin"); err != nil {
+		for _, memberID := range uniqueIDs(memberIDs) {
+			if memberID == creatorID {
+				continue
+			}
+			if err := tx.Rooms.AddMember(ctx, room.ID, memberID, "member"); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
 
 	return room, nil
 }
 
-// CreateDirectMessageRoom creates a direct message room between two users
-func (s *ChatService) CreateDirectMessageRoom(user1ID, user2ID string) (*models.Room, error) {
-	// Check if DM room already exists
-	room, err := s.pgRoom.FindDMRoom(user1ID, user2ID)
+// CreateDirectMessageRoom finds or creates the direct message room between two users
+func (s *ChatService) CreateDirectMessageRoom(ctx context.Context, user1ID, user2ID string) (*models.Room, error) {
+	if user1ID == user2ID {
+		return nil, ErrSelfDirectMessage
+	}
+
+	participants := []string{user1ID, user2ID}
+	memberHash := dmMemberHash(participants)
+
+	room := &models.Room{
+		Name:       "", // DMs don't need names
+		IsPrivate:  true,
+		Type:       "direct",
+		CreatorID:  user1ID,
+		MemberHash: &memberHash,
+	}
+
+	return s.findOrCreateDMRoom(ctx, room, participants)
+}
+
+// CreateGroupDMRoom finds or creates a group direct message room for a set of participants.
+// The creator is always included, and the same participant set always resolves to the same room.
+func (s *ChatService) CreateGroupDMRoom(ctx context.Context, creatorID string, participantIDs []string) (*models.Room, error) {
+	participants := uniqueIDs(append([]string{creatorID}, participantIDs...))
+	if len(participants) < minGroupDMParticipants || len(participants) > maxGroupDMParticipants {
+		return nil, ErrInvalidParticipants
+	}
+
+	// Reopen the existing room for this exact set of participants
+	memberHash := dmMemberHash(participants)
+	room, err := s.rooms.FindByMemberHash(ctx, memberHash)
 	if err == nil {
-		// Room exists, return it
 		return room, nil
 	}
 
-	// Create new DM room
+	// Every participant must be an existing user
+	users, err := s.users.FindByIDs(ctx, participants)
+	if err != nil {
+		return nil, err
+	}
+	if len(users) != len(participants) {
+		return nil, ErrInvalidParticipants
+	}
+
 	room = &models.Room{
-		Name:      "", // DMs don't need names
-		IsPrivate: true,
-		Type:      "direct",
-		CreatorID: user1ID,
+		Name:       groupDMTitle(users),
+		IsPrivate:  true,
+		Type:       "group_dm",
+		CreatorID:  creatorID,
+		MemberHash: &memberHash,
+	}
+
+	return s.findOrCreateDMRoom(ctx, room, participants)
+}
+
+// findOrCreateDMRoom returns the room matching the DM's member hash, creating it and its
+// memberships in a single transaction if it doesn't exist. The unique member hash makes
+// concurrent requests for the same participants resolve to one room.
+func (s *ChatService) findOrCreateDMRoom(ctx context.Context, room *models.Room, participantIDs []string) (*models.Room, error) {
+	existing, err := s.rooms.FindByMemberHash(ctx, *room.MemberHash)
+	if err == nil {
+		return existing, nil
 	}
 
-	if err := s.pgRoom.Create(room); err != nil {
+	created := false
+	err = s.transactor.WithTx(ctx, func(tx *repository.Set) error {
+		var err error
+		created, err = createDMRoomTx(ctx, tx, room, participantIDs)
+		return err
+	})
+	if err != nil {
 		return nil, err
 	}
 
-	// Add both users as members
-	if err := s.pgRoom.AddMember(room.ID, user1ID, "member"); err != nil {
+	// Another request created the room first
+	if !created {
+		return s.rooms.FindByMemberHash(ctx, *room.MemberHash)
+	}
+
+	return room, nil
+}
+
+// createDMRoomTx creates a DM room and its memberships inside tx, unless a room with the
+// same member hash already exists. It reports whether the room was created.
+func createDMRoomTx(ctx context.Context, tx *repository.Set, room *models.Room, participantIDs []string) (bool, error) {
+	created, err := tx.Rooms.CreateIfNotExists(ctx, room)
+	if err != nil || !created {
+		return false, err
+	}
+
+	for _, participantID := range participantIDs {
+		if err := tx.Rooms.AddMember(ctx, room.ID, participantID, "member"); err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// AddGroupDMParticipants adds people to a direct message conversation. Membership of a
+// DM is fixed by its participant set, so this forks into a new (or existing) group DM.
+func (s *ChatService) AddGroupDMParticipants(ctx context.Context, roomID, requesterID string, newParticipantIDs []string) (*models.Room, error) {
+	room, err := s.rooms.FindByID(ctx, roomID)
+	if err != nil {
 		return nil, err
 	}
-	if err := s.pgRoom.AddMember(room.ID, user2ID, "member"); err != nil {
+
+	if room.Type != "direct" && room.Type != "group_dm" {
+		return nil, ErrNotDirectRoom
+	}
+
+	members, err := s.rooms.GetRoomMembers(ctx, roomID)
+	if err != nil {
 		return nil, err
 	}
 
-	return room, nil
+	participants := make([]string, 0, len(members)+len(newParticipantIDs))
+	isMember := false
+	for _, member := range members {
+		if member.ID == requesterID {
+			isMember = true
+		}
+		participants = append(participants, member.ID)
+	}
+
+	if !isMember {
+		return nil, ErrNotRoomMember
+	}
+
+	if len(uniqueIDs(append(participants, newParticipantIDs...))) == len(uniqueIDs(participants)) {
+		return nil, ErrInvalidParticipants
+	}
+
+	return s.CreateGroupDMRoom(ctx, requesterID, append(participants, newParticipantIDs...))
 }
 
 // GetUserRooms gets all rooms a user is a member of
-func (s *ChatService) GetUserRooms(userID string) ([]*models.Room, error) {
-	return s.pgRoom.FindRoomsByUserID(userID)
+func (s *ChatService) GetUserRooms(ctx context.Context, userID string) ([]*models.Room, error) {
+	return s.rooms.FindRoomsByUserID(ctx, userID)
 }
 
 // GetRoomMembers gets all members of a room
-func (s *ChatService) GetRoomMembers(roomID string) ([]*models.User, error) {
-	return s.pgRoom.GetRoomMembers(roomID)
+func (s *ChatService) GetRoomMembers(ctx context.Context, roomID string) ([]*models.User, error) {
+	return s.rooms.GetRoomMembers(ctx, roomID)
 }
 
 // SendMessage sends a message to a room
-func (s *ChatService) SendMessage(roomID, userID, content string) (*models.Message, error) {
+func (s *ChatService) SendMessage(ctx context.Context, roomID, userID, content string) (*models.Message, error) {
+	message, _, err := s.SendMessageOnce(ctx, roomID, userID, content, "")
+	return message, err
+}
+
+// SendMessageOnce sends a message to a room at most once per client message ID, so a
+// send retried after a reconnect doesn't create a duplicate. If the user already sent
+// the message, it is returned with created false. An empty clientMsgID always sends.
+func (s *ChatService) SendMessageOnce(ctx context.Context, roomID, userID, content, clientMsgID string) (message *models.Message, created bool, err error) {
+	if len(clientMsgID) > maxClientMsgIDLength {
+		return nil, false, ErrInvalidClientMsgID
+	}
+
 	// Create message in database
-	message := &models.Message{
+	message = &models.Message{
 		RoomID:  roomID,
 		UserID:  userID,
 		Content: content,
 	}
 
-	if err := s.pgMessage.Create(message); err != nil {
-		return nil, err
+	if clientMsgID == "" {
+		if err := s.messages.Create(ctx, message); err != nil {
+			return nil, false, err
+		}
+	} else {
+		message.ClientMsgID = &clientMsgID
+		created, err := s.messages.CreateIfNotExists(ctx, message)
+		if err != nil {
+			return nil, false, err
+		}
+		if !created {
+			existing, err := s.messages.FindByClientMsgID(ctx, userID, clientMsgID)
+			if err != nil {
+				return nil, false, err
+			}
+			if existing.RoomID != roomID || existing.Content != content {
+				return nil, false, ErrClientMsgIDReused
+			}
+			return existing, false, nil
+		}
 	}
 
-	// Publish message to Redis for real-time delivery
-	ctx := s.redisClient.Context()
-
-	messageData, err := json.Marshal(map[string]any{
+	// Publish message to the room channel for real-time delivery
+	messageData := map[string]any{
 		"id":        message.ID,
 		"room_id":   message.RoomID,
 		"user_id":   message.UserID,
 		"content":   message.Content,
 		"timestamp": message.CreatedAt,
-	})
-	if err != nil {
-		return nil, err
+	}
+	if err := s.pubsub.PublishMessage(ctx, "chat:room:"+roomID, messageData); err != nil {
+		log.Printf("Failed to publish message %s: %v", message.ID, err)
 	}
 
-	// Publish to Redis channel for this room
-	s.redisClient.Publish(ctx, "chat:room:"+roomID, messageData)
-
-	return message, nil
+	return message, true, nil
 }
 
 // GetRoomMessages gets messages from a room with pagination
 // Updated to return MessageDTO with user information
-func (s *ChatService) GetRoomMessages(roomID string, limit, offset int) ([]*models.MessageDTO, error) {
-	return s.pgMessage.FindByRoomID(roomID, limit, offset)
+func (s *ChatService) GetRoomMessages(ctx context.Context, roomID string, limit, offset int) ([]*models.MessageDTO, error) {
+	return s.messages.FindByRoomID(ctx, roomID, limit, offset)
 }
 
 // MarkMessageAsRead marks a message as read by a user
-func (s *ChatService) MarkMessageAsRead(messageID, userID string) error {
-	return s.pgMessage.MarkAsRead(messageID, userID)
+func (s *ChatService) MarkMessageAsRead(ctx context.Context, messageID, userID string) error {
+	return s.messages.MarkAsRead(ctx, messageID, userID)
 }
 
 // GetUnreadCount gets the count of unread messages in a room for a user
-func (s *ChatService) GetUnreadCount(roomID, userID string) (int, error) {
-	return s.pgMessage.GetUnreadCount(roomID, userID)
+func (s *ChatService) GetUnreadCount(ctx context.Context, roomID, userID string) (int, error) {
+	return s.messages.GetUnreadCount(ctx, roomID, userID)
 }
 
 // GetRoomDetails gets details of a room
-func (s *ChatService) GetRoomDetails(roomID string) (*models.Room, error) {
-	return s.pgRoom.FindByID(roomID)
+func (s *ChatService) GetRoomDetails(ctx context.Context, roomID string) (*models.Room, error) {
+	return s.rooms.FindByID(ctx, roomID)
 }
 
 // IsUserMemberOfRoom checks if a user is a member of a room
-func (s *ChatService) IsUserMemberOfRoom(userID, roomID string) (bool, error) {
+func (s *ChatService) IsUserMemberOfRoom(ctx context.Context, userID, roomID string) (bool, error) {
 	// Get members of the room
-	members, err := s.pgRoom.GetRoomMembers(roomID)
+	members, err := s.rooms.GetRoomMembers(ctx, roomID)
 	if err != nil {
 		return false, err
 	}
@@ -162,9 +347,9 @@ func (s *ChatService) IsUserMemberOfRoom(userID, roomID string) (bool, error) {
 }
 
 // DeleteRoom deletes a room if the user is the creator
-func (s *ChatService) DeleteRoom(roomID, userID string) error {
+func (s *ChatService) DeleteRoom(ctx context.Context, roomID, userID string) error {
 	// Get room details to check creator
-	room, err := s.pgRoom.FindByID(roomID)
+	room, err := s.rooms.FindByID(ctx, roomID)
 	if err != nil {
 		return err
 	}
@@ -175,5 +360,52 @@ func (s *ChatService) DeleteRoom(roomID, userID string) error {
 	}
 
 	// Delete the room
-	return s.pgRoom.Delete(roomID)
+	return s.rooms.Delete(ctx, roomID)
+}
+
+// uniqueIDs removes empty and duplicate IDs while preserving order
+func uniqueIDs(ids []string) []string {
+	seen := make(map[string]bool, len(ids))
+	result := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		result = append(result, id)
+	}
+	return result
+}
+
+// dmMemberHash computes a deterministic hash of a set of participant IDs
+func dmMemberHash(participantIDs []string) string {
+	sorted := uniqueIDs(participantIDs)
+	sort.Strings(sorted)
+
+	sum := sha256.Sum256([]byte(strings.Join(sorted, ",")))
+	return hex.EncodeToString(sum[:])
+}
+
+// groupDMTitle generates a room title from participant names, e.g. "Alice, Bob and Carol"
+func groupDMTitle(users []*models.User) string {
+	const maxNamed = 3
+
+	names := make([]string, 0, len(users))
+	for _, user := range users {
+		names = append(names, user.Name)
+	}
+
+	switch {
+	case len(names) <= 1:
+		return strings.Join(names, "")
+	case len(names) <= maxNamed:
+		return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+	default:
+		others := len(names) - maxNamed
+		suffix := "others"
+		if others == 1 {
+			suffix = "other"
+		}
+		return fmt.Sprintf("%s and %d %s", strings.Join(names[:maxNamed], ", "), others, suffix)
+	}
 }
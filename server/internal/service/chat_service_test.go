@@ -0,0 +1,256 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mjxoro/sent/server/internal/db/memory"
+	"github.com/mjxoro/sent/server/internal/models"
+	"github.com/mjxoro/sent/server/internal/repository"
+)
+
+// newTestRepos creates an in-memory store and its repositories
+func newTestRepos(t *testing.T) (*memory.Store, *repository.Set) {
+	t.Helper()
+	store := memory.NewStore()
+	return store, store.Repositories()
+}
+
+// createTestUser stores a user with the given name
+func createTestUser(t *testing.T, repos *repository.Set, name string) *models.User {
+	t.Helper()
+	user := &models.User{Name: name, Email: name + "@example.com", OAuthID: name, Provider: "google"}
+	if err := repos.Users.Create(context.Background(), user); err != nil {
+		t.Fatalf("failed to create user %s: %v", name, err)
+	}
+	return user
+}
+
+func newTestChatService(t *testing.T) (*ChatService, *repository.Set) {
+	t.Helper()
+	store, repos := newTestRepos(t)
+	return NewChatService(store, repos.Rooms, repos.Messages, repos.Users, memory.NewPubSub()), repos
+}
+
+func TestCreateRoomAddsMembers(t *testing.T) {
+	ctx := context.Background()
+	svc, repos := newTestChatService(t)
+	alice := createTestUser(t, repos, "alice")
+	bob := createTestUser(t, repos, "bob")
+
+	room, err := svc.CreateRoom(ctx, "design", "", false, alice.ID, bob.ID, alice.ID)
+	if err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+
+	members, err := svc.GetRoomMembers(ctx, room.ID)
+	if err != nil {
+		t.Fatalf("GetRoomMembers: %v", err)
+	}
+	if len(members) != 2 {
+		t.Fatalf("expected 2 members, got %d", len(members))
+	}
+}
+
+func TestCreateRoomIsAtomic(t *testing.T) {
+	ctx := context.Background()
+	svc, repos := newTestChatService(t)
+	alice := createTestUser(t, repos, "alice")
+
+	if _, err := svc.CreateRoom(ctx, "design", "", false, alice.ID, "missing-user"); err == nil {
+		t.Fatal("expected an error when adding a missing user")
+	}
+
+	rooms, err := svc.GetUserRooms(ctx, alice.ID)
+	if err != nil {
+		t.Fatalf("GetUserRooms: %v", err)
+	}
+	if len(rooms) != 0 {
+		t.Fatalf("expected the failed room to be rolled back, got %d rooms", len(rooms))
+	}
+}
+
+func TestRolledBackTxKeepsConcurrentWrites(t *testing.T) {
+	ctx := context.Background()
+	store, repos := newTestRepos(t)
+
+	// A user created while a transaction is running must survive its rollback
+	var created sync.WaitGroup
+	err := store.WithTx(ctx, func(tx *repository.Set) error {
+		created.Add(1)
+		go func() {
+			defer created.Done()
+			createTestUser(t, repos, "alice")
+		}()
+		time.Sleep(20 * time.Millisecond)
+
+		createTestUser(t, tx, "bob")
+		return errors.New("roll back")
+	})
+	if err == nil {
+		t.Fatal("expected the transaction's error")
+	}
+	created.Wait()
+
+	if _, err := repos.Users.FindByEmail(ctx, "alice@example.com"); err != nil {
+		t.Fatalf("expected the user created outside the transaction to be kept: %v", err)
+	}
+	if _, err := repos.Users.FindByEmail(ctx, "bob@example.com"); err == nil {
+		t.Fatal("expected the user created in the transaction to be rolled back")
+	}
+}
+
+func TestCreateDirectMessageRoomReusesRoom(t *testing.T) {
+	ctx := context.Background()
+	svc, repos := newTestChatService(t)
+	alice := createTestUser(t, repos, "alice")
+	bob := createTestUser(t, repos, "bob")
+
+	first, err := svc.CreateDirectMessageRoom(ctx, alice.ID, bob.ID)
+	if err != nil {
+		t.Fatalf("CreateDirectMessageRoom: %v", err)
+	}
+	second, err := svc.CreateDirectMessageRoom(ctx, bob.ID, alice.ID)
+	if err != nil {
+		t.Fatalf("CreateDirectMessageRoom: %v", err)
+	}
+
+	if first.ID != second.ID {
+		t.Fatalf("expected the same room for both directions, got %s and %s", first.ID, second.ID)
+	}
+
+	if _, err := svc.CreateDirectMessageRoom(ctx, alice.ID, alice.ID); !errors.Is(err, ErrSelfDirectMessage) {
+		t.Fatalf("expected ErrSelfDirectMessage, got %v", err)
+	}
+}
+
+func TestCreateGroupDMRoom(t *testing.T) {
+	ctx := context.Background()
+	svc, repos := newTestChatService(t)
+	alice := createTestUser(t, repos, "Alice")
+	bob := createTestUser(t, repos, "Bob")
+	carol := createTestUser(t, repos, "Carol")
+	dave := createTestUser(t, repos, "Dave")
+
+	room, err := svc.CreateGroupDMRoom(ctx, alice.ID, []string{bob.ID, carol.ID})
+	if err != nil {
+		t.Fatalf("CreateGroupDMRoom: %v", err)
+	}
+	if room.Type != "group_dm" {
+		t.Fatalf("expected type group_dm, got %s", room.Type)
+	}
+	if room.Name != "Alice, Bob and Carol" {
+		t.Fatalf("unexpected title %q", room.Name)
+	}
+
+	reopened, err := svc.CreateGroupDMRoom(ctx, carol.ID, []string{bob.ID, alice.ID})
+	if err != nil {
+		t.Fatalf("CreateGroupDMRoom: %v", err)
+	}
+	if reopened.ID != room.ID {
+		t.Fatal("expected the same participants to reopen the existing room")
+	}
+
+	forked, err := svc.AddGroupDMParticipants(ctx, room.ID, alice.ID, []string{dave.ID})
+	if err != nil {
+		t.Fatalf("AddGroupDMParticipants: %v", err)
+	}
+	if forked.ID == room.ID {
+		t.Fatal("expected adding a participant to fork a new room")
+	}
+
+	if _, err := svc.CreateGroupDMRoom(ctx, alice.ID, []string{bob.ID}); !errors.Is(err, ErrInvalidParticipants) {
+		t.Fatalf("expected ErrInvalidParticipants for two participants, got %v", err)
+	}
+	if _, err := svc.AddGroupDMParticipants(ctx, room.ID, dave.ID, []string{dave.ID}); !errors.Is(err, ErrNotRoomMember) {
+		t.Fatalf("expected ErrNotRoomMember, got %v", err)
+	}
+}
+
+func TestSendMessagePublishesToRoomChannel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store, repos := newTestRepos(t)
+	pubsub := memory.NewPubSub()
+	svc := NewChatService(store, repos.Rooms, repos.Messages, repos.Users, pubsub)
+	alice := createTestUser(t, repos, "alice")
+
+	room, err := svc.CreateRoom(ctx, "general", "", false, alice.ID)
+	if err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+
+	received := make(chan []byte, 1)
+	subscribed := make(chan struct{})
+	go func() {
+		close(subscribed)
+		pubsub.Subscribe(ctx, "chat:room:"+room.ID, func(payload []byte) {
+			received <- payload
+		})
+	}()
+	<-subscribed
+
+	// Publishing may race with the subscription being registered, so retry until delivered
+	for i := 0; i < 50; i++ {
+		if _, err := svc.SendMessage(ctx, room.ID, alice.ID, "hello"); err != nil {
+			t.Fatalf("SendMessage: %v", err)
+		}
+		select {
+		case payload := <-received:
+			var published map[string]any
+			if err := json.Unmarshal(payload, &published); err != nil {
+				t.Fatalf("invalid published payload: %v", err)
+			}
+			if published["content"] != "hello" || published["room_id"] != room.ID {
+				t.Fatalf("unexpected published message: %v", published)
+			}
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	t.Fatal("message was never published")
+}
+
+func TestSendMessageOnceDeduplicatesRetries(t *testing.T) {
+	ctx := context.Background()
+	svc, repos := newTestChatService(t)
+	alice := createTestUser(t, repos, "alice")
+	bob := createTestUser(t, repos, "bob")
+
+	room, err := svc.CreateRoom(ctx, "general", "", false, alice.ID, bob.ID)
+	if err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+
+	first, created, err := svc.SendMessageOnce(ctx, room.ID, alice.ID, "hello", "c1")
+	if err != nil || !created {
+		t.Fatalf("SendMessageOnce = %v, %v", created, err)
+	}
+
+	retry, created, err := svc.SendMessageOnce(ctx, room.ID, alice.ID, "hello", "c1")
+	if err != nil || created || retry.ID != first.ID {
+		t.Fatalf("retry = %+v, %v, %v; want message %s not created", retry, created, err, first.ID)
+	}
+
+	// Client message IDs are scoped to the sender
+	if _, created, err := svc.SendMessageOnce(ctx, room.ID, bob.ID, "hello", "c1"); err != nil || !created {
+		t.Fatalf("other sender = %v, %v", created, err)
+	}
+
+	if _, _, err := svc.SendMessageOnce(ctx, room.ID, alice.ID, "different", "c1"); !errors.Is(err, ErrClientMsgIDReused) {
+		t.Fatalf("expected ErrClientMsgIDReused, got %v", err)
+	}
+
+	messages, err := svc.GetRoomMessages(ctx, room.ID, 50, 0)
+	if err != nil {
+		t.Fatalf("GetRoomMessages: %v", err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("expected 2 messages, got %d", len(messages))
+	}
+}
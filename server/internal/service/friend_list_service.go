@@ -0,0 +1,174 @@
+// internal/service/friend_list_service.go
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/mjxoro/sent/server/internal/models"
+	"github.com/mjxoro/sent/server/internal/repository"
+)
+
+// maxFriendListNameLength is the longest name a friend list may have
+const maxFriendListNameLength = 100
+
+// FriendListService handles friend list business logic
+type FriendListService struct {
+	friendLists repository.FriendList
+	friendships repository.Friendship
+}
+
+// NewFriendListService creates a new friend list service
+func NewFriendListService(friendLists repository.FriendList, friendships repository.Friendship) *FriendListService {
+	return &FriendListService{
+		friendLists: friendLists,
+		friendships: friendships,
+	}
+}
+
+// CreateList creates a new friend list for a user
+func (s *FriendListService) CreateList(ctx context.Context, ownerID, name string) (*models.FriendList, error) {
+	name, err := validateFriendListName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &models.FriendList{
+		OwnerID: ownerID,
+		Name:    name,
+	}
+
+	if err := s.friendLists.Create(ctx, list); err != nil {
+		return nil, errors.New("failed to create friend list")
+	}
+
+	return list, nil
+}
+
+// GetLists gets all friend lists owned by a user along with their members
+func (s *FriendListService) GetLists(ctx context.Context, ownerID string) ([]*models.FriendListWithMembers, error) {
+	lists, err := s.friendLists.FindByOwnerID(ctx, ownerID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*models.FriendListWithMembers, 0, len(lists))
+	for _, list := range lists {
+		members, err := s.friendLists.GetMembers(ctx, list.ID)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, &models.FriendListWithMembers{FriendList: *list, Members: members})
+	}
+
+	return result, nil
+}
+
+// GetList gets a single friend list owned by a user along with its members
+func (s *FriendListService) GetList(ctx context.Context, listID, ownerID string) (*models.FriendListWithMembers, error) {
+	list, err := s.getOwnedList(ctx, listID, ownerID)
+	if err != nil {
+		return nil, err
+	}
+
+	members, err := s.friendLists.GetMembers(ctx, list.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.FriendListWithMembers{FriendList: *list, Members: members}, nil
+}
+
+// RenameList renames a friend list owned by a user
+func (s *FriendListService) RenameList(ctx context.Context, listID, ownerID, name string) (*models.FriendList, error) {
+	list, err := s.getOwnedList(ctx, listID, ownerID)
+	if err != nil {
+		return nil, err
+	}
+
+	name, err = validateFriendListName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.friendLists.UpdateName(ctx, list.ID, name); err != nil {
+		return nil, errors.New("failed to rename friend list")
+	}
+
+	list.Name = name
+	return list, nil
+}
+
+// DeleteList deletes a friend list owned by a user
+func (s *FriendListService) DeleteList(ctx context.Context, listID, ownerID string) error {
+	list, err := s.getOwnedList(ctx, listID, ownerID)
+	if err != nil {
+		return err
+	}
+
+	return s.friendLists.Delete(ctx, list.ID)
+}
+
+// AddMember adds an accepted friend to a friend list owned by a user
+func (s *FriendListService) AddMember(ctx context.Context, listID, ownerID, friendID string) error {
+	list, err := s.getOwnedList(ctx, listID, ownerID)
+	if err != nil {
+		return err
+	}
+
+	friendship, err := s.friendships.FindByUserAndFriend(ctx, ownerID, friendID)
+	if err != nil || friendship.Status != models.FriendshipStatusAccepted {
+		return errors.New("users are not friends")
+	}
+
+	return s.friendLists.AddMember(ctx, list.ID, friendID)
+}
+
+// RemoveMember removes a user from a friend list owned by a user
+func (s *FriendListService) RemoveMember(ctx context.Context, listID, ownerID, friendID string) error {
+	list, err := s.getOwnedList(ctx, listID, ownerID)
+	if err != nil {
+		return err
+	}
+
+	return s.friendLists.RemoveMember(ctx, list.ID, friendID)
+}
+
+// GetMemberIDs gets the user IDs of the members of a friend list owned by a user
+func (s *FriendListService) GetMemberIDs(ctx context.Context, listID, ownerID string) ([]string, error) {
+	list, err := s.GetList(ctx, listID, ownerID)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(list.Members))
+	for _, member := range list.Members {
+		ids = append(ids, member.ID)
+	}
+
+	return ids, nil
+}
+
+// getOwnedList finds a friend list and verifies it belongs to the user
+func (s *FriendListService) getOwnedList(ctx context.Context, listID, ownerID string) (*models.FriendList, error) {
+	list, err := s.friendLists.FindByID(ctx, listID)
+	if err != nil || list.OwnerID != ownerID {
+		return nil, errors.New("friend list not found")
+	}
+
+	return list, nil
+}
+
+// validateFriendListName trims a friend list name and checks its length
+func validateFriendListName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("friend list name is required")
+	}
+	if len(name) > maxFriendListNameLength {
+		return "", errors.New("friend list name is too long")
+	}
+
+	return name, nil
+}
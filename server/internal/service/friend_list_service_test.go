@@ -0,0 +1,169 @@
+package service
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/mjxoro/sent/server/internal/db/memory"
+	"github.com/mjxoro/sent/server/internal/models"
+	"github.com/mjxoro/sent/server/internal/repository"
+)
+
+// newTestFriendListService creates friend list and friendship services sharing an in-memory store
+func newTestFriendListService(t *testing.T) (*FriendListService, *FriendshipService, *repository.Set) {
+	t.Helper()
+	store, repos := newTestRepos(t)
+	friendships := NewFriendshipService(store, repos.Friendships, repos.Users, memory.NewCache())
+	return NewFriendListService(repos.FriendLists, repos.Friendships), friendships, repos
+}
+
+// befriendForTest makes two users accepted friends
+func befriendForTest(t *testing.T, svc *FriendshipService, a, b *models.User) {
+	t.Helper()
+	ctx := context.Background()
+	friendship, err := svc.SendFriendRequest(ctx, a.ID, b.ID)
+	if err != nil {
+		t.Fatalf("SendFriendRequest: %v", err)
+	}
+	if err := svc.AcceptFriendRequest(ctx, friendship.ID, b.ID); err != nil {
+		t.Fatalf("AcceptFriendRequest: %v", err)
+	}
+}
+
+func TestFriendListCRUD(t *testing.T) {
+	ctx := context.Background()
+	svc, _, repos := newTestFriendListService(t)
+	alice := createTestUser(t, repos, "alice")
+
+	list, err := svc.CreateList(ctx, alice.ID, "  Climbing  ")
+	if err != nil {
+		t.Fatalf("CreateList: %v", err)
+	}
+	if list.Name != "Climbing" || list.OwnerID != alice.ID {
+		t.Fatalf("unexpected list: %+v", list)
+	}
+
+	for _, name := range []string{"", "   ", strings.Repeat("x", maxFriendListNameLength+1)} {
+		if _, err := svc.CreateList(ctx, alice.ID, name); err == nil {
+			t.Fatalf("expected an error creating a list named %q", name)
+		}
+	}
+	if _, err := svc.CreateList(ctx, alice.ID, "Climbing"); err == nil {
+		t.Fatal("expected an error creating a second list with the same name")
+	}
+
+	renamed, err := svc.RenameList(ctx, list.ID, alice.ID, "Bouldering")
+	if err != nil || renamed.Name != "Bouldering" {
+		t.Fatalf("RenameList: %+v (%v)", renamed, err)
+	}
+	if _, err := svc.CreateList(ctx, alice.ID, "Climbing"); err != nil {
+		t.Fatalf("expected the old name to be free after renaming: %v", err)
+	}
+
+	lists, err := svc.GetLists(ctx, alice.ID)
+	if err != nil || len(lists) != 2 {
+		t.Fatalf("expected 2 lists, got %d (%v)", len(lists), err)
+	}
+
+	if err := svc.DeleteList(ctx, list.ID, alice.ID); err != nil {
+		t.Fatalf("DeleteList: %v", err)
+	}
+	if _, err := svc.GetList(ctx, list.ID, alice.ID); err == nil {
+		t.Fatal("expected the deleted list to be gone")
+	}
+	if lists, _ := svc.GetLists(ctx, alice.ID); len(lists) != 1 {
+		t.Fatalf("expected 1 list after deleting, got %d", len(lists))
+	}
+}
+
+func TestFriendListsAreOwnedByTheirUser(t *testing.T) {
+	ctx := context.Background()
+	svc, friendships, repos := newTestFriendListService(t)
+	alice := createTestUser(t, repos, "alice")
+	bob := createTestUser(t, repos, "bob")
+	befriendForTest(t, friendships, alice, bob)
+
+	list, err := svc.CreateList(ctx, alice.ID, "Work")
+	if err != nil {
+		t.Fatalf("CreateList: %v", err)
+	}
+
+	// Bob can't see or change Alice's list, even though they are friends
+	if _, err := svc.GetList(ctx, list.ID, bob.ID); err == nil {
+		t.Error("expected another user to be unable to get the list")
+	}
+	if _, err := svc.RenameList(ctx, list.ID, bob.ID, "Mine"); err == nil {
+		t.Error("expected another user to be unable to rename the list")
+	}
+	if err := svc.AddMember(ctx, list.ID, bob.ID, alice.ID); err == nil {
+		t.Error("expected another user to be unable to add members")
+	}
+	if err := svc.DeleteList(ctx, list.ID, bob.ID); err == nil {
+		t.Error("expected another user to be unable to delete the list")
+	}
+	if lists, _ := svc.GetLists(ctx, bob.ID); len(lists) != 0 {
+		t.Errorf("expected bob to have no lists, got %d", len(lists))
+	}
+
+	if got, err := svc.GetList(ctx, list.ID, alice.ID); err != nil || got.Name != "Work" {
+		t.Fatalf("expected alice's list to be unchanged, got %+v (%v)", got, err)
+	}
+}
+
+func TestFriendListMembership(t *testing.T) {
+	ctx := context.Background()
+	svc, friendships, repos := newTestFriendListService(t)
+	alice := createTestUser(t, repos, "alice")
+	bob := createTestUser(t, repos, "bob")
+	carol := createTestUser(t, repos, "carol")
+	dave := createTestUser(t, repos, "dave")
+	befriendForTest(t, friendships, alice, bob)
+	befriendForTest(t, friendships, carol, alice)
+
+	list, err := svc.CreateList(ctx, alice.ID, "Close friends")
+	if err != nil {
+		t.Fatalf("CreateList: %v", err)
+	}
+
+	// Only accepted friends, in either direction, can be added
+	for _, friend := range []*models.User{bob, carol, bob} {
+		if err := svc.AddMember(ctx, list.ID, alice.ID, friend.ID); err != nil {
+			t.Fatalf("AddMember %s: %v", friend.Name, err)
+		}
+	}
+	if err := svc.AddMember(ctx, list.ID, alice.ID, dave.ID); err == nil {
+		t.Fatal("expected a user who isn't a friend to be refused")
+	}
+	if _, err := friendships.SendFriendRequest(ctx, alice.ID, dave.ID); err != nil {
+		t.Fatalf("SendFriendRequest: %v", err)
+	}
+	if err := svc.AddMember(ctx, list.ID, alice.ID, dave.ID); err == nil {
+		t.Fatal("expected a pending friend to be refused")
+	}
+
+	ids, err := svc.GetMemberIDs(ctx, list.ID, alice.ID)
+	if err != nil {
+		t.Fatalf("GetMemberIDs: %v", err)
+	}
+	if !slices.Equal(ids, []string{bob.ID, carol.ID}) {
+		t.Fatalf("expected bob and carol, got %v", ids)
+	}
+
+	if err := svc.RemoveMember(ctx, list.ID, alice.ID, bob.ID); err != nil {
+		t.Fatalf("RemoveMember: %v", err)
+	}
+
+	// Friends who are unfriended drop out of the list
+	if err := friendships.RemoveFriend(ctx, carol.ID, alice.ID); err != nil {
+		t.Fatalf("RemoveFriend: %v", err)
+	}
+	got, err := svc.GetList(ctx, list.ID, alice.ID)
+	if err != nil {
+		t.Fatalf("GetList: %v", err)
+	}
+	if len(got.Members) != 0 {
+		t.Fatalf("expected no members left, got %v", got.Members)
+	}
+}
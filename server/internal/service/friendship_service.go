@@ -2,44 +2,47 @@
 package service
 
 import (
+	"context"
 	"errors"
+	"fmt"
 
-	"github.com/mjxoro/sent/server/internal/db/postgres"
-	"github.com/mjxoro/sent/server/internal/db/redis"
 	"github.com/mjxoro/sent/server/internal/models"
+	"github.com/mjxoro/sent/server/internal/repository"
 )
 
 // FriendshipService handles friendship-related business logic
 type FriendshipService struct {
-	pgFriendship *postgres.Friendship
-	pgUser       *postgres.User
-	redisCache   *redis.Cache
+	transactor  repository.Transactor
+	friendships repository.Friendship
+	users       repository.User
+	cache       repository.Cache
 }
 
 // NewFriendshipService creates a new friendship service
-func NewFriendshipService(pgFriendship *postgres.Friendship, pgUser *postgres.User, redisCache *redis.Cache) *FriendshipService {
+func NewFriendshipService(transactor repository.Transactor, friendships repository.Friendship, users repository.User, cache repository.Cache) *FriendshipService {
 	return &FriendshipService{
-		pgFriendship: pgFriendship,
-		pgUser:       pgUser,
-		redisCache:   redisCache,
+		transactor:  transactor,
+		friendships: friendships,
+		users:       users,
+		cache:       cache,
 	}
 }
 
 // SendFriendRequest sends a friend request from one user to another
-func (s *FriendshipService) SendFriendRequest(userID, friendID string) (*models.Friendship, error) {
+func (s *FriendshipService) SendFriendRequest(ctx context.Context, userID, friendID string) (*models.Friendship, error) {
 	// Validate users exist
-	_, err := s.pgUser.FindByID(userID)
+	_, err := s.users.FindByID(ctx, userID)
 	if err != nil {
 		return nil, errors.New("sender user not found")
 	}
 
-	_, err = s.pgUser.FindByID(friendID)
+	_, err = s.users.FindByID(ctx, friendID)
 	if err != nil {
 		return nil, errors.New("recipient user not found")
 	}
 
 	// Check if friendship already exists
-	existingFriendship, err := s.pgFriendship.FindByUserAndFriend(userID, friendID)
+	existingFriendship, err := s.friendships.FindByUserAndFriend(ctx, userID, friendID)
 	if err == nil {
 		// Friendship exists, handle based on status
 		switch existingFriendship.Status {
@@ -49,7 +52,7 @@ func (s *FriendshipService) SendFriendRequest(userID, friendID string) (*models.
 			return nil, errors.New("already friends")
 		case models.FriendshipStatusRejected:
 			// Allow re-requesting after rejection, update status to pending
-			err = s.pgFriendship.UpdateStatus(existingFriendship.ID, models.FriendshipStatusPending)
+			err = s.friendships.UpdateStatus(ctx, existingFriendship.ID, models.FriendshipStatusPending)
 			if err != nil {
 				return nil, err
 			}
@@ -66,7 +69,7 @@ func (s *FriendshipService) SendFriendRequest(userID, friendID string) (*models.
 		Status:   models.FriendshipStatusPending,
 	}
 
-	err = s.pgFriendship.Create(friendship)
+	err = s.friendships.Create(ctx, friendship)
 	if err != nil {
 		return nil, err
 	}
@@ -77,31 +80,80 @@ func (s *FriendshipService) SendFriendRequest(userID, friendID string) (*models.
 }
 
 // AcceptFriendRequest accepts a pending friend request
-func (s *FriendshipService) AcceptFriendRequest(friendshipID, userID string) error {
+func (s *FriendshipService) AcceptFriendRequest(ctx context.Context, friendshipID, userID string) error {
+	return s.transactor.WithTx(ctx, func(tx *repository.Set) error {
+		_, err := acceptFriendRequestTx(ctx, tx, friendshipID, userID)
+		return err
+	})
+}
+
+// AcceptFriendRequestAndOpenDM accepts a pending friend request and opens the direct
+// message room between the two users in the same transaction
+func (s *FriendshipService) AcceptFriendRequestAndOpenDM(ctx context.Context, friendshipID, userID string) (*models.Room, error) {
+	var room *models.Room
+
+	err := s.transactor.WithTx(ctx, func(tx *repository.Set) error {
+		friendship, err := acceptFriendRequestTx(ctx, tx, friendshipID, userID)
+		if err != nil {
+			return err
+		}
+
+		participants := []string{friendship.FriendID, friendship.UserID}
+		memberHash := dmMemberHash(participants)
+		room = &models.Room{
+			IsPrivate:  true,
+			Type:       "direct",
+			CreatorID:  userID,
+			MemberHash: &memberHash,
+		}
+
+		created, err := createDMRoomTx(ctx, tx, room, participants)
+		if err != nil || created {
+			return err
+		}
+
+		// The DM room already existed
+		room, err = tx.Rooms.FindByMemberHash(ctx, memberHash)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return room, nil
+}
+
+// acceptFriendRequestTx locks a friendship, verifies it can be accepted by the user and accepts it
+func acceptFriendRequestTx(ctx context.Context, tx *repository.Set, friendshipID, userID string) (*models.Friendship, error) {
 	// Get friendship
-	friendship, err := s.pgFriendship.FindByID(friendshipID)
+	friendship, err := tx.Friendships.FindByIDForUpdate(ctx, friendshipID)
 	if err != nil {
-		return errors.New("friendship not found")
+		return nil, errors.New("friendship not found")
 	}
 
 	// Verify the user is the recipient of the request
 	if friendship.FriendID != userID {
-		return errors.New("not authorized to accept this request")
+		return nil, errors.New("not authorized to accept this request")
 	}
 
 	// Verify the status is pending
 	if friendship.Status != models.FriendshipStatusPending {
-		return errors.New("friend request is not pending")
+		return nil, errors.New("friend request is not pending")
 	}
 
 	// Update status to accepted
-	return s.pgFriendship.UpdateStatus(friendshipID, models.FriendshipStatusAccepted)
+	if err := tx.Friendships.UpdateStatus(ctx, friendshipID, models.FriendshipStatusAccepted); err != nil {
+		return nil, err
+	}
+
+	friendship.Status = models.FriendshipStatusAccepted
+	return friendship, nil
 }
 
 // RejectFriendRequest rejects a pending friend request
-func (s *FriendshipService) RejectFriendRequest(friendshipID, userID string) error {
+func (s *FriendshipService) RejectFriendRequest(ctx context.Context, friendshipID, userID string) error {
 	// Get friendship
-	friendship, err := s.pgFriendship.FindByID(friendshipID)
+	friendship, err := s.friendships.FindByID(ctx, friendshipID)
 	if err != nil {
 		return errors.New("friendship not found")
 	}
@@ -117,16 +169,16 @@ func (s *FriendshipService) RejectFriendRequest(friendshipID, userID string) err
 	}
 
 	// Update status to rejected
-	return s.pgFriendship.UpdateStatus(friendshipID, models.FriendshipStatusRejected)
+	return s.friendships.UpdateStatus(ctx, friendshipID, models.FriendshipStatusRejected)
 }
 
 // BlockUser blocks another user
-func (s *FriendshipService) BlockUser(userID, blockUserID string) error {
+func (s *FriendshipService) BlockUser(ctx context.Context, userID, blockUserID string) error {
 	// Check if friendship already exists
-	friendship, err := s.pgFriendship.FindByUserAndFriend(userID, blockUserID)
+	friendship, err := s.friendships.FindByUserAndFriend(ctx, userID, blockUserID)
 	if err == nil {
 		// Update existing relationship to blocked
-		return s.pgFriendship.UpdateStatus(friendship.ID, models.FriendshipStatusBlocked)
+		return s.friendships.UpdateStatus(ctx, friendship.ID, models.FriendshipStatusBlocked)
 	}
 
 	// Create new blocked relationship
@@ -136,13 +188,13 @@ func (s *FriendshipService) BlockUser(userID, blockUserID string) error {
 		Status:   models.FriendshipStatusBlocked,
 	}
 
-	return s.pgFriendship.Create(friendship)
+	return s.friendships.Create(ctx, friendship)
 }
 
 // UnblockUser removes a block on a user
-func (s *FriendshipService) UnblockUser(userID, blockedUserID string) error {
+func (s *FriendshipService) UnblockUser(ctx context.Context, userID, blockedUserID string) error {
 	// Find the friendship
-	friendship, err := s.pgFriendship.FindByUserAndFriend(userID, blockedUserID)
+	friendship, err := s.friendships.FindByUserAndFriend(ctx, userID, blockedUserID)
 	if err != nil {
 		return errors.New("relationship not found")
 	}
@@ -157,13 +209,13 @@ func (s *FriendshipService) UnblockUser(userID, blockedUserID string) error {
 	}
 
 	// Delete the friendship record
-	return s.pgFriendship.Delete(friendship.ID)
+	return s.friendships.Delete(ctx, friendship.ID)
 }
 
 // RemoveFriend removes a friend connection
-func (s *FriendshipService) RemoveFriend(userID, friendID string) error {
+func (s *FriendshipService) RemoveFriend(ctx context.Context, userID, friendID string) error {
 	// Find the friendship
-	friendship, err := s.pgFriendship.FindByUserAndFriend(userID, friendID)
+	friendship, err := s.friendships.FindByUserAndFriend(ctx, userID, friendID)
 	if err != nil {
 		return errors.New("friendship not found")
 	}
@@ -173,32 +225,68 @@ func (s *FriendshipService) RemoveFriend(userID, friendID string) error {
 	}
 
 	// Delete the friendship record
-	return s.pgFriendship.Delete(friendship.ID)
+	return s.friendships.Delete(ctx, friendship.ID)
 }
 
 // GetFriends gets all accepted friends of a user
-func (s *FriendshipService) GetFriends(userID string) ([]*models.FriendshipWithUser, error) {
-	return s.pgFriendship.FindFriendsByUserID(userID, models.FriendshipStatusAccepted)
+func (s *FriendshipService) GetFriends(ctx context.Context, userID string) ([]*models.FriendshipWithUser, error) {
+	return s.friendships.FindFriendsByUserID(ctx, userID, models.FriendshipStatusAccepted)
 }
 
 // GetPendingRequests gets all pending friend requests for a user
-func (s *FriendshipService) GetPendingRequests(userID string) ([]*models.FriendshipWithUser, error) {
-	return s.pgFriendship.FindPendingRequests(userID)
+func (s *FriendshipService) GetPendingRequests(ctx context.Context, userID string) ([]*models.FriendshipWithUser, error) {
+	return s.friendships.FindPendingRequests(ctx, userID)
 }
 
 // GetAllRelationships gets all friendship relationships for a user
-func (s *FriendshipService) GetAllRelationships(userID string) ([]*models.FriendshipWithUser, error) {
-	return s.pgFriendship.FindAllUserRelationships(userID)
+func (s *FriendshipService) GetAllRelationships(ctx context.Context, userID string) ([]*models.FriendshipWithUser, error) {
+	return s.friendships.FindAllUserRelationships(ctx, userID)
+}
+
+// GetFriendSuggestions gets ranked friend suggestions for a user, each with a reason
+func (s *FriendshipService) GetFriendSuggestions(ctx context.Context, userID string, limit, offset int) ([]*models.FriendSuggestion, error) {
+	suggestions, err := s.friendships.FindSuggestions(ctx, userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, suggestion := range suggestions {
+		suggestion.Reason = suggestionReason(suggestion)
+	}
+
+	return suggestions, nil
 }
 
-// GetNonFriends gets users who are not connected to the specified user
-func (s *FriendshipService) GetNonFriends(userID string, limit, offset int) ([]*models.User, error) {
-	return s.pgFriendship.FindNonFriends(userID, limit, offset)
+// DismissSuggestion stops a user from being suggested to the current user
+func (s *FriendshipService) DismissSuggestion(ctx context.Context, userID, suggestedUserID string) error {
+	if userID == suggestedUserID {
+		return errors.New("cannot dismiss yourself")
+	}
+
+	if _, err := s.users.FindByID(ctx, suggestedUserID); err != nil {
+		return errors.New("user not found")
+	}
+
+	return s.friendships.DismissSuggestion(ctx, userID, suggestedUserID)
+}
+
+// suggestionReason describes why a user was suggested, preferring mutual friends
+func suggestionReason(suggestion *models.FriendSuggestion) string {
+	switch {
+	case suggestion.MutualFriends == 1:
+		return "1 mutual friend"
+	case suggestion.MutualFriends > 1:
+		return fmt.Sprintf("%d mutual friends", suggestion.MutualFriends)
+	case suggestion.SharedRooms > 0 && suggestion.SharedRoomName != "":
+		return "in #" + suggestion.SharedRoomName
+	default:
+		return ""
+	}
 }
 
 // GetFriendshipStatus gets the status of friendship between two users
-func (s *FriendshipService) GetFriendshipStatus(userID, otherUserID string) (models.FriendshipStatus, error) {
-	friendship, err := s.pgFriendship.FindByUserAndFriend(userID, otherUserID)
+func (s *FriendshipService) GetFriendshipStatus(ctx context.Context, userID, otherUserID string) (models.FriendshipStatus, error) {
+	friendship, err := s.friendships.FindByUserAndFriend(ctx, userID, otherUserID)
 	if err != nil {
 		return "", errors.New("no relationship found")
 	}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mjxoro/sent/server/internal/db/memory"
+	"github.com/mjxoro/sent/server/internal/models"
+)
+
+func TestAcceptFriendRequestAndOpenDM(t *testing.T) {
+	ctx := context.Background()
+	store, repos := newTestRepos(t)
+	svc := NewFriendshipService(store, repos.Friendships, repos.Users, memory.NewCache())
+	alice := createTestUser(t, repos, "alice")
+	bob := createTestUser(t, repos, "bob")
+
+	friendship, err := svc.SendFriendRequest(ctx, alice.ID, bob.ID)
+	if err != nil {
+		t.Fatalf("SendFriendRequest: %v", err)
+	}
+
+	if err := svc.AcceptFriendRequest(ctx, friendship.ID, alice.ID); err == nil {
+		t.Fatal("expected the sender to be unable to accept their own request")
+	}
+
+	room, err := svc.AcceptFriendRequestAndOpenDM(ctx, friendship.ID, bob.ID)
+	if err != nil {
+		t.Fatalf("AcceptFriendRequestAndOpenDM: %v", err)
+	}
+
+	status, err := svc.GetFriendshipStatus(ctx, alice.ID, bob.ID)
+	if err != nil || status != models.FriendshipStatusAccepted {
+		t.Fatalf("expected accepted friendship, got %q (%v)", status, err)
+	}
+
+	chat := NewChatService(store, repos.Rooms, repos.Messages, repos.Users, memory.NewPubSub())
+	dm, err := chat.CreateDirectMessageRoom(ctx, alice.ID, bob.ID)
+	if err != nil {
+		t.Fatalf("CreateDirectMessageRoom: %v", err)
+	}
+	if dm.ID != room.ID {
+		t.Fatal("expected the DM opened on accept to be reused")
+	}
+}
+
+func TestGetFriendSuggestions(t *testing.T) {
+	ctx := context.Background()
+	store, repos := newTestRepos(t)
+	svc := NewFriendshipService(store, repos.Friendships, repos.Users, memory.NewCache())
+	chat := NewChatService(store, repos.Rooms, repos.Messages, repos.Users, memory.NewPubSub())
+
+	alice := createTestUser(t, repos, "alice")
+	bob := createTestUser(t, repos, "bob")
+	carol := createTestUser(t, repos, "carol")
+	dave := createTestUser(t, repos, "dave")
+	erin := createTestUser(t, repos, "erin")
+	frank := createTestUser(t, repos, "frank")
+
+	befriend := func(a, b *models.User) {
+		t.Helper()
+		friendship, err := svc.SendFriendRequest(ctx, a.ID, b.ID)
+		if err != nil {
+			t.Fatalf("SendFriendRequest: %v", err)
+		}
+		if err := svc.AcceptFriendRequest(ctx, friendship.ID, b.ID); err != nil {
+			t.Fatalf("AcceptFriendRequest: %v", err)
+		}
+	}
+
+	// carol is a friend of two of alice's friends, dave shares a room with alice
+	befriend(alice, bob)
+	befriend(alice, erin)
+	befriend(bob, carol)
+	befriend(erin, carol)
+	if _, err := chat.CreateRoom(ctx, "design", "", false, alice.ID, dave.ID); err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+	if err := svc.BlockUser(ctx, frank.ID, alice.ID); err != nil {
+		t.Fatalf("BlockUser: %v", err)
+	}
+
+	suggestions, err := svc.GetFriendSuggestions(ctx, alice.ID, 10, 0)
+	if err != nil {
+		t.Fatalf("GetFriendSuggestions: %v", err)
+	}
+	if len(suggestions) != 2 {
+		t.Fatalf("expected 2 suggestions, got %d", len(suggestions))
+	}
+	if suggestions[0].ID != carol.ID || suggestions[0].Reason != "2 mutual friends" {
+		t.Fatalf("unexpected first suggestion: %s (%q)", suggestions[0].Name, suggestions[0].Reason)
+	}
+	if suggestions[1].ID != dave.ID || suggestions[1].Reason != "in #design" {
+		t.Fatalf("unexpected second suggestion: %s (%q)", suggestions[1].Name, suggestions[1].Reason)
+	}
+
+	if err := svc.DismissSuggestion(ctx, alice.ID, carol.ID); err != nil {
+		t.Fatalf("DismissSuggestion: %v", err)
+	}
+	suggestions, err = svc.GetFriendSuggestions(ctx, alice.ID, 10, 0)
+	if err != nil {
+		t.Fatalf("GetFriendSuggestions: %v", err)
+	}
+	if len(suggestions) != 1 || suggestions[0].ID != dave.ID {
+		t.Fatalf("expected only dave after dismissing carol, got %d suggestions", len(suggestions))
+	}
+}
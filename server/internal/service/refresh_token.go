@@ -2,38 +2,139 @@
 package service
 
 import (
-	"github.com/mjxoro/sent/server/internal/db/postgres"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
 	"time"
+
+	"github.com/google/uuid"
+	"github.com/mjxoro/sent/server/internal/models"
+	"github.com/mjxoro/sent/server/internal/repository"
+)
+
+var (
+	// ErrInvalidRefreshToken is returned when a refresh token is unknown, expired or revoked
+	ErrInvalidRefreshToken = errors.New("refresh token not valid")
+
+	// ErrRefreshTokenReused is returned when a refresh token that was already
+	// rotated is presented again. Its whole family is revoked.
+	ErrRefreshTokenReused = errors.New("refresh token was already used")
 )
 
 // RefreshTokenService handles refresh token business logic
 type RefreshTokenService struct {
-	pgRefreshToken *postgres.RefreshToken
+	transactor    repository.Transactor
+	refreshTokens repository.RefreshToken
 }
 
 // NewRefreshTokenService creates a new refresh token service
-func NewRefreshTokenService(pgRefreshToken *postgres.RefreshToken) *RefreshTokenService {
+func NewRefreshTokenService(transactor repository.Transactor, refreshTokens repository.RefreshToken) *RefreshTokenService {
 	return &RefreshTokenService{
-		pgRefreshToken: pgRefreshToken,
+		transactor:    transactor,
+		refreshTokens: refreshTokens,
 	}
 }
 
-// Store stores a refresh token for a user
-func (s *RefreshTokenService) Store(userID, token string, expiresAt time.Time) error {
-	return s.pgRefreshToken.Store(userID, token, expiresAt)
+// Store stores a refresh token for a user, starting a new token family
+func (s *RefreshTokenService) Store(ctx context.Context, userID, token string, expiresAt time.Time) error {
+	return s.refreshTokens.Create(ctx, &models.RefreshToken{
+		UserID:    userID,
+		FamilyID:  uuid.NewString(),
+		TokenHash: hashRefreshToken(token),
+		ExpiresAt: expiresAt,
+	})
 }
 
-// Validate checks if a refresh token is valid
-func (s *RefreshTokenService) Validate(userID, token string) (bool, error) {
-	return s.pgRefreshToken.Validate(userID, token)
+// Rotate exchanges a user's valid refresh token for next, which joins the same
+// family. A token can only be rotated once: presenting it again means it leaked,
+// so every token in its family is revoked and ErrRefreshTokenReused is returned.
+func (s *RefreshTokenService) Rotate(ctx context.Context, userID, token, next string, nextExpiresAt time.Time) error {
+	var reused *models.RefreshToken
+
+	err := s.transactor.WithTx(ctx, func(tx *repository.Set) error {
+		current, err := tx.RefreshTokens.GetByHash(ctx, hashRefreshToken(token))
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrInvalidRefreshToken
+		}
+		if err != nil {
+			return err
+		}
+		if current.UserID != userID {
+			return ErrInvalidRefreshToken
+		}
+		if current.RotatedAt != nil {
+			reused = current
+			return ErrRefreshTokenReused
+		}
+		if current.IsRevoked || !current.ExpiresAt.After(time.Now()) {
+			return ErrInvalidRefreshToken
+		}
+
+		// Concurrent rotations of the same token race here, and only one wins
+		rotated, err := tx.RefreshTokens.MarkRotated(ctx, current.ID)
+		if err != nil {
+			return err
+		}
+		if !rotated {
+			reused = current
+			return ErrRefreshTokenReused
+		}
+
+		return tx.RefreshTokens.Create(ctx, &models.RefreshToken{
+			UserID:    userID,
+			FamilyID:  current.FamilyID,
+			TokenHash: hashRefreshToken(next),
+			ExpiresAt: nextExpiresAt,
+		})
+	})
+
+	// The family is revoked outside the transaction, which has been rolled back
+	if errors.Is(err, ErrRefreshTokenReused) {
+		log.Printf("Refresh token reused for user %s, revoking token family %s", userID, reused.FamilyID)
+		if revokeErr := s.refreshTokens.RevokeFamily(ctx, reused.FamilyID); revokeErr != nil {
+			return fmt.Errorf("revoking reused refresh token family: %w", revokeErr)
+		}
+	}
+	return err
 }
 
 // Revoke revokes a refresh token
-func (s *RefreshTokenService) Revoke(userID, token string) error {
-	return s.pgRefreshToken.Revoke(userID, token)
+func (s *RefreshTokenService) Revoke(ctx context.Context, userID, token string) error {
+	return s.refreshTokens.Revoke(ctx, userID, hashRefreshToken(token))
 }
 
 // RevokeAllForUser revokes all refresh tokens for a user
-func (s *RefreshTokenService) RevokeAllForUser(userID string) error {
-	return s.pgRefreshToken.RevokeAllForUser(userID)
+func (s *RefreshTokenService) RevokeAllForUser(ctx context.Context, userID string) error {
+	return s.refreshTokens.RevokeAllForUser(ctx, userID)
+}
+
+// RunCleanup deletes expired refresh tokens every interval until ctx is done
+func (s *RefreshTokenService) RunCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			deleted, err := s.refreshTokens.DeleteExpired(ctx)
+			if err != nil {
+				log.Printf("Failed to delete expired refresh tokens: %v", err)
+			} else if deleted > 0 {
+				log.Printf("Deleted %d expired refresh tokens", deleted)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// hashRefreshToken returns the hash refresh tokens are stored under. Tokens are
+// long and random, so a fast unsalted hash is enough.
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
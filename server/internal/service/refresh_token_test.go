@@ -0,0 +1,101 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mjxoro/sent/server/internal/db/memory"
+)
+
+// newTestRefreshTokenService creates a refresh token service backed by an in-memory store
+func newTestRefreshTokenService() (*RefreshTokenService, *memory.Store) {
+	store := memory.NewStore()
+	return NewRefreshTokenService(store, store.Repositories().RefreshTokens), store
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	ctx := context.Background()
+	svc, store := newTestRefreshTokenService()
+	expiresAt := time.Now().Add(time.Hour)
+
+	if err := svc.Store(ctx, "alice", "first", expiresAt); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	if err := svc.Rotate(ctx, "alice", "first", "second", expiresAt); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	if err := svc.Rotate(ctx, "alice", "second", "third", expiresAt); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+
+	// Tokens are only stored hashed
+	stored, err := store.Repositories().RefreshTokens.GetByHash(ctx, hashRefreshToken("third"))
+	if err != nil {
+		t.Fatalf("GetByHash: %v", err)
+	}
+	if stored.TokenHash == "third" || stored.UserID != "alice" {
+		t.Fatalf("unexpected stored token: %+v", stored)
+	}
+
+	if err := svc.Rotate(ctx, "bob", "third", "fourth", expiresAt); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("expected ErrInvalidRefreshToken rotating another user's token, got %v", err)
+	}
+	if err := svc.Rotate(ctx, "alice", "unknown", "fourth", expiresAt); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("expected ErrInvalidRefreshToken for an unknown token, got %v", err)
+	}
+}
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	ctx := context.Background()
+	svc, _ := newTestRefreshTokenService()
+	expiresAt := time.Now().Add(time.Hour)
+
+	if err := svc.Store(ctx, "alice", "phone", expiresAt); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	if err := svc.Store(ctx, "alice", "laptop", expiresAt); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	if err := svc.Rotate(ctx, "alice", "phone", "phone-2", expiresAt); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+
+	// Replaying the rotated token revokes the token it was exchanged for
+	if err := svc.Rotate(ctx, "alice", "phone", "stolen", expiresAt); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("expected ErrRefreshTokenReused, got %v", err)
+	}
+	if err := svc.Rotate(ctx, "alice", "phone-2", "phone-3", expiresAt); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("expected the family to be revoked, got %v", err)
+	}
+
+	// Other logins are unaffected
+	if err := svc.Rotate(ctx, "alice", "laptop", "laptop-2", expiresAt); err != nil {
+		t.Fatalf("expected another family to keep working, got %v", err)
+	}
+}
+
+func TestRefreshTokenExpiry(t *testing.T) {
+	ctx := context.Background()
+	svc, store := newTestRefreshTokenService()
+
+	if err := svc.Store(ctx, "alice", "expired", time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	if err := svc.Store(ctx, "alice", "current", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+
+	if err := svc.Rotate(ctx, "alice", "expired", "next", time.Now().Add(time.Hour)); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Fatalf("expected ErrInvalidRefreshToken for an expired token, got %v", err)
+	}
+
+	deleted, err := store.Repositories().RefreshTokens.DeleteExpired(ctx)
+	if err != nil || deleted != 1 {
+		t.Fatalf("expected 1 expired token deleted, got %d (%v)", deleted, err)
+	}
+	if err := svc.Rotate(ctx, "alice", "current", "next", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("expected the current token to survive cleanup, got %v", err)
+	}
+}
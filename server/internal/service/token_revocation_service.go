@@ -0,0 +1,87 @@
+// internal/service/token_revocation_service.go
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/mjxoro/sent/server/internal/repository"
+)
+
+// TokenRevocationService revokes access tokens before they expire, for logging
+// out. Single tokens are denylisted by ID until they expire; logging out
+// everywhere revokes every token issued to the user up to that moment.
+type TokenRevocationService struct {
+	cache repository.Cache
+
+	// maxTokenLifetime is how long any access token lasts, and so how long a
+	// revocation has to be remembered
+	maxTokenLifetime time.Duration
+}
+
+// NewTokenRevocationService creates a new token revocation service for access
+// tokens that last at most maxTokenLifetime
+func NewTokenRevocationService(cache repository.Cache, maxTokenLifetime time.Duration) *TokenRevocationService {
+	return &TokenRevocationService{
+		cache:            cache,
+		maxTokenLifetime: maxTokenLifetime,
+	}
+}
+
+// RevokeToken revokes the access token with tokenID until it expires at
+// expiresAt. Tokens without an ID can't be revoked individually.
+func (s *TokenRevocationService) RevokeToken(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	if tokenID == "" {
+		return nil
+	}
+
+	ttl := s.maxTokenLifetime
+	if !expiresAt.IsZero() {
+		ttl = time.Until(expiresAt)
+	}
+	if ttl <= 0 {
+		return nil
+	}
+	return s.cache.Set(ctx, revokedTokenKey(tokenID), true, ttl)
+}
+
+// RevokeAllForUser revokes every access token issued to a user so far
+func (s *TokenRevocationService) RevokeAllForUser(ctx context.Context, userID string) error {
+	return s.cache.Set(ctx, revokedBeforeKey(userID), time.Now().UnixNano(), s.maxTokenLifetime)
+}
+
+// IsRevoked reports whether a user's access token, identified by tokenID and
+// issued at issuedAt, was revoked
+func (s *TokenRevocationService) IsRevoked(ctx context.Context, userID, tokenID string, issuedAt time.Time) (bool, error) {
+	if tokenID != "" {
+		var revoked bool
+		err := s.cache.Get(ctx, revokedTokenKey(tokenID), &revoked)
+		if err == nil {
+			return true, nil
+		}
+		if !errors.Is(err, repository.ErrCacheMiss) {
+			return false, err
+		}
+	}
+
+	var revokedBefore int64
+	if err := s.cache.Get(ctx, revokedBeforeKey(userID), &revokedBefore); err != nil {
+		if errors.Is(err, repository.ErrCacheMiss) {
+			return false, nil
+		}
+		return false, err
+	}
+	return !issuedAt.After(time.Unix(0, revokedBefore)), nil
+}
+
+// revokedTokenKey returns the cache key marking a single access token as revoked
+func revokedTokenKey(tokenID string) string {
+	return "auth:revoked:" + tokenID
+}
+
+// revokedBeforeKey returns the cache key holding the time, in Unix nanoseconds,
+// that a user's tokens issued up to were revoked
+func revokedBeforeKey(userID string) string {
+	return "auth:revoked_before:" + userID
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mjxoro/sent/server/internal/auth"
+	"github.com/mjxoro/sent/server/internal/db/memory"
+)
+
+func TestTokenRevocation(t *testing.T) {
+	ctx := context.Background()
+	svc := NewTokenRevocationService(memory.NewCache(), time.Hour)
+	issuedAt := time.Now().Add(-time.Minute)
+
+	if err := svc.RevokeToken(ctx, "phone", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("RevokeToken: %v", err)
+	}
+	for tokenID, want := range map[string]bool{"phone": true, "laptop": false} {
+		if revoked, err := svc.IsRevoked(ctx, "alice", tokenID, issuedAt); err != nil || revoked != want {
+			t.Errorf("IsRevoked(%q) = %v, %v; want %v", tokenID, revoked, err, want)
+		}
+	}
+
+	// Logging out everywhere revokes tokens issued until then, and no later
+	if err := svc.RevokeAllForUser(ctx, "alice"); err != nil {
+		t.Fatalf("RevokeAllForUser: %v", err)
+	}
+	for _, tc := range []struct {
+		userID   string
+		issuedAt time.Time
+		want     bool
+	}{
+		{"alice", issuedAt, true},
+		{"alice", time.Time{}, true},
+		{"alice", time.Now().Add(2 * time.Second), false},
+		{"bob", issuedAt, false},
+	} {
+		if revoked, err := svc.IsRevoked(ctx, tc.userID, "laptop", tc.issuedAt); err != nil || revoked != tc.want {
+			t.Errorf("IsRevoked(%s, issued %v) = %v, %v; want %v", tc.userID, tc.issuedAt, revoked, err, tc.want)
+		}
+	}
+}
+
+func TestTokenRevocationAcceptsLoginRightAfterLogoutAll(t *testing.T) {
+	ctx := context.Background()
+	jwtService := auth.NewJWTService()
+	svc := NewTokenRevocationService(memory.NewCache(), jwtService.TokenDuration())
+
+	issue := func() *auth.TokenClaims {
+		t.Helper()
+		token, err := jwtService.GenerateToken("alice", "alice@example.com", "Alice", "")
+		if err != nil {
+			t.Fatalf("GenerateToken: %v", err)
+		}
+		claims, err := jwtService.ValidateToken(token)
+		if err != nil {
+			t.Fatalf("ValidateToken: %v", err)
+		}
+		return claims
+	}
+
+	// Both tokens are issued within the same second as logging out everywhere
+	before := issue()
+	if err := svc.RevokeAllForUser(ctx, "alice"); err != nil {
+		t.Fatalf("RevokeAllForUser: %v", err)
+	}
+	after := issue()
+
+	if revoked, err := svc.IsRevoked(ctx, "alice", before.ID, before.IssuedAtTime()); err != nil || !revoked {
+		t.Errorf("expected the token issued before logging out to be revoked, got %v, %v", revoked, err)
+	}
+	if revoked, err := svc.IsRevoked(ctx, "alice", after.ID, after.IssuedAtTime()); err != nil || revoked {
+		t.Errorf("expected the token issued after logging out to be accepted, got %v, %v", revoked, err)
+	}
+}
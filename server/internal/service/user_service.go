@@ -2,49 +2,50 @@
 package service
 
 import (
-	"github.com/mjxoro/sent/server/internal/db/postgres"
+	"context"
 	"github.com/mjxoro/sent/server/internal/models"
+	"github.com/mjxoro/sent/server/internal/repository"
 	"time"
 )
 
 // UserService handles user-related business logic
 type UserService struct {
-	pgUser *postgres.User
+	users repository.User
 }
 
 // NewUserService creates a new user service
-func NewUserService(pgUser *postgres.User) *UserService {
+func NewUserService(users repository.User) *UserService {
 	return &UserService{
-		pgUser: pgUser,
+		users: users,
 	}
 }
 
 // GetByID gets a user by ID
-func (s *UserService) GetByID(id string) (*models.User, error) {
-	return s.pgUser.FindByID(id)
+func (s *UserService) GetByID(ctx context.Context, id string) (*models.User, error) {
+	return s.users.FindByID(ctx, id)
 }
 
 // FindByEmail gets a user by email
-func (s *UserService) FindByEmail(email string) (*models.User, error) {
-	return s.pgUser.FindByEmail(email)
+func (s *UserService) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	return s.users.FindByEmail(ctx, email)
 }
 
 // FindOrCreateFromOAuth finds or creates a user from OAuth data
-func (s *UserService) FindOrCreateFromOAuth(userInput *models.User, provider string) (*models.User, error) {
+func (s *UserService) FindOrCreateFromOAuth(ctx context.Context, userInput *models.User, provider string) (*models.User, error) {
 	// Try to find user by OAuth ID and provider
-	user, err := s.pgUser.FindByOAuthID(userInput.OAuthID, provider)
+	user, err := s.users.FindByOAuthID(ctx, userInput.OAuthID, provider)
 	if err == nil {
 		// User exists, return it
 		return user, nil
 	}
 	// User not found, try to find by email
-	user, err = s.pgUser.FindByEmail(userInput.Email)
+	user, err = s.users.FindByEmail(ctx, userInput.Email)
 	if err == nil {
 		// User exists with this email but different OAuth provider
 		// Update the OAuth ID if it's from the same provider
 		if user.Provider == provider {
 			user.OAuthID = userInput.OAuthID
-			if err := s.pgUser.Update(user); err != nil {
+			if err := s.users.Update(ctx, user); err != nil {
 				return nil, err
 			}
 		}
@@ -60,7 +61,7 @@ func (s *UserService) FindOrCreateFromOAuth(userInput *models.User, provider str
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
-	if err := s.pgUser.Create(user); err != nil {
+	if err := s.users.Create(ctx, user); err != nil {
 		return nil, err
 	}
 	return user, nil
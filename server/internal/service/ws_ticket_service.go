@@ -0,0 +1,70 @@
+// internal/service/ws_ticket_service.go
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/mjxoro/sent/server/internal/repository"
+)
+
+// WSTicketTTL is how long a WebSocket ticket can be redeemed for
+const WSTicketTTL = 30 * time.Second
+
+// ErrInvalidTicket is returned when a ticket doesn't exist, has expired or was already used
+var ErrInvalidTicket = errors.New("invalid or expired ticket")
+
+// WSTicket is what a ticket stands in for: the user and the access token it was issued for
+type WSTicket struct {
+	UserID         string    `json:"user_id"`
+	TokenID        string    `json:"token_id"`
+	TokenIssuedAt  time.Time `json:"token_issued_at"`
+	TokenExpiresAt time.Time `json:"token_expires_at"`
+}
+
+// WSTicketService issues short-lived, single-use tickets for opening a WebSocket,
+// so clients don't have to put their access token in the URL
+type WSTicketService struct {
+	cache repository.Cache
+}
+
+// NewWSTicketService creates a new WebSocket ticket service
+func NewWSTicketService(cache repository.Cache) *WSTicketService {
+	return &WSTicketService{
+		cache: cache,
+	}
+}
+
+// Issue creates a ticket standing in for t
+func (s *WSTicketService) Issue(ctx context.Context, t WSTicket) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	ticket := hex.EncodeToString(b)
+
+	if err := s.cache.Set(ctx, ticketKey(ticket), t, WSTicketTTL); err != nil {
+		return "", err
+	}
+	return ticket, nil
+}
+
+// Redeem uses up a ticket and returns what it stands in for
+func (s *WSTicketService) Redeem(ctx context.Context, ticket string) (*WSTicket, error) {
+	var t WSTicket
+	if err := s.cache.Take(ctx, ticketKey(ticket), &t); err != nil {
+		if errors.Is(err, repository.ErrCacheMiss) {
+			return nil, ErrInvalidTicket
+		}
+		return nil, err
+	}
+	return &t, nil
+}
+
+// ticketKey returns the cache key a ticket is stored under
+func ticketKey(ticket string) string {
+	return "ws:ticket:" + ticket
+}
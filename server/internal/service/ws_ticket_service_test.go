@@ -0,0 +1,36 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mjxoro/sent/server/internal/db/memory"
+)
+
+func TestWSTicketIsSingleUse(t *testing.T) {
+	ctx := context.Background()
+	svc := NewWSTicketService(memory.NewCache())
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	ticket, err := svc.Issue(ctx, WSTicket{UserID: "alice", TokenID: "token", TokenExpiresAt: expiresAt})
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+
+	redeemed, err := svc.Redeem(ctx, ticket)
+	if err != nil {
+		t.Fatalf("Redeem: %v", err)
+	}
+	if redeemed.UserID != "alice" || redeemed.TokenID != "token" || !redeemed.TokenExpiresAt.Equal(expiresAt) {
+		t.Fatalf("unexpected ticket: %+v", redeemed)
+	}
+
+	if _, err := svc.Redeem(ctx, ticket); !errors.Is(err, ErrInvalidTicket) {
+		t.Fatalf("expected ErrInvalidTicket redeeming a ticket twice, got %v", err)
+	}
+	if _, err := svc.Redeem(ctx, "not-a-ticket"); !errors.Is(err, ErrInvalidTicket) {
+		t.Fatalf("expected ErrInvalidTicket for an unknown ticket, got %v", err)
+	}
+}
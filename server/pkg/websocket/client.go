@@ -2,7 +2,11 @@
 package websocket
 
 import (
+	"context"
+	"errors"
+	"io"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -19,16 +23,42 @@ const (
 	pingPeriod = (pongWait * 9) / 10
 
 	// Maximum message size allowed from peer
-	maxMessageSize = 512
+	maxMessageSize = 16 * 1024
+
+	// Messages smaller than this are sent uncompressed, as deflate gains little on them
+	compressionThreshold = 512
 )
 
+// ClientInfo describes the device behind a client connection
+type ClientInfo struct {
+	SessionID   string    `json:"session_id"`
+	DeviceID    string    `json:"device_id,omitempty"`
+	Transport   string    `json:"transport"`
+	UserAgent   string    `json:"user_agent,omitempty"`
+	RemoteAddr  string    `json:"remote_addr,omitempty"`
+	ConnectedAt time.Time `json:"connected_at"`
+}
+
 // Client represents a connected WebSocket client
 type Client struct {
-	Hub   *Hub
-	Conn  *websocket.Conn
-	Send  chan []byte
-	ID    string
-	Rooms map[string]bool // Changed from a single Room string to a map of rooms
+	Hub  *Hub
+	Conn *websocket.Conn
+	ID   string
+
+	// Info describes the client's device. It is set before the client is
+	// registered and not changed afterwards.
+	Info ClientInfo
+
+	// rooms is the set of rooms the client is subscribed to. Only the hub changes
+	// it; other goroutines read it through IsInRoom and RoomIDs.
+	roomsMu sync.RWMutex
+	rooms   map[string]bool
+
+	// queue holds frames waiting to be written by WritePump
+	queue *sendQueue
+
+	// codec encodes frames for the subprotocol negotiated on Conn
+	codec frameCodec
 }
 
 // NewClient creates a new WebSocket client
@@ -36,18 +66,19 @@ func NewClient(hub *Hub, conn *websocket.Conn, id string) *Client {
 	return &Client{
 		Hub:   hub,
 		Conn:  conn,
-		Send:  make(chan []byte, 256),
 		ID:    id,
-		Rooms: make(map[string]bool),
+		rooms: make(map[string]bool),
+		queue: newSendQueue(hub.policy, &hub.metrics),
+		codec: codecFor(conn),
 	}
 }
 
-// ReadPump pumps messages from the WebSocket connection to the hub
-func (c *Client) ReadPump() {
-	defer func() {
-		c.Hub.Unregister <- c
-		c.Conn.Close()
-	}()
+// ReadPump reads frames from the WebSocket connection and passes each one to
+// handler, in order. It enforces the maximum frame size and closes the connection
+// if no frame or pong arrives within pongWait. It returns once the connection is
+// closed; the caller is responsible for unregistering the client.
+func (c *Client) ReadPump(handler func([]byte)) {
+	defer c.Conn.Close()
 
 	c.Conn.SetReadLimit(maxMessageSize)
 	c.Conn.SetReadDeadline(time.Now().Add(pongWait))
@@ -57,7 +88,11 @@ func (c *Client) ReadPump() {
 	})
 
 	for {
-		_, message, err := c.Conn.ReadMessage()
+		message, err := readMessage(c.Conn)
+		if errors.Is(err, errMessageTooBig) {
+			c.Conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseMessageTooBig, ""), time.Now().Add(writeWait))
+			break
+		}
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("error: %v", err)
@@ -65,17 +100,60 @@ func (c *Client) ReadPump() {
 			break
 		}
 
-		// Send raw message bytes to the hub
-		// Message parsing and handling will be done in the handler
-		c.Hub.Broadcast <- &Message{
-			Data:   message,
-			Client: c,
+		// Any frame shows the peer is alive
+		c.Conn.SetReadDeadline(time.Now().Add(pongWait))
+
+		// Frames that can't be decoded are passed on as is, to be rejected as invalid
+		if decoded, err := c.codec.decode(message); err == nil {
+			message = decoded
 		}
+
+		handler(message)
+	}
+}
+
+// errMessageTooBig is returned when a decompressed message exceeds maxMessageSize
+var errMessageTooBig = errors.New("message too big")
+
+// readMessage reads the next message. The connection's read limit only applies
+// to the bytes on the wire, so compressed messages are also limited once inflated.
+func readMessage(conn *websocket.Conn) ([]byte, error) {
+	_, r, err := conn.NextReader()
+	if err != nil {
+		return nil, err
+	}
+
+	message, err := io.ReadAll(io.LimitReader(r, maxMessageSize+1))
+	if err != nil {
+		return nil, err
 	}
+	if len(message) > maxMessageSize {
+		return nil, errMessageTooBig
+	}
+	return message, nil
 }
 
-// WritePump pumps messages from the hub to the WebSocket connection
+// ReadFrame reads a single frame from a connection that has no client yet, such
+// as one that must authenticate first, waiting at most timeout. The frame is
+// limited and decoded exactly as ReadPump does.
+func ReadFrame(conn *websocket.Conn, timeout time.Duration) ([]byte, error) {
+	conn.SetReadLimit(maxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(timeout))
+
+	message, err := readMessage(conn)
+	if err != nil {
+		return nil, err
+	}
+	return codecFor(conn).decode(message)
+}
+
+// WritePump pumps messages from the hub to the WebSocket connection. Start it
+// before registering the client, so that stopping the hub waits for it.
 func (c *Client) WritePump() {
+	if c.Hub.startWriter() {
+		defer c.Hub.writers.Done()
+	}
+
 	ticker := time.NewTicker(pingPeriod)
 	defer func() {
 		ticker.Stop()
@@ -84,28 +162,22 @@ func (c *Client) WritePump() {
 
 	for {
 		select {
-		case message, ok := <-c.Send:
-			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if !ok {
-				// The hub closed the channel
-				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
-			}
+		case <-c.queue.ready:
+			frames, closed, closeCode := c.queue.drain()
 
-			w, err := c.Conn.NextWriter(websocket.TextMessage)
-			if err != nil {
-				return
+			if len(frames) > 0 {
+				if err := c.writeBatch(frames); err != nil {
+					return
+				}
 			}
-			w.Write(message)
 
-			// Add queued chat messages to the current WebSocket message
-			n := len(c.Send)
-			for i := 0; i < n; i++ {
-				w.Write([]byte{'\n'})
-				w.Write(<-c.Send)
-			}
-
-			if err := w.Close(); err != nil {
+			if closed {
+				c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+				closeMessage := []byte{}
+				if closeCode != 0 {
+					closeMessage = websocket.FormatCloseMessage(closeCode, "")
+				}
+				c.Conn.WriteMessage(websocket.CloseMessage, closeMessage)
 				return
 			}
 		case <-ticker.C:
@@ -117,18 +189,131 @@ func (c *Client) WritePump() {
 	}
 }
 
+// Pump delivers the client's queued frames with write until the client is closed
+// or ctx is done, calling keepAlive every interval. It is for clients without a
+// WebSocket connection, such as Server-Sent Events streams. It returns the code
+// the client was closed with, or 0 if ctx ended or a write failed first.
+func (c *Client) Pump(ctx context.Context, interval time.Duration, write func(frames [][]byte) error, keepAlive func() error) int {
+	if c.Hub.startWriter() {
+		defer c.Hub.writers.Done()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.queue.ready:
+			frames, closed, closeCode := c.queue.drain()
+			if len(frames) > 0 {
+				if err := write(frames); err != nil {
+					return 0
+				}
+			}
+			if closed {
+				return closeCode
+			}
+		case <-ticker.C:
+			if err := keepAlive(); err != nil {
+				return 0
+			}
+		case <-ctx.Done():
+			return 0
+		}
+	}
+}
+
+// Poll waits until frames are queued for the client, the client is closed or ctx
+// is done, and returns the queued frames. It is for long-polling clients; closed
+// reports whether the client was closed, with closeCode.
+func (c *Client) Poll(ctx context.Context) (frames [][]byte, closed bool, closeCode int) {
+	for {
+		select {
+		case <-c.queue.ready:
+			frames, closed, closeCode = c.queue.drain()
+			if len(frames) > 0 || closed {
+				return frames, closed, closeCode
+			}
+		case <-ctx.Done():
+			return nil, false, 0
+		}
+	}
+}
+
+// writeBatch writes queued frames as one WebSocket message, encoded for the
+// client's subprotocol. Compression, when negotiated, is skipped for small batches.
+func (c *Client) writeBatch(frames [][]byte) error {
+	size := 0
+	for _, frame := range frames {
+		size += len(frame)
+	}
+	c.Conn.EnableWriteCompression(size >= compressionThreshold)
+
+	c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+	w, err := c.Conn.NextWriter(c.codec.messageType())
+	if err != nil {
+		return err
+	}
+
+	if err := c.codec.writeBatch(w, frames); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// Send queues a frame for the client, reporting whether it was queued. If the
+// client's queue has been full for too long, the client is disconnected.
+func (c *Client) Send(data []byte) bool {
+	return c.push(data, false) == pushQueued
+}
+
+// push queues a frame, closing the connection on sustained overflow. Ephemeral
+// frames such as typing indicators are dropped first when the queue is full.
+func (c *Client) push(data []byte, ephemeral bool) pushResult {
+	result := c.queue.push(data, ephemeral)
+	if result == pushOverflow {
+		log.Printf("Disconnecting client %s: send queue overflowed", c.ID)
+	}
+	return result
+}
+
 // IsInRoom checks if client is subscribed to a room
 func (c *Client) IsInRoom(roomID string) bool {
-	_, ok := c.Rooms[roomID]
-	return ok
+	c.roomsMu.RLock()
+	defer c.roomsMu.RUnlock()
+	return c.rooms[roomID]
+}
+
+// RoomIDs returns the rooms the client is subscribed to
+func (c *Client) RoomIDs() []string {
+	c.roomsMu.RLock()
+	defer c.roomsMu.RUnlock()
+
+	roomIDs := make([]string, 0, len(c.rooms))
+	for roomID := range c.rooms {
+		roomIDs = append(roomIDs, roomID)
+	}
+	return roomIDs
+}
+
+// joinRoom records that the client is in a room. It is only called by the hub.
+func (c *Client) joinRoom(roomID string) {
+	c.roomsMu.Lock()
+	defer c.roomsMu.Unlock()
+	c.rooms[roomID] = true
 }
 
-// JoinRoom adds client to a room
-func (c *Client) JoinRoom(roomID string) {
-	c.Rooms[roomID] = true
+// leaveRoom records that the client left a room. It is only called by the hub.
+func (c *Client) leaveRoom(roomID string) {
+	c.roomsMu.Lock()
+	defer c.roomsMu.Unlock()
+	delete(c.rooms, roomID)
 }
 
-// LeaveRoom removes client from a room
-func (c *Client) LeaveRoom(roomID string) {
-	delete(c.Rooms, roomID)
+// Close closes the client's queue so that WritePump closes the connection with
+// code, such as a 4000-4999 application code, after writing any queued frames.
+// It is safe to call more than once.
+func (c *Client) Close(code int) {
+	c.queue.close(code)
 }
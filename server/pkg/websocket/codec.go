@@ -0,0 +1,182 @@
+// pkg/websocket/codec.go
+package websocket
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+
+	"github.com/gorilla/websocket"
+	"github.com/ugorji/go/codec"
+)
+
+// Subprotocols a client can ask for when connecting. Clients that don't ask
+// for one get newline-separated JSON text messages.
+const (
+	// SubprotocolJSON sends each batch of frames as a JSON array in a text message
+	SubprotocolJSON = "sent.json"
+
+	// SubprotocolMsgpack sends each batch of frames as a MessagePack array in a
+	// binary message, and expects MessagePack frames from the client
+	SubprotocolMsgpack = "sent.msgpack"
+)
+
+// Subprotocols lists the subprotocols the server supports, in order of preference
+var Subprotocols = []string{SubprotocolMsgpack, SubprotocolJSON}
+
+// frameCodec encodes batches of queued frames onto the wire and decodes frames
+// from the client. Frames are JSON inside the server whatever the codec.
+type frameCodec interface {
+	// messageType is the WebSocket message type batches are written as
+	messageType() int
+
+	// writeBatch writes JSON frames to w as one message
+	writeBatch(w io.Writer, frames [][]byte) error
+
+	// decode converts a message from the client into a JSON frame
+	decode(data []byte) ([]byte, error)
+}
+
+// codecFor returns the codec for the subprotocol negotiated on conn
+func codecFor(conn *websocket.Conn) frameCodec {
+	if conn == nil {
+		return lineCodec{}
+	}
+
+	switch conn.Subprotocol() {
+	case SubprotocolJSON:
+		return jsonArrayCodec{}
+	case SubprotocolMsgpack:
+		return msgpackCodec{}
+	default:
+		return lineCodec{}
+	}
+}
+
+// lineCodec writes batches as newline-separated JSON, for clients that predate subprotocols
+type lineCodec struct{}
+
+func (lineCodec) messageType() int {
+	return websocket.TextMessage
+}
+
+func (lineCodec) writeBatch(w io.Writer, frames [][]byte) error {
+	for i, frame := range frames {
+		if i > 0 {
+			if _, err := w.Write([]byte{'\n'}); err != nil {
+				return err
+			}
+		}
+		if _, err := w.Write(frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (lineCodec) decode(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+// jsonArrayCodec writes batches as a JSON array
+type jsonArrayCodec struct{}
+
+func (jsonArrayCodec) messageType() int {
+	return websocket.TextMessage
+}
+
+func (jsonArrayCodec) writeBatch(w io.Writer, frames [][]byte) error {
+	if _, err := w.Write([]byte{'['}); err != nil {
+		return err
+	}
+	for i, frame := range frames {
+		if i > 0 {
+			if _, err := w.Write([]byte{','}); err != nil {
+				return err
+			}
+		}
+		if _, err := w.Write(frame); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write([]byte{']'})
+	return err
+}
+
+func (jsonArrayCodec) decode(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+// msgpackHandle configures MessagePack to use the current spec's string and
+// binary types, and to decode maps with string keys so they convert to JSON
+var msgpackHandle = func() *codec.MsgpackHandle {
+	h := &codec.MsgpackHandle{}
+	h.WriteExt = true
+	h.MapType = reflect.TypeOf(map[string]any(nil))
+	return h
+}()
+
+// msgpackCodec writes batches as a MessagePack array
+type msgpackCodec struct{}
+
+func (msgpackCodec) messageType() int {
+	return websocket.BinaryMessage
+}
+
+func (msgpackCodec) writeBatch(w io.Writer, frames [][]byte) error {
+	values := make([]any, len(frames))
+	for i, frame := range frames {
+		value, err := decodeJSON(frame)
+		if err != nil {
+			return err
+		}
+		values[i] = value
+	}
+	return codec.NewEncoder(w, msgpackHandle).Encode(values)
+}
+
+func (msgpackCodec) decode(data []byte) ([]byte, error) {
+	var value any
+	if err := codec.NewDecoderBytes(data, msgpackHandle).Decode(&value); err != nil {
+		return nil, fmt.Errorf("decoding msgpack frame: %w", err)
+	}
+	return json.Marshal(value)
+}
+
+// decodeJSON decodes a JSON frame, keeping whole numbers as integers
+func decodeJSON(frame []byte) (any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(frame))
+	decoder.UseNumber()
+
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return convertNumbers(value), nil
+}
+
+// convertNumbers replaces json.Number values with int64 or float64
+func convertNumbers(value any) any {
+	switch v := value.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]any:
+		for key, item := range v {
+			v[key] = convertNumbers(item)
+		}
+		return v
+	case []any:
+		for i, item := range v {
+			v[i] = convertNumbers(item)
+		}
+		return v
+	default:
+		return value
+	}
+}
@@ -0,0 +1,141 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// historyBurst returns the frames sent to a client that subscribes to a busy room
+func historyBurst(n int) [][]byte {
+	roomID := uuid.NewString()
+	users := []struct{ id, name, avatar string }{
+		{uuid.NewString(), "Alice Johnson", "https://lh3.googleusercontent.com/a/ACg8ocJ1x2y3z4-alice=s96-c"},
+		{uuid.NewString(), "Bob Smith", "https://lh3.googleusercontent.com/a/ACg8ocK5l6m7n8-bob=s96-c"},
+		{uuid.NewString(), "Carol Nguyen", "https://lh3.googleusercontent.com/a/ACg8ocP9q0r1s2-carol=s96-c"},
+	}
+	contents := []string{
+		"Morning! Did anyone look at the deploy logs from last night?",
+		"Yes, the migration ran fine but the cache warmup took longer than expected.",
+		"ok",
+		"I'll open a ticket so we can dig into it after standup.",
+		"Sounds good, thanks 👍",
+	}
+
+	start := time.Date(2026, 5, 4, 9, 0, 0, 0, time.UTC)
+	frames := make([][]byte, n)
+	for i := range frames {
+		user := users[i%len(users)]
+		createdAt := start.Add(time.Duration(i) * 37 * time.Second)
+		frames[i], _ = json.Marshal(map[string]any{
+			"type":        "message",
+			"id":          uuid.NewString(),
+			"room_id":     roomID,
+			"user_id":     user.id,
+			"content":     contents[i%len(contents)],
+			"created_at":  createdAt,
+			"updated_at":  createdAt,
+			"user_name":   user.name,
+			"user_avatar": user.avatar,
+			"history":     true,
+		})
+	}
+	return frames
+}
+
+// countingConn counts bytes read from the network
+type countingConn struct {
+	net.Conn
+	read *atomic.Int64
+}
+
+func (c countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.read.Add(int64(n))
+	return n, err
+}
+
+// dialBench connects a server-side Client to a peer connection, counting the
+// bytes the peer reads after the handshake
+func dialBench(b *testing.B, subprotocol string, compress bool) (*Client, *websocket.Conn, *atomic.Int64) {
+	b.Helper()
+
+	conns := make(chan *websocket.Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{EnableCompression: compress, Subprotocols: Subprotocols}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			b.Errorf("Upgrade: %v", err)
+			return
+		}
+		conns <- conn
+	}))
+	b.Cleanup(srv.Close)
+
+	read := &atomic.Int64{}
+	dialer := websocket.Dialer{
+		EnableCompression: compress,
+		NetDial: func(network, addr string) (net.Conn, error) {
+			conn, err := net.Dial(network, addr)
+			return countingConn{Conn: conn, read: read}, err
+		},
+	}
+	if subprotocol != "" {
+		dialer.Subprotocols = []string{subprotocol}
+	}
+
+	peer, _, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		b.Fatalf("Dial: %v", err)
+	}
+	b.Cleanup(func() { peer.Close() })
+
+	conn := <-conns
+	b.Cleanup(func() { conn.Close() })
+
+	read.Store(0)
+	return NewClient(NewHub(1, DefaultQueuePolicy()), conn, "bench"), peer, read
+}
+
+// BenchmarkHistoryBurst measures the bytes on the wire for the 50 message history
+// burst sent on subscribe, written as one batch, for each subprotocol with and
+// without permessage-deflate.
+//
+//	go test ./pkg/websocket -run '^$' -bench HistoryBurst
+func BenchmarkHistoryBurst(b *testing.B) {
+	frames := historyBurst(50)
+
+	for _, subprotocol := range []string{"", SubprotocolJSON, SubprotocolMsgpack} {
+		for _, compress := range []bool{false, true} {
+			name := subprotocol
+			if name == "" {
+				name = "lines"
+			}
+			b.Run(fmt.Sprintf("%s/compression=%t", name, compress), func(b *testing.B) {
+				client, peer, read := dialBench(b, subprotocol, compress)
+
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if err := client.writeBatch(frames); err != nil {
+						b.Fatalf("writeBatch: %v", err)
+					}
+					if _, _, err := peer.ReadMessage(); err != nil {
+						b.Fatalf("ReadMessage: %v", err)
+					}
+				}
+				b.StopTimer()
+
+				b.ReportMetric(float64(read.Load())/float64(b.N), "wire-bytes/burst")
+			})
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/ugorji/go/codec"
+)
+
+var testBatch = [][]byte{
+	[]byte(`{"type":"message","id":"m1","reconnect_after_ms":1500}`),
+	[]byte(`{"type":"read","message_ids":["m1","m2"],"ratio":0.5,"history":true}`),
+}
+
+func TestLineCodecJoinsFramesWithNewlines(t *testing.T) {
+	var buf bytes.Buffer
+	if err := (lineCodec{}).writeBatch(&buf, testBatch); err != nil {
+		t.Fatalf("writeBatch: %v", err)
+	}
+
+	want := string(testBatch[0]) + "\n" + string(testBatch[1])
+	if buf.String() != want {
+		t.Fatalf("got %s, want %s", buf.String(), want)
+	}
+}
+
+func TestJSONArrayCodecWritesArray(t *testing.T) {
+	var buf bytes.Buffer
+	if err := (jsonArrayCodec{}).writeBatch(&buf, testBatch); err != nil {
+		t.Fatalf("writeBatch: %v", err)
+	}
+
+	var got []map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("batch is not a JSON array: %v", err)
+	}
+	if len(got) != 2 || got[0]["id"] != "m1" || got[1]["type"] != "read" {
+		t.Fatalf("unexpected batch: %v", got)
+	}
+}
+
+func TestMsgpackCodecRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	if err := (msgpackCodec{}).writeBatch(&buf, testBatch); err != nil {
+		t.Fatalf("writeBatch: %v", err)
+	}
+
+	var got []map[string]any
+	if err := codec.NewDecoderBytes(buf.Bytes(), msgpackHandle).Decode(&got); err != nil {
+		t.Fatalf("batch is not a MessagePack array: %v", err)
+	}
+	want := []map[string]any{
+		{"type": "message", "id": "m1", "reconnect_after_ms": int64(1500)},
+		{"type": "read", "message_ids": []any{"m1", "m2"}, "ratio": 0.5, "history": true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+
+	// A frame from the client decodes to the equivalent JSON
+	var frame []byte
+	if err := codec.NewEncoderBytes(&frame, msgpackHandle).Encode(map[string]any{"type": "message", "room_id": "r1"}); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	decoded, err := (msgpackCodec{}).decode(frame)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if string(decoded) != `{"room_id":"r1","type":"message"}` {
+		t.Fatalf("decoded %s", decoded)
+	}
+
+	if _, err := (msgpackCodec{}).decode([]byte{0xc1}); err == nil {
+		t.Fatal("expected an error decoding an invalid frame")
+	}
+}
@@ -1,126 +1,288 @@
 // pkg/websocket/hub.go
 package websocket
 
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"math/rand/v2"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Clients are told to wait a random delay in this range before reconnecting
+// after a shutdown, so they don't all reconnect at once
+const (
+	minReconnectDelay = 1 * time.Second
+	maxReconnectDelay = 5 * time.Second
+)
+
 // Subscription represents a client subscription to a room
 type Subscription struct {
 	Client *Client
 	Room   string
 }
 
-// Hub maintains the set of active clients and broadcasts messages to them
+// goingAwayFrame tells clients the server is shutting down and when to reconnect
+type goingAwayFrame struct {
+	Type             string `json:"type"`
+	ReconnectAfterMs int64  `json:"reconnect_after_ms"`
+}
+
+// Hub maintains the set of active clients and broadcasts messages to them.
+// Rooms are spread across shards, each owned by its own goroutine, so
+// subscriptions and fan-out in one room don't wait on rooms in other shards.
 type Hub struct {
+	// shards own room membership; a room always lives in the same shard
+	shards []*shard
+
+	// mu guards clients, users and stopped
+	mu sync.Mutex
+
 	// Registered clients
-	Clients map[*Client]bool
+	clients map[*Client]bool
+
+	// users holds each user's registered clients, one per device or tab
+	users map[string]map[*Client]bool
+
+	// stopped is set once the hub has disconnected every client
+	stopped bool
+
+	// policy configures each client's send queue
+	policy QueuePolicy
+
+	// metrics counts frames dropped by client queues
+	metrics queueMetrics
+
+	// quit is closed to stop the hub
+	quit     chan struct{}
+	stopOnce sync.Once
+
+	// writers counts the WritePump and Pump loops started before the hub stopped,
+	// so stopping can wait for them to flush the going away frame
+	writers sync.WaitGroup
+
+	// done is closed once the hub has stopped, disconnected every client and
+	// waited for their writers to exit
+	done chan struct{}
+}
+
+// NewHub creates a new Hub with the given number of shards, whose clients' send
+// queues follow policy. If shards is not positive, one shard per CPU is used.
+func NewHub(shards int, policy QueuePolicy) *Hub {
+	if shards <= 0 {
+		shards = runtime.GOMAXPROCS(0)
+	}
+
+	h := &Hub{
+		clients: make(map[*Client]bool),
+		users:   make(map[string]map[*Client]bool),
+		policy:  policy,
+		quit:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	h.shards = make([]*shard, shards)
+	for i := range h.shards {
+		h.shards[i] = newShard(h)
+	}
+
+	return h
+}
+
+// shardFor returns the shard that owns a room
+func (h *Hub) shardFor(room string) *shard {
+	if len(h.shards) == 1 {
+		return h.shards[0]
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(room))
+	return h.shards[hash.Sum32()%uint32(len(h.shards))]
+}
+
+// Register adds a client to the hub. If the hub has stopped, the client is
+// closed with a going away status instead.
+func (h *Hub) Register(client *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.stopped {
+		client.Close(websocket.CloseGoingAway)
+		return
+	}
+	h.clients[client] = true
+	if h.users[client.ID] == nil {
+		h.users[client.ID] = make(map[*Client]bool)
+	}
+	h.users[client.ID][client] = true
+}
+
+// Unregister removes a client from the hub and all of its rooms, and closes its
+// connection. It returns the rooms the client was its user's last client in.
+// Unregistering a client more than once is safe.
+func (h *Hub) Unregister(client *Client) []string {
+	h.mu.Lock()
+	h.removeClient(client)
+	h.mu.Unlock()
+
+	// Closing the queue first makes shards ignore any subscription still in flight
+	client.Close(websocket.CloseNormalClosure)
+
+	var left []string
+	for _, room := range client.RoomIDs() {
+		if h.Unsubscribe(&Subscription{Client: client, Room: room}) {
+			left = append(left, room)
+		}
+	}
+	return left
+}
 
-	// Registered clients by room
-	Rooms map[string]map[*Client]bool
+// removeClient forgets a registered client. h.mu must be held.
+func (h *Hub) removeClient(client *Client) {
+	delete(h.clients, client)
+	delete(h.users[client.ID], client)
+	if len(h.users[client.ID]) == 0 {
+		delete(h.users, client.ID)
+	}
+}
 
-	// Register requests from clients
-	Register chan *Client
+// UserClients returns a user's registered clients
+func (h *Hub) UserClients(userID string) []*Client {
+	h.mu.Lock()
+	defer h.mu.Unlock()
 
-	// Unregister requests from clients
-	Unregister chan *Client
+	clients := make([]*Client, 0, len(h.users[userID]))
+	for client := range h.users[userID] {
+		clients = append(clients, client)
+	}
+	return clients
+}
 
-	// Subscribe clients to rooms
-	Subscribe chan *Subscription
+// SendToUser queues data on every one of a user's clients except except, which may be nil
+func (h *Hub) SendToUser(userID string, data []byte, except *Client) {
+	for _, client := range h.UserClients(userID) {
+		if client != except {
+			client.Send(data)
+		}
+	}
+}
 
-	// Unsubscribe clients from rooms
-	Unsubscribe chan *Subscription
+// Subscribe adds a client to a room. It returns once the client is in the room,
+// so a following IsInRoom call sees the subscription. It reports whether the
+// client is the first of its user's clients in the room.
+func (h *Hub) Subscribe(subscription *Subscription) bool {
+	s := h.shardFor(subscription.Room)
+	return s.apply(s.subscribe, subscription)
+}
 
-	// Inbound messages from clients
-	Broadcast chan *Message
+// Unsubscribe removes a client from a room. It returns once the client has left
+// the room, and reports whether it was the last of its user's clients there.
+func (h *Hub) Unsubscribe(subscription *Subscription) bool {
+	s := h.shardFor(subscription.Room)
+	return s.apply(s.unsubscribe, subscription)
 }
 
-// NewHub creates a new Hub
-func NewHub() *Hub {
-	return &Hub{
-		Clients:     make(map[*Client]bool),
-		Rooms:       make(map[string]map[*Client]bool),
-		Register:    make(chan *Client),
-		Unregister:  make(chan *Client),
-		Subscribe:   make(chan *Subscription),
-		Unsubscribe: make(chan *Subscription),
-		Broadcast:   make(chan *Message),
+// Broadcast sends a message to every client in its room except the sender
+func (h *Hub) Broadcast(message *Message) {
+	// For messages with a specific room, broadcast to that room only
+	if message.RoomID == "" {
+		return
+	}
+
+	select {
+	case h.shardFor(message.RoomID).broadcast <- message:
+	case <-h.quit:
 	}
 }
 
-// Run starts the hub
+// Metrics returns counts of frames dropped by client queues
+func (h *Hub) Metrics() QueueMetrics {
+	return h.metrics.snapshot()
+}
+
+// Done returns a channel that is closed once the hub has stopped
+func (h *Hub) Done() <-chan struct{} {
+	return h.done
+}
+
+// Stop stops the hub, telling every client the server is going away and closing
+// their connections. It waits for every client's writer to flush and exit, or
+// for ctx to be done.
+func (h *Hub) Stop(ctx context.Context) error {
+	h.stopOnce.Do(func() {
+		close(h.quit)
+	})
+
+	select {
+	case <-h.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Run starts the hub's shards. It returns once the hub is stopped and every
+// client's writer has exited.
 func (h *Hub) Run() {
-	for {
-		select {
-		case client := <-h.Register:
-			// Register new client
-			h.Clients[client] = true
-
-		case client := <-h.Unregister:
-			// Unregister client from all rooms
-			if _, ok := h.Clients[client]; ok {
-				delete(h.Clients, client)
-
-				// Remove from all rooms
-				for room := range client.Rooms {
-					if _, ok := h.Rooms[room]; ok {
-						delete(h.Rooms[room], client)
-
-						// If room is empty, delete it
-						if len(h.Rooms[room]) == 0 {
-							delete(h.Rooms, room)
-						}
-					}
-				}
-
-				close(client.Send)
-			}
-
-		case subscription := <-h.Subscribe:
-			// Create room if it doesn't exist
-			if _, ok := h.Rooms[subscription.Room]; !ok {
-				h.Rooms[subscription.Room] = make(map[*Client]bool)
-			}
-
-			// Add client to room
-			h.Rooms[subscription.Room][subscription.Client] = true
-
-			// Update client's room list
-			subscription.Client.JoinRoom(subscription.Room)
-
-		case unsubscription := <-h.Unsubscribe:
-			// Remove client from room
-			if _, ok := h.Rooms[unsubscription.Room]; ok {
-				delete(h.Rooms[unsubscription.Room], unsubscription.Client)
-
-				// If room is empty, delete it
-				if len(h.Rooms[unsubscription.Room]) == 0 {
-					delete(h.Rooms, unsubscription.Room)
-				}
-
-				// Update client's room list
-				unsubscription.Client.LeaveRoom(unsubscription.Room)
-			}
-
-		case message := <-h.Broadcast:
-			// For messages with a specific room, broadcast to that room only
-			if message.RoomID != "" {
-				if _, ok := h.Rooms[message.RoomID]; ok {
-					for client := range h.Rooms[message.RoomID] {
-						// Don't send message back to sender
-						if client != message.Client {
-							select {
-							case client.Send <- message.Data:
-							default:
-								close(client.Send)
-								delete(h.Rooms[message.RoomID], client)
-								delete(h.Clients, client)
-
-								// If room is empty, delete it
-								if len(h.Rooms[message.RoomID]) == 0 {
-									delete(h.Rooms, message.RoomID)
-								}
-							}
-						}
-					}
-				}
-			}
+	defer close(h.done)
+
+	var wg sync.WaitGroup
+	for _, s := range h.shards {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.run()
+		}()
+	}
+
+	<-h.quit
+	wg.Wait()
+	h.disconnectAll()
+	h.writers.Wait()
+}
+
+// startWriter records that a client's writer is running, reporting whether it
+// was counted. Writers started after the hub stopped aren't waited for.
+func (h *Hub) startWriter() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.stopped {
+		return false
+	}
+	h.writers.Add(1)
+	return true
+}
+
+// disconnectAll sends every client a server_going_away frame with a reconnect
+// hint, then closes its connection with a going away status
+func (h *Hub) disconnectAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.stopped = true
+
+	for client := range h.clients {
+		frame, _ := json.Marshal(goingAwayFrame{
+			Type:             "server_going_away",
+			ReconnectAfterMs: reconnectDelay().Milliseconds(),
+		})
+
+		client.Send(frame)
+		client.Close(websocket.CloseGoingAway)
+		h.removeClient(client)
+
+		// The shards have stopped, so their rooms can be changed directly
+		for _, room := range client.RoomIDs() {
+			h.shardFor(room).removeFromRoom(client, room)
 		}
 	}
 }
+
+// reconnectDelay picks a random delay for clients to wait before reconnecting
+func reconnectDelay() time.Duration {
+	return minReconnectDelay + rand.N(maxReconnectDelay-minReconnectDelay)
+}
@@ -0,0 +1,121 @@
+package websocket
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Benchmark fan-out with 10k clients spread across 1k rooms. Each client is in
+// roomsPerClient rooms, and every client's queue is drained by its own goroutine
+// as WritePump would.
+const (
+	benchClients        = 10000
+	benchRooms          = 1000
+	benchRoomsPerClient = 3
+)
+
+func BenchmarkHubBroadcast(b *testing.B) {
+	for _, shards := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			benchmarkHubBroadcast(b, shards)
+		})
+	}
+}
+
+func benchmarkHubBroadcast(b *testing.B, shards int) {
+	hub := NewHub(shards, QueuePolicy{Size: 1024, OverflowTimeout: time.Minute})
+	go hub.Run()
+	defer stopBenchHub(b, hub)
+
+	var delivered atomic.Int64
+	var drainers sync.WaitGroup
+
+	clients := make([]*Client, benchClients)
+	members := make([]int64, benchRooms)
+	for i := range clients {
+		client := NewClient(hub, nil, fmt.Sprintf("client-%d", i))
+		hub.Register(client)
+		for r := 0; r < benchRoomsPerClient; r++ {
+			room := i*benchRoomsPerClient + r
+			hub.Subscribe(&Subscription{Client: client, Room: benchRoom(room)})
+			members[room%benchRooms]++
+		}
+		clients[i] = client
+
+		drainers.Add(1)
+		go func() {
+			defer drainers.Done()
+			for range client.queue.ready {
+				frames, closed, _ := client.queue.drain()
+				delivered.Add(int64(len(frames)))
+				if closed {
+					return
+				}
+			}
+		}()
+	}
+
+	data := []byte(`{"type":"message","content":"hello"}`)
+	var next, expected atomic.Int64
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := int(next.Add(1))
+			sender := clients[i%benchClients]
+			room := benchRoom(i)
+
+			recipients := members[i%benchRooms]
+			if sender.IsInRoom(room) {
+				recipients--
+			}
+			expected.Add(recipients)
+
+			hub.Broadcast(&Message{
+				RoomID: room,
+				Data:   data,
+				Client: sender,
+			})
+		}
+	})
+
+	// Wait for every broadcast to be delivered before stopping the clock
+	for delivered.Load() < expected.Load() {
+		runtime.Gosched()
+	}
+
+	elapsed := time.Since(start)
+	b.StopTimer()
+
+	for _, client := range clients {
+		hub.Unregister(client)
+	}
+	drainers.Wait()
+
+	b.ReportMetric(float64(b.N)/elapsed.Seconds(), "broadcasts/s")
+	b.ReportMetric(float64(delivered.Load())/elapsed.Seconds(), "deliveries/s")
+
+	if metrics := hub.Metrics(); metrics.OverflowDisconnects > 0 {
+		b.Logf("disconnected %d clients for overflowing", metrics.OverflowDisconnects)
+	}
+}
+
+// benchRoom returns the name of the i-th room, wrapping at benchRooms
+func benchRoom(i int) string {
+	return fmt.Sprintf("room-%d", i%benchRooms)
+}
+
+// stopBenchHub stops the hub at the end of a benchmark
+func stopBenchHub(b *testing.B, hub *Hub) {
+	b.Helper()
+	if err := hub.Stop(b.Context()); err != nil {
+		b.Fatalf("Stop: %v", err)
+	}
+}
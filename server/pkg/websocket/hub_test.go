@@ -0,0 +1,334 @@
+package websocket
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestHub starts a hub that is stopped when the test ends
+func newTestHub(t *testing.T, policy QueuePolicy) *Hub {
+	t.Helper()
+	hub := NewHub(4, policy)
+	go hub.Run()
+	t.Cleanup(func() {
+		hub.Stop(context.Background())
+	})
+	return hub
+}
+
+// stopHub stops the hub so its state can be inspected without racing Run
+func stopHub(t *testing.T, hub *Hub) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := hub.Stop(ctx); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+}
+
+// eventually waits for cond to hold, failing the test if it doesn't within a second
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// queued returns the frames waiting in a client's queue
+func queued(c *Client) []string {
+	frames, _, _ := c.queue.drain()
+	result := make([]string, len(frames))
+	for i, frame := range frames {
+		result[i] = string(frame)
+	}
+	return result
+}
+
+func TestHubBroadcastSkipsSender(t *testing.T) {
+	hub := newTestHub(t, DefaultQueuePolicy())
+	alice := NewClient(hub, nil, "alice")
+	bob := NewClient(hub, nil, "bob")
+	carol := NewClient(hub, nil, "carol")
+
+	for _, client := range []*Client{alice, bob, carol} {
+		hub.Register(client)
+	}
+	hub.Subscribe(&Subscription{Client: alice, Room: "general"})
+	hub.Subscribe(&Subscription{Client: bob, Room: "general"})
+	hub.Subscribe(&Subscription{Client: carol, Room: "random"})
+
+	if !alice.IsInRoom("general") || alice.IsInRoom("random") {
+		t.Fatalf("unexpected rooms for alice: %v", alice.RoomIDs())
+	}
+
+	hub.Broadcast(&Message{RoomID: "general", Data: []byte("hello"), Client: alice})
+	stopHub(t, hub)
+
+	// Stopping the hub queues a going away frame after everything broadcast before it
+	if got := queued(bob); len(got) != 2 || got[0] != "hello" {
+		t.Fatalf("expected bob to receive the broadcast, got %v", got)
+	}
+	if got := queued(alice); len(got) != 1 {
+		t.Fatalf("expected alice not to receive her own broadcast, got %v", got)
+	}
+	if got := queued(carol); len(got) != 1 {
+		t.Fatalf("expected carol not to receive another room's broadcast, got %v", got)
+	}
+}
+
+func TestHubBroadcastExceptUserSkipsSendersDevices(t *testing.T) {
+	hub := newTestHub(t, DefaultQueuePolicy())
+	laptop := NewClient(hub, nil, "alice")
+	phone := NewClient(hub, nil, "alice")
+	bob := NewClient(hub, nil, "bob")
+
+	for _, client := range []*Client{laptop, phone, bob} {
+		hub.Register(client)
+		hub.Subscribe(&Subscription{Client: client, Room: "general"})
+	}
+
+	hub.Broadcast(&Message{RoomID: "general", Data: []byte("typing"), Client: laptop, ExceptUser: true})
+	hub.Broadcast(&Message{RoomID: "general", Data: []byte("hello"), Client: laptop})
+	stopHub(t, hub)
+
+	// Stopping the hub queues a going away frame after everything broadcast before it
+	if got := queued(phone); len(got) != 2 || got[0] != "hello" {
+		t.Fatalf("expected the sender's other device to get only hello, got %v", got)
+	}
+	if got := queued(bob); len(got) != 3 || got[0] != "typing" || got[1] != "hello" {
+		t.Fatalf("expected bob to get typing then hello, got %v", got)
+	}
+}
+
+func TestHubOverflowClosesClientUntilUnregistered(t *testing.T) {
+	hub := newTestHub(t, QueuePolicy{Size: 1, OverflowTimeout: 0})
+	sender := NewClient(hub, nil, "sender")
+	slow := NewClient(hub, nil, "slow")
+
+	hub.Register(sender)
+	hub.Register(slow)
+	for _, room := range []string{"a", "b", "c"} {
+		hub.Subscribe(&Subscription{Client: sender, Room: room})
+		hub.Subscribe(&Subscription{Client: slow, Room: room})
+	}
+
+	// The first frame fills the queue and the second overflows it
+	hub.Broadcast(&Message{RoomID: "a", Data: []byte("1"), Client: sender})
+	hub.Broadcast(&Message{RoomID: "a", Data: []byte("2"), Client: sender})
+	eventually(t, func() bool { return hub.Metrics().OverflowDisconnects == 1 })
+
+	// Broadcasting to the closed client's other rooms must not panic
+	hub.Broadcast(&Message{RoomID: "b", Data: []byte("3"), Client: sender})
+
+	// The client keeps its rooms until its handler unregisters it, which reports
+	// every room it left
+	left := hub.Unregister(slow)
+	sort.Strings(left)
+	if !slices.Equal(left, []string{"a", "b", "c"}) {
+		t.Fatalf("Unregister left %v, want [a b c]", left)
+	}
+	if left := hub.Unregister(slow); len(left) != 0 {
+		t.Fatalf("unregistering again left %v, want none", left)
+	}
+
+	stopHub(t, hub)
+
+	for _, s := range hub.shards {
+		for room, clients := range s.rooms {
+			if clients[slow] {
+				t.Fatalf("slow client is still in room %s", room)
+			}
+		}
+	}
+	if _, closed, _ := slow.queue.drain(); !closed {
+		t.Fatal("expected the slow client's queue to be closed")
+	}
+}
+
+func TestHubClosedClientLeavesRoomsOnUnregister(t *testing.T) {
+	hub := newTestHub(t, DefaultQueuePolicy())
+	alice := NewClient(hub, nil, "alice")
+	bob := NewClient(hub, nil, "bob")
+	for _, client := range []*Client{alice, bob} {
+		hub.Register(client)
+		hub.Subscribe(&Subscription{Client: client, Room: "room"})
+	}
+
+	// A client closed by logout or token expiry gets no more frames, but stays in
+	// the room so that unregistering it reports the user left
+	bob.Close(4401)
+	hub.Broadcast(&Message{RoomID: "room", Data: []byte("hello"), Client: alice})
+	hub.Broadcast(&Message{RoomID: "room", Data: []byte("again"), Client: alice})
+
+	if left := hub.Unregister(bob); len(left) != 1 || left[0] != "room" {
+		t.Fatalf("Unregister left %v, want [room]", left)
+	}
+	if bob.IsInRoom("room") {
+		t.Fatal("expected bob to have left the room")
+	}
+}
+
+func TestHubConcurrentSubscribeBroadcastUnregister(t *testing.T) {
+	const (
+		clients = 50
+		rooms   = 5
+		rounds  = 20
+	)
+
+	hub := newTestHub(t, QueuePolicy{Size: 8, OverflowTimeout: time.Millisecond})
+
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			client := NewClient(hub, nil, fmt.Sprintf("client-%d", i))
+			hub.Register(client)
+
+			// Drain the queue concurrently, as WritePump would
+			drained := make(chan struct{})
+			go func() {
+				defer close(drained)
+				for range client.queue.ready {
+					if _, closed, _ := client.queue.drain(); closed {
+						return
+					}
+				}
+			}()
+
+			for r := 0; r < rounds; r++ {
+				room := fmt.Sprintf("room-%d", (i+r)%rooms)
+				hub.Subscribe(&Subscription{Client: client, Room: room})
+				client.IsInRoom(room)
+				hub.Broadcast(&Message{RoomID: room, Data: []byte("message"), Client: client})
+				hub.Broadcast(&Message{RoomID: room, Data: []byte("typing"), Client: client, Ephemeral: true})
+				client.RoomIDs()
+				if r%3 == 0 {
+					hub.Unsubscribe(&Subscription{Client: client, Room: room})
+				}
+			}
+
+			hub.Unregister(client)
+			<-drained
+
+			if rooms := client.RoomIDs(); len(rooms) != 0 {
+				t.Errorf("client %d is still in rooms %v after unregistering", i, rooms)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	stopHub(t, hub)
+
+	if len(hub.clients) != 0 {
+		t.Fatalf("expected no clients left, got %d", len(hub.clients))
+	}
+	for _, s := range hub.shards {
+		if len(s.rooms) != 0 {
+			t.Fatalf("expected no rooms left, got %d in a shard", len(s.rooms))
+		}
+	}
+}
+
+func TestHubTracksUsersAcrossClients(t *testing.T) {
+	hub := newTestHub(t, DefaultQueuePolicy())
+	phone := NewClient(hub, nil, "alice")
+	laptop := NewClient(hub, nil, "alice")
+	bob := NewClient(hub, nil, "bob")
+	for _, client := range []*Client{phone, laptop, bob} {
+		hub.Register(client)
+	}
+
+	if got := len(hub.UserClients("alice")); got != 2 {
+		t.Fatalf("alice has %d clients, want 2", got)
+	}
+
+	if !hub.Subscribe(&Subscription{Client: phone, Room: "room"}) {
+		t.Error("phone should be alice's first client in the room")
+	}
+	if hub.Subscribe(&Subscription{Client: phone, Room: "room"}) {
+		t.Error("subscribing twice should not count as joining")
+	}
+	if hub.Subscribe(&Subscription{Client: laptop, Room: "room"}) {
+		t.Error("laptop should not be alice's first client in the room")
+	}
+	if !hub.Subscribe(&Subscription{Client: bob, Room: "room"}) {
+		t.Error("bob should be his own first client in the room")
+	}
+
+	if hub.Unsubscribe(&Subscription{Client: phone, Room: "room"}) {
+		t.Error("phone should not be alice's last client in the room")
+	}
+	if hub.Unsubscribe(&Subscription{Client: phone, Room: "room"}) {
+		t.Error("unsubscribing twice should not count as leaving")
+	}
+
+	hub.SendToUser("alice", []byte("sync"), phone)
+	if got := queued(laptop); len(got) != 1 || got[0] != "sync" {
+		t.Errorf("laptop got %v, want the sync frame", got)
+	}
+	if got := queued(phone); len(got) != 0 {
+		t.Errorf("phone got %v, want nothing", got)
+	}
+
+	if left := hub.Unregister(laptop); len(left) != 1 || left[0] != "room" {
+		t.Errorf("Unregister(laptop) left %v, want [room]", left)
+	}
+	if left := hub.Unregister(phone); len(left) != 0 {
+		t.Errorf("Unregister(phone) left %v, want none", left)
+	}
+	if got := len(hub.UserClients("alice")); got != 0 {
+		t.Errorf("alice has %d clients after disconnecting, want 0", got)
+	}
+}
+
+func TestHubStopWaitsForWriters(t *testing.T) {
+	hub := newTestHub(t, DefaultQueuePolicy())
+	client := NewClient(hub, nil, "alice")
+	hub.Register(client)
+
+	// The writer is stuck writing a frame when the hub is stopped
+	writing, release := make(chan struct{}, 1), make(chan struct{})
+	var mu sync.Mutex
+	var written []string
+	go client.Pump(context.Background(), time.Hour, func(frames [][]byte) error {
+		writing <- struct{}{}
+		<-release
+		mu.Lock()
+		defer mu.Unlock()
+		for _, frame := range frames {
+			written = append(written, string(frame))
+		}
+		return nil
+	}, func() error { return nil })
+
+	client.Send([]byte("hello"))
+	<-writing
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := hub.Stop(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected Stop to wait for the writer, got %v", err)
+	}
+
+	close(release)
+	stopHub(t, hub)
+
+	// Once Stop returns, the going away frame has been written
+	mu.Lock()
+	defer mu.Unlock()
+	if len(written) != 2 || written[0] != "hello" || !strings.Contains(written[1], "server_going_away") {
+		t.Fatalf("expected hello then server_going_away, got %v", written)
+	}
+}
@@ -19,6 +19,12 @@ type Message struct {
 	Action string          `json:"action,omitempty"` // For system messages (joined, left)
 	Data   json.RawMessage `json:"data,omitempty"`   // Additional data specific to message type
 
+	// Ephemeral frames such as typing and presence are dropped first when a client falls behind
+	Ephemeral bool `json:"-"`
+
+	// ExceptUser skips all of the sending client's user's clients, not just the sender
+	ExceptUser bool `json:"-"`
+
 	// Reference to the client (not serialized)
 	Client *Client `json:"-"` // Not sent over the wire
 }
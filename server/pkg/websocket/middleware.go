@@ -0,0 +1,183 @@
+// pkg/websocket/middleware.go
+package websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrMissingRoomID is returned when a frame that targets a room has no room_id
+	ErrMissingRoomID = errors.New("missing room_id")
+
+	// ErrNotSubscribed is returned when a client acts on a room it isn't subscribed to
+	ErrNotSubscribed = errors.New("not subscribed to room")
+
+	// ErrRateLimited is returned when a client sends frames faster than allowed
+	ErrRateLimited = errors.New("rate limited")
+)
+
+// Recovery turns a panic in a handler into an error, so one bad frame doesn't
+// take down the connection. Add it after logging and metrics middleware so that
+// panics are logged and counted as failures.
+func Recovery() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(req *Request) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("Recovered from panic handling %q frame from client %s: %v\n%s", req.Type, req.Client.ID, r, debug.Stack())
+					err = fmt.Errorf("panic handling %q frame: %v", req.Type, r)
+				}
+			}()
+			return next(req)
+		}
+	}
+}
+
+// Logging logs every frame with how long it took to handle and any error
+func Logging() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(req *Request) error {
+			start := time.Now()
+			err := next(req)
+
+			if err != nil {
+				log.Printf("Client %s %q frame for room %q failed after %s: %v", req.Client.ID, req.Type, req.RoomID, time.Since(start), err)
+			} else {
+				log.Printf("Client %s %q frame for room %q handled in %s", req.Client.ID, req.Type, req.RoomID, time.Since(start))
+			}
+			return err
+		}
+	}
+}
+
+// RequireRoomID rejects frames without a room_id
+func RequireRoomID() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(req *Request) error {
+			if req.RoomID == "" {
+				return ErrMissingRoomID
+			}
+			return next(req)
+		}
+	}
+}
+
+// RequireSubscription rejects frames for rooms the client isn't subscribed to
+func RequireSubscription() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(req *Request) error {
+			if req.RoomID == "" {
+				return ErrMissingRoomID
+			}
+			if !req.Client.IsInRoom(req.RoomID) {
+				return ErrNotSubscribed
+			}
+			return next(req)
+		}
+	}
+}
+
+// Timeout bounds how long a handler's queries may take. The handler must not use
+// req.Ctx after it returns.
+func Timeout(d time.Duration) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(req *Request) error {
+			ctx, cancel := context.WithTimeout(req.Ctx, d)
+			defer cancel()
+
+			timed := *req
+			timed.Ctx = ctx
+			return next(&timed)
+		}
+	}
+}
+
+// RateLimitError is returned when a frame is rate limited. It matches ErrRateLimited.
+type RateLimitError struct {
+	// RetryAfter is how long until the client may send the frame again
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limited, retry after %s", e.RetryAfter)
+}
+
+// Is makes RateLimitError match ErrRateLimited
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// RateLimit rejects frames when allow returns false, with a RateLimitError
+// carrying the retry delay allow returned
+func RateLimit(allow func(req *Request) (bool, time.Duration)) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(req *Request) error {
+			if ok, retryAfter := allow(req); !ok {
+				return &RateLimitError{RetryAfter: retryAfter}
+			}
+			return next(req)
+		}
+	}
+}
+
+// FrameStats counts frames of one type
+type FrameStats struct {
+	Handled uint64 `json:"handled"`
+	Failed  uint64 `json:"failed"`
+}
+
+// frameCounters is the live, concurrently updated form of FrameStats
+type frameCounters struct {
+	handled atomic.Uint64
+	failed  atomic.Uint64
+}
+
+// FrameMetrics counts frames handled by a router, by frame type
+type FrameMetrics struct {
+	counters sync.Map // frame type -> *frameCounters
+}
+
+// Middleware returns middleware that records every frame in m
+func (m *FrameMetrics) Middleware() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(req *Request) error {
+			err := next(req)
+
+			// Unknown frame types are counted together so clients can't grow the map
+			frameType := req.Type
+			if errors.Is(err, ErrUnknownFrameType) {
+				frameType = "unknown"
+			}
+
+			value, _ := m.counters.LoadOrStore(frameType, &frameCounters{})
+			counters := value.(*frameCounters)
+			if err != nil {
+				counters.failed.Add(1)
+			} else {
+				counters.handled.Add(1)
+			}
+			return err
+		}
+	}
+}
+
+// Snapshot returns the current counts by frame type
+func (m *FrameMetrics) Snapshot() map[string]FrameStats {
+	result := make(map[string]FrameStats)
+	m.counters.Range(func(key, value any) bool {
+		counters := value.(*frameCounters)
+		result[key.(string)] = FrameStats{
+			Handled: counters.handled.Load(),
+			Failed:  counters.failed.Load(),
+		}
+		return true
+	})
+	return result
+}
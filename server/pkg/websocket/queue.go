@@ -0,0 +1,201 @@
+// pkg/websocket/queue.go
+package websocket
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// QueuePolicy controls how a client's outbound queue handles a slow consumer
+type QueuePolicy struct {
+	// Size is the number of frames a client's queue holds before it starts
+	// dropping ephemeral frames. Other frames are never dropped, so the queue
+	// grows past Size while it overflows.
+	Size int
+
+	// OverflowTimeout is how long a client's queue may stay over Size with frames
+	// that can't be dropped before the client is disconnected
+	OverflowTimeout time.Duration
+}
+
+// DefaultQueuePolicy returns the queue policy used when none is configured
+func DefaultQueuePolicy() QueuePolicy {
+	return QueuePolicy{
+		Size:            256,
+		OverflowTimeout: 5 * time.Second,
+	}
+}
+
+// QueueMetrics counts frames dropped by client queues
+type QueueMetrics struct {
+	// DroppedEphemeral is the number of typing and presence frames dropped
+	DroppedEphemeral uint64 `json:"dropped_ephemeral"`
+
+	// OverflowDisconnects is the number of clients disconnected for sustained overflow
+	OverflowDisconnects uint64 `json:"overflow_disconnects"`
+}
+
+// queueMetrics is the live, concurrently updated form of QueueMetrics
+type queueMetrics struct {
+	droppedEphemeral    atomic.Uint64
+	overflowDisconnects atomic.Uint64
+}
+
+// snapshot returns the current counts
+func (m *queueMetrics) snapshot() QueueMetrics {
+	return QueueMetrics{
+		DroppedEphemeral:    m.droppedEphemeral.Load(),
+		OverflowDisconnects: m.overflowDisconnects.Load(),
+	}
+}
+
+// pushResult reports what happened to a frame pushed onto a queue
+type pushResult int
+
+const (
+	// pushQueued means the frame was queued, possibly by dropping an older ephemeral frame
+	pushQueued pushResult = iota
+
+	// pushDropped means the frame was ephemeral and dropped
+	pushDropped
+
+	// pushOverflow means the queue has been full for too long and was closed
+	pushOverflow
+
+	// pushClosed means the queue was already closed
+	pushClosed
+)
+
+// queuedFrame is a frame waiting to be written
+type queuedFrame struct {
+	data      []byte
+	ephemeral bool
+}
+
+// sendQueue is a bounded queue of outbound frames for one client. When full,
+// the oldest ephemeral frames (typing, presence) are dropped to make room. Other
+// frames are never dropped: the queue grows past its size instead, and if it
+// stays over its size for longer than the policy allows, it is closed.
+type sendQueue struct {
+	policy  QueuePolicy
+	metrics *queueMetrics
+
+	mu     sync.Mutex
+	frames []queuedFrame
+
+	// overflowSince is when the queue last went over its size, or zero if it's
+	// under its size
+	overflowSince time.Time
+	closed        bool
+	closeCode     int
+
+	// ready receives a value whenever frames are queued or the queue is closed
+	ready chan struct{}
+}
+
+// newSendQueue creates an empty queue
+func newSendQueue(policy QueuePolicy, metrics *queueMetrics) *sendQueue {
+	return &sendQueue{
+		policy:  policy,
+		metrics: metrics,
+		frames:  make([]queuedFrame, 0, policy.Size),
+		ready:   make(chan struct{}, 1),
+	}
+}
+
+// push adds a frame to the queue according to the policy
+func (q *sendQueue) push(data []byte, ephemeral bool) pushResult {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return pushClosed
+	}
+
+	if len(q.frames) < q.policy.Size {
+		q.overflowSince = time.Time{}
+	} else if !q.dropOldestEphemeral() {
+		if ephemeral {
+			q.metrics.droppedEphemeral.Add(1)
+			return pushDropped
+		}
+
+		// The queue is full of frames that can't be dropped, so it grows until
+		// the client catches up or has been behind for too long
+		now := time.Now()
+		if q.overflowSince.IsZero() {
+			q.overflowSince = now
+		}
+		if now.Sub(q.overflowSince) >= q.policy.OverflowTimeout {
+			q.metrics.overflowDisconnects.Add(1)
+			q.closeLocked(websocket.CloseTryAgainLater)
+			return pushOverflow
+		}
+	}
+
+	q.frames = append(q.frames, queuedFrame{data: data, ephemeral: ephemeral})
+	q.signal()
+	return pushQueued
+}
+
+// dropOldestEphemeral removes the oldest ephemeral frame, reporting whether there was one
+func (q *sendQueue) dropOldestEphemeral() bool {
+	for i, frame := range q.frames {
+		if frame.ephemeral {
+			q.frames = append(q.frames[:i], q.frames[i+1:]...)
+			q.metrics.droppedEphemeral.Add(1)
+			return true
+		}
+	}
+	return false
+}
+
+// drain removes and returns every queued frame. closed reports whether the queue
+// has been closed, in which case closeCode is the status to close the connection with.
+func (q *sendQueue) drain() (frames [][]byte, closed bool, closeCode int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	frames = make([][]byte, len(q.frames))
+	for i, frame := range q.frames {
+		frames[i] = frame.data
+	}
+	q.frames = q.frames[:0]
+
+	return frames, q.closed, q.closeCode
+}
+
+// isClosed reports whether the queue has been closed
+func (q *sendQueue) isClosed() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.closed
+}
+
+// close closes the queue so that the connection is closed with code once
+// already queued frames are written. Closing an already closed queue does nothing.
+func (q *sendQueue) close(code int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closeLocked(code)
+}
+
+func (q *sendQueue) closeLocked(code int) {
+	if q.closed {
+		return
+	}
+	q.closed = true
+	q.closeCode = code
+	q.signal()
+}
+
+// signal wakes the writer without blocking
+func (q *sendQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
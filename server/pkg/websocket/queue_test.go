@@ -0,0 +1,106 @@
+package websocket
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestSendQueueDropsOldestEphemeralFirst(t *testing.T) {
+	metrics := &queueMetrics{}
+	q := newSendQueue(QueuePolicy{Size: 3, OverflowTimeout: time.Minute}, metrics)
+
+	q.push([]byte("typing-1"), true)
+	q.push([]byte("message-1"), false)
+	q.push([]byte("typing-2"), true)
+
+	if result := q.push([]byte("message-2"), false); result != pushQueued {
+		t.Fatalf("expected message to be queued, got %v", result)
+	}
+
+	frames, _, _ := q.drain()
+	want := []string{"message-1", "typing-2", "message-2"}
+	if len(frames) != len(want) {
+		t.Fatalf("expected %d frames, got %d", len(want), len(frames))
+	}
+	for i, frame := range frames {
+		if string(frame) != want[i] {
+			t.Fatalf("frame %d: expected %s, got %s", i, want[i], frame)
+		}
+	}
+
+	if got := metrics.snapshot().DroppedEphemeral; got != 1 {
+		t.Fatalf("expected 1 dropped ephemeral frame, got %d", got)
+	}
+}
+
+func TestSendQueueDisconnectsOnSustainedOverflow(t *testing.T) {
+	metrics := &queueMetrics{}
+	q := newSendQueue(QueuePolicy{Size: 1, OverflowTimeout: 20 * time.Millisecond}, metrics)
+
+	q.push([]byte("message-1"), false)
+	if result := q.push([]byte("typing"), true); result != pushDropped {
+		t.Fatalf("expected ephemeral frame to be dropped, got %v", result)
+	}
+	if result := q.push([]byte("message-2"), false); result != pushQueued {
+		t.Fatalf("expected message to be kept during the grace period, got %v", result)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if result := q.push([]byte("message-3"), false); result != pushOverflow {
+		t.Fatalf("expected overflow after the grace period, got %v", result)
+	}
+	if result := q.push([]byte("message-4"), false); result != pushClosed {
+		t.Fatalf("expected closed queue, got %v", result)
+	}
+
+	frames, closed, code := q.drain()
+	if len(frames) != 2 || !closed || code != websocket.CloseTryAgainLater {
+		t.Fatalf("expected two frames and close code %d, got %d frames, closed=%v, code=%d", websocket.CloseTryAgainLater, len(frames), closed, code)
+	}
+
+	got := metrics.snapshot()
+	if got.DroppedEphemeral != 1 || got.OverflowDisconnects != 1 {
+		t.Fatalf("unexpected metrics: %+v", got)
+	}
+}
+
+func TestSendQueueKeepsMessagesWhileCatchingUp(t *testing.T) {
+	q := newSendQueue(QueuePolicy{Size: 1, OverflowTimeout: 20 * time.Millisecond}, &queueMetrics{})
+
+	// The client falls behind again and again, but catches up within the grace
+	// period each time, so it loses nothing and stays connected
+	var received []string
+	for i := range 4 {
+		for _, message := range []string{fmt.Sprintf("message-%d-a", i), fmt.Sprintf("message-%d-b", i)} {
+			if result := q.push([]byte(message), false); result != pushQueued {
+				t.Fatalf("expected %s to be queued, got %v", message, result)
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		frames, closed, _ := q.drain()
+		if closed {
+			t.Fatal("expected a client that catches up to stay connected")
+		}
+		for _, frame := range frames {
+			received = append(received, string(frame))
+		}
+	}
+	if len(received) != 8 {
+		t.Fatalf("expected every message to be delivered, got %v", received)
+	}
+}
+
+func TestSendQueueCloseIsIdempotent(t *testing.T) {
+	q := newSendQueue(DefaultQueuePolicy(), &queueMetrics{})
+
+	q.close(websocket.CloseGoingAway)
+	q.close(websocket.CloseNormalClosure)
+
+	if _, closed, code := q.drain(); !closed || code != websocket.CloseGoingAway {
+		t.Fatalf("expected the first close code to win, got closed=%v code=%d", closed, code)
+	}
+}
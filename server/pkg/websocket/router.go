@@ -0,0 +1,123 @@
+// pkg/websocket/router.go
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+)
+
+var (
+	// ErrInvalidFrame is returned when a frame is not a valid JSON envelope
+	ErrInvalidFrame = errors.New("invalid frame")
+
+	// ErrUnknownFrameType is returned when no handler is registered for a frame's type
+	ErrUnknownFrameType = errors.New("unknown frame type")
+)
+
+// Request is a frame received from a client, as passed through the router
+type Request struct {
+	// Ctx is the connection's context, cancelled once the connection closes
+	Ctx context.Context `json:"-"`
+
+	// Client is the client that sent the frame
+	Client *Client `json:"-"`
+
+	// Envelope fields shared by every frame type
+	Type    string          `json:"type"`
+	RoomID  string          `json:"room_id"`
+	Content string          `json:"content,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+
+	// RequestID is chosen by the client and echoed on the frame's acknowledgement or error
+	RequestID string `json:"request_id,omitempty"`
+
+	// ClientMsgID is the client's own ID for a chat message, used to deduplicate retried sends
+	ClientMsgID string `json:"client_msg_id,omitempty"`
+
+	// Raw is the frame as received
+	Raw []byte `json:"-"`
+}
+
+// HandlerFunc handles a frame. A returned error is passed to the router's error handler.
+type HandlerFunc func(req *Request) error
+
+// Middleware wraps a handler, for example to check permissions or record metrics
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// ErrorHandler is called when a frame can't be parsed, has no handler, or its handler fails
+type ErrorHandler func(req *Request, err error)
+
+// Router dispatches client frames to handlers registered by frame type
+type Router struct {
+	// handlers are wrapped in their route's and the router's middleware when registered
+	handlers   map[string]HandlerFunc
+	middleware []Middleware
+	onError    ErrorHandler
+
+	// unknown handles frame types without a handler, wrapped in the router's middleware
+	unknown HandlerFunc
+}
+
+// NewRouter creates an empty router
+func NewRouter() *Router {
+	return &Router{
+		handlers: make(map[string]HandlerFunc),
+		onError:  func(*Request, error) {},
+		unknown:  unknownFrameType,
+	}
+}
+
+// Use adds middleware that runs for every frame, including unknown frame types.
+// Middleware runs in the order it was added, before any route middleware.
+// Handlers are wrapped when they are registered, so Use panics once a route has
+// been registered.
+func (r *Router) Use(middleware ...Middleware) {
+	if len(r.handlers) > 0 {
+		panic("websocket: Router.Use called after routes were registered")
+	}
+	r.middleware = append(r.middleware, middleware...)
+	r.unknown = chain(unknownFrameType, r.middleware)
+}
+
+// Handle registers the handler for a frame type, wrapped in any route middleware
+func (r *Router) Handle(frameType string, handler HandlerFunc, middleware ...Middleware) {
+	r.handlers[frameType] = chain(chain(handler, middleware), r.middleware)
+}
+
+// OnError sets the handler for frames that fail
+func (r *Router) OnError(handler ErrorHandler) {
+	r.onError = handler
+}
+
+// Dispatch parses a frame from client and runs its handler
+func (r *Router) Dispatch(ctx context.Context, client *Client, raw []byte) {
+	req := &Request{Ctx: ctx, Client: client, Raw: raw}
+
+	if err := json.Unmarshal(raw, req); err != nil {
+		r.onError(req, ErrInvalidFrame)
+		return
+	}
+
+	handler, ok := r.handlers[req.Type]
+	if !ok {
+		handler = r.unknown
+	}
+
+	if err := handler(req); err != nil {
+		r.onError(req, err)
+	}
+}
+
+// unknownFrameType handles frame types without a handler
+func unknownFrameType(*Request) error {
+	return ErrUnknownFrameType
+}
+
+// chain wraps handler so that middleware runs in order
+func chain(handler HandlerFunc, middleware []Middleware) HandlerFunc {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
@@ -0,0 +1,149 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// recordErrors returns a router whose errors are appended to the returned slice
+func recordErrors(router *Router) *[]error {
+	errs := &[]error{}
+	router.OnError(func(req *Request, err error) {
+		*errs = append(*errs, err)
+	})
+	return errs
+}
+
+func TestRouterDispatchesByType(t *testing.T) {
+	router := NewRouter()
+	errs := recordErrors(router)
+
+	var got *Request
+	router.Handle("message", func(req *Request) error {
+		got = req
+		return nil
+	})
+
+	client := NewClient(newTestHub(t, DefaultQueuePolicy()), nil, "alice")
+	router.Dispatch(context.Background(), client, []byte(`{"type":"message","room_id":"r1","content":"hi","data":{"a":1}}`))
+
+	if len(*errs) != 0 {
+		t.Fatalf("unexpected errors: %v", *errs)
+	}
+	if got == nil {
+		t.Fatal("handler not called")
+	}
+	if got.Client != client || got.RoomID != "r1" || got.Content != "hi" || string(got.Data) != `{"a":1}` {
+		t.Fatalf("unexpected request: %+v", got)
+	}
+}
+
+func TestRouterMiddlewareOrder(t *testing.T) {
+	router := NewRouter()
+	var order []string
+	record := func(name string) Middleware {
+		return func(next HandlerFunc) HandlerFunc {
+			return func(req *Request) error {
+				order = append(order, name)
+				return next(req)
+			}
+		}
+	}
+
+	router.Use(record("global1"), record("global2"))
+	router.Handle("typing", func(req *Request) error {
+		order = append(order, "handler")
+		return nil
+	}, record("route"))
+
+	router.Dispatch(context.Background(), NewClient(newTestHub(t, DefaultQueuePolicy()), nil, "alice"), []byte(`{"type":"typing"}`))
+
+	want := []string{"global1", "global2", "route", "handler"}
+	if !reflect.DeepEqual(order, want) {
+		t.Fatalf("order = %v, want %v", order, want)
+	}
+}
+
+func TestRouterUseAfterHandlePanics(t *testing.T) {
+	router := NewRouter()
+	router.Handle("typing", func(req *Request) error { return nil })
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected Use after Handle to panic")
+		}
+	}()
+	router.Use(Recovery())
+}
+
+func TestRouterErrors(t *testing.T) {
+	router := NewRouter()
+	errs := recordErrors(router)
+	metrics := &FrameMetrics{}
+	router.Use(metrics.Middleware(), Recovery())
+
+	router.Handle("boom", func(req *Request) error {
+		panic("boom")
+	})
+	router.Handle("message", func(req *Request) error {
+		t.Fatal("handler called for unsubscribed room")
+		return nil
+	}, RequireSubscription())
+
+	client := NewClient(newTestHub(t, DefaultQueuePolicy()), nil, "alice")
+	for _, frame := range []string{
+		`not json`,
+		`{"type":"nope"}`,
+		`{"type":"boom"}`,
+		`{"type":"message"}`,
+		`{"type":"message","room_id":"r1"}`,
+	} {
+		router.Dispatch(context.Background(), client, []byte(frame))
+	}
+
+	if len(*errs) != 5 {
+		t.Fatalf("got %d errors, want 5: %v", len(*errs), *errs)
+	}
+	for i, want := range []error{ErrInvalidFrame, ErrUnknownFrameType, nil, ErrMissingRoomID, ErrNotSubscribed} {
+		if want != nil && !errors.Is((*errs)[i], want) {
+			t.Errorf("error %d = %v, want %v", i, (*errs)[i], want)
+		}
+	}
+
+	// Invalid frames never reach middleware, and unknown types are counted together
+	want := map[string]FrameStats{
+		"unknown": {Failed: 1},
+		"boom":    {Failed: 1},
+		"message": {Failed: 2},
+	}
+	if got := metrics.Snapshot(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("metrics = %v, want %v", got, want)
+	}
+}
+
+func TestRateLimitReportsRetryAfter(t *testing.T) {
+	router := NewRouter()
+	errs := recordErrors(router)
+
+	allowed := 1
+	router.Use(RateLimit(func(req *Request) (bool, time.Duration) {
+		allowed--
+		return allowed >= 0, 3 * time.Second
+	}))
+	router.Handle("typing", func(req *Request) error { return nil })
+
+	client := NewClient(newTestHub(t, DefaultQueuePolicy()), nil, "alice")
+	router.Dispatch(context.Background(), client, []byte(`{"type":"typing"}`))
+	router.Dispatch(context.Background(), client, []byte(`{"type":"typing"}`))
+
+	if len(*errs) != 1 || !errors.Is((*errs)[0], ErrRateLimited) {
+		t.Fatalf("expected one ErrRateLimited, got %v", *errs)
+	}
+	var limited *RateLimitError
+	if !errors.As((*errs)[0], &limited) || limited.RetryAfter != 3*time.Second {
+		t.Fatalf("expected a RateLimitError with RetryAfter 3s, got %v", (*errs)[0])
+	}
+}
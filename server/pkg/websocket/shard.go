@@ -0,0 +1,162 @@
+// pkg/websocket/shard.go
+package websocket
+
+// shardBroadcastBuffer is how many broadcasts may wait for a busy shard before senders block
+const shardBroadcastBuffer = 256
+
+// subscriptionRequest is a subscription change waiting to be applied by a shard
+type subscriptionRequest struct {
+	*Subscription
+
+	// applied receives whether the change was the user's first client joining
+	// or last client leaving the room, once the shard has applied it
+	applied chan bool
+}
+
+// shard owns the membership of a subset of rooms. Only the shard's goroutine
+// changes its rooms and the matching entries in each client's room set.
+type shard struct {
+	hub *Hub
+
+	// Registered clients by room
+	rooms map[string]map[*Client]bool
+
+	// users counts each user's clients by room, so a user with several clients
+	// in a room is only present once
+	users map[string]map[string]int
+
+	// Subscribe clients to rooms
+	subscribe chan subscriptionRequest
+
+	// Unsubscribe clients from rooms
+	unsubscribe chan subscriptionRequest
+
+	// Messages to fan out to a room
+	broadcast chan *Message
+}
+
+// newShard creates an empty shard
+func newShard(hub *Hub) *shard {
+	return &shard{
+		hub:         hub,
+		rooms:       make(map[string]map[*Client]bool),
+		users:       make(map[string]map[string]int),
+		subscribe:   make(chan subscriptionRequest),
+		unsubscribe: make(chan subscriptionRequest),
+		broadcast:   make(chan *Message, shardBroadcastBuffer),
+	}
+}
+
+// apply sends a subscription change to the shard and waits for it to be
+// applied. It returns whether the change was the user's first client joining or
+// last client leaving the room.
+func (s *shard) apply(requests chan subscriptionRequest, subscription *Subscription) bool {
+	req := subscriptionRequest{Subscription: subscription, applied: make(chan bool, 1)}
+
+	select {
+	case requests <- req:
+	case <-s.hub.quit:
+		return false
+	}
+
+	select {
+	case changed := <-req.applied:
+		return changed
+	case <-s.hub.quit:
+		return false
+	}
+}
+
+// run processes the shard's requests until the hub is stopped. Broadcasts
+// accepted before the hub stopped are still delivered.
+func (s *shard) run() {
+	for {
+		select {
+		case <-s.hub.quit:
+			s.drainBroadcasts()
+			return
+
+		case req := <-s.subscribe:
+			req.applied <- s.addToRoom(req.Client, req.Room)
+
+		case req := <-s.unsubscribe:
+			req.applied <- s.removeFromRoom(req.Client, req.Room)
+
+		case message := <-s.broadcast:
+			s.fanOut(message)
+		}
+	}
+}
+
+// drainBroadcasts delivers any broadcasts still buffered
+func (s *shard) drainBroadcasts() {
+	for {
+		select {
+		case message := <-s.broadcast:
+			s.fanOut(message)
+		default:
+			return
+		}
+	}
+}
+
+// fanOut delivers a message to every client in its room except the sender
+func (s *shard) fanOut(message *Message) {
+	for client := range s.rooms[message.RoomID] {
+		// Don't send message back to sender
+		if client == message.Client || (message.ExceptUser && message.Client != nil && client.ID == message.Client.ID) {
+			continue
+		}
+
+		// Clients that overflow have their queue closed, so their connection is
+		// closed and their handler unregisters them. They stay in their rooms until
+		// then, so Unregister reports the rooms they leave.
+		client.push(message.Data, message.Ephemeral)
+	}
+}
+
+// addToRoom adds a client to a room. It returns whether the client is the first
+// of its user's clients in the room.
+func (s *shard) addToRoom(client *Client, room string) bool {
+	// Ignore clients that have already been disconnected
+	if client.queue.isClosed() || s.rooms[room][client] {
+		return false
+	}
+
+	// Create room if it doesn't exist
+	if _, ok := s.rooms[room]; !ok {
+		s.rooms[room] = make(map[*Client]bool)
+		s.users[room] = make(map[string]int)
+	}
+
+	s.rooms[room][client] = true
+	s.users[room][client.ID]++
+	client.joinRoom(room)
+
+	return s.users[room][client.ID] == 1
+}
+
+// removeFromRoom removes a client from a room, deleting the room once it is
+// empty. It returns whether the client was the last of its user's clients in the room.
+func (s *shard) removeFromRoom(client *Client, room string) bool {
+	client.leaveRoom(room)
+
+	if !s.rooms[room][client] {
+		return false
+	}
+
+	delete(s.rooms[room], client)
+	s.users[room][client.ID]--
+	last := s.users[room][client.ID] == 0
+	if last {
+		delete(s.users[room], client.ID)
+	}
+
+	// If room is empty, delete it
+	if len(s.rooms[room]) == 0 {
+		delete(s.rooms, room)
+		delete(s.users, room)
+	}
+
+	return last
+}
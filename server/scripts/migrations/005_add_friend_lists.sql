@@ -0,0 +1,26 @@
+-- scripts/migrations/005_add_friend_lists.sql
+BEGIN;
+
+-- Named lists a user organises their friends into
+CREATE TABLE IF NOT EXISTS friend_lists (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    owner_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE(owner_id, name)
+);
+
+-- Friends belonging to a list
+CREATE TABLE IF NOT EXISTS friend_list_members (
+    list_id UUID NOT NULL REFERENCES friend_lists(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    added_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (list_id, user_id)
+);
+
+-- Indexes for performance
+CREATE INDEX IF NOT EXISTS idx_friend_lists_owner_id ON friend_lists(owner_id);
+CREATE INDEX IF NOT EXISTS idx_friend_list_members_user_id ON friend_list_members(user_id);
+
+COMMIT;
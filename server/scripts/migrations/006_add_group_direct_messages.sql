@@ -0,0 +1,10 @@
+-- scripts/migrations/006_add_group_direct_messages.sql
+BEGIN;
+
+-- Deterministic hash of a direct message room's sorted participant IDs, so the
+-- same set of participants always resolves to the same room
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS member_hash VARCHAR(64);
+
+CREATE INDEX IF NOT EXISTS idx_rooms_member_hash ON rooms(member_hash);
+
+COMMIT;
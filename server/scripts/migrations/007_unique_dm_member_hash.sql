@@ -0,0 +1,35 @@
+-- scripts/migrations/007_unique_dm_member_hash.sql
+BEGIN;
+
+-- Backfill the participant hash for existing 1:1 direct rooms. This must match
+-- the hash computed by the chat service: sha256 of the sorted, comma-joined user IDs.
+UPDATE rooms r
+SET member_hash = keys.member_hash
+FROM (
+    SELECT rm.room_id,
+           encode(sha256(convert_to(string_agg(rm.user_id::text, ',' ORDER BY rm.user_id::text COLLATE "C"), 'UTF8')), 'hex') AS member_hash
+    FROM room_members rm
+    JOIN rooms dr ON dr.id = rm.room_id
+    WHERE dr.type = 'direct' AND dr.member_hash IS NULL
+    GROUP BY rm.room_id
+) keys
+WHERE r.id = keys.room_id;
+
+-- Duplicate rooms created by concurrent requests keep working, but only the
+-- oldest room for each participant set is reachable by its hash
+UPDATE rooms
+SET member_hash = NULL
+WHERE id IN (
+    SELECT id FROM (
+        SELECT id, ROW_NUMBER() OVER (PARTITION BY member_hash ORDER BY created_at, id) AS rn
+        FROM rooms
+        WHERE member_hash IS NOT NULL
+    ) ranked
+    WHERE ranked.rn > 1
+);
+
+-- One room per participant set
+DROP INDEX IF EXISTS idx_rooms_member_hash;
+CREATE UNIQUE INDEX idx_rooms_member_hash ON rooms(member_hash) WHERE member_hash IS NOT NULL;
+
+COMMIT;
@@ -0,0 +1,11 @@
+-- scripts/migrations/008_add_message_client_msg_id.sql
+BEGIN;
+
+-- Client-generated ID for a message, so a send retried after a reconnect
+-- doesn't create a second message
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS client_msg_id VARCHAR(64);
+
+-- One message per client-generated ID for each user
+CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_user_client_msg_id ON messages(user_id, client_msg_id) WHERE client_msg_id IS NOT NULL;
+
+COMMIT;
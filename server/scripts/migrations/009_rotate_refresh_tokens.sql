@@ -0,0 +1,29 @@
+-- scripts/migrations/009_rotate_refresh_tokens.sql
+BEGIN;
+
+-- Refresh tokens are stored as SHA-256 hashes, so a leaked table can't be used
+-- to refresh sessions
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS token_hash TEXT;
+
+-- Tokens issued by rotating another share its family, and replaying a rotated
+-- token revokes the whole family
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS family_id UUID;
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS rotated_at TIMESTAMP WITH TIME ZONE;
+
+-- Existing tokens each start their own family
+UPDATE refresh_tokens
+SET token_hash = encode(sha256(convert_to(token, 'UTF8')), 'hex'),
+    family_id = id
+WHERE token_hash IS NULL;
+
+ALTER TABLE refresh_tokens ALTER COLUMN token_hash SET NOT NULL;
+ALTER TABLE refresh_tokens ALTER COLUMN family_id SET NOT NULL;
+
+-- Dropping the plaintext column also drops its unique constraint and index
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS token;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_refresh_tokens_token_hash ON refresh_tokens(token_hash);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON refresh_tokens(family_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_expires_at ON refresh_tokens(expires_at);
+
+COMMIT;
@@ -0,0 +1,382 @@
+package e2e
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mjxoro/sent/server/internal/handler"
+	"github.com/mjxoro/sent/server/internal/models"
+	sentws "github.com/mjxoro/sent/server/pkg/websocket"
+)
+
+func TestWSAuthCookie(t *testing.T) {
+	h := NewHarness(t)
+	aliceUser := h.CreateUser("Alice")
+	room := h.CreateRoom("general", aliceUser)
+
+	header := http.Header{"Cookie": {"auth_token=" + h.Token(aliceUser)}}
+	alice, status := h.DialWith(aliceUser, nil, header, "")
+	if alice == nil {
+		t.Fatalf("expected the cookie to authenticate the upgrade, got %d", status)
+	}
+	alice.Subscribe(room.ID)
+}
+
+func TestWSTicket(t *testing.T) {
+	h := NewHarness(t)
+	aliceUser := h.CreateUser("Alice")
+	room := h.CreateRoom("general", aliceUser)
+
+	var issued struct {
+		Ticket    string `json:"ticket"`
+		ExpiresIn int    `json:"expires_in"`
+	}
+	if status := h.Do(aliceUser, http.MethodPost, "/api/ws/ticket", nil, &issued); status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+	if issued.Ticket == "" || issued.ExpiresIn <= 0 {
+		t.Fatalf("unexpected ticket response: %+v", issued)
+	}
+
+	query := url.Values{"ticket": {issued.Ticket}}
+	alice, status := h.DialWith(aliceUser, query, nil, "")
+	if alice == nil {
+		t.Fatalf("expected the ticket to authenticate the upgrade, got %d", status)
+	}
+	alice.Subscribe(room.ID)
+
+	// Tickets are single use
+	if _, status := h.DialWith(aliceUser, query, nil, ""); status != http.StatusUnauthorized {
+		t.Fatalf("expected 401 reusing a ticket, got %d", status)
+	}
+}
+
+func TestWSAuthFrame(t *testing.T) {
+	h := NewHarness(t)
+	aliceUser := h.CreateUser("Alice")
+	room := h.CreateRoom("general", aliceUser)
+
+	for _, subprotocol := range []string{"", sentws.SubprotocolMsgpack} {
+		alice, status := h.DialWith(aliceUser, nil, nil, subprotocol)
+		if alice == nil {
+			t.Fatalf("expected a connection without credentials to upgrade, got %d", status)
+		}
+
+		alice.Send(map[string]any{"type": "auth", "request_id": "a1", "data": map[string]any{"token": h.Token(aliceUser)}})
+		if ack := alice.Expect("authenticated"); ack["success"] != true || ack.String("request_id") != "a1" || ack.String("expires_at") == "" {
+			t.Fatalf("unexpected authenticated frame: %v", ack)
+		}
+		alice.Subscribe(room.ID)
+	}
+
+	// Any other first frame, or an invalid token, closes the connection
+	for _, frame := range []map[string]any{
+		{"type": "subscribe", "room_id": room.ID},
+		{"type": "auth", "data": map[string]any{"token": "not-a-token"}},
+	} {
+		alice, _ := h.DialWith(aliceUser, nil, nil, "")
+		alice.Send(frame)
+		alice.ExpectClose(handler.CloseUnauthorized)
+	}
+}
+
+func TestWSTokenExpiring(t *testing.T) {
+	h := NewHarness(t)
+	aliceUser, bobUser := h.CreateUser("Alice"), h.CreateUser("Bob")
+
+	shortToken := func(user *models.User) string {
+		token, err := h.JWT.GenerateTokenWithDuration(user.ID, user.Email, user.Name, user.Avatar, 2*time.Second)
+		if err != nil {
+			t.Fatalf("failed to generate token: %v", err)
+		}
+		return token
+	}
+
+	// A connection whose token expires without being refreshed is closed
+	alice, _ := h.DialWith(aliceUser, nil, http.Header{"Authorization": {"Bearer " + shortToken(aliceUser)}}, "")
+	if expiring := alice.Expect("token_expiring"); expiring.String("expires_at") == "" {
+		t.Fatalf("unexpected token_expiring frame: %v", expiring)
+	}
+	alice.ExpectClose(handler.CloseUnauthorized)
+
+	// Refreshing the token in band keeps the connection open
+	bob, _ := h.DialWith(bobUser, nil, http.Header{"Authorization": {"Bearer " + shortToken(bobUser)}}, "")
+	bob.Expect("token_expiring")
+
+	bob.Send(map[string]any{"type": "auth", "data": map[string]any{"token": h.Token(aliceUser)}})
+	if ack := bob.Expect("authenticated"); ack["success"] != false || ack.String("message") != "Token is for a different user" {
+		t.Fatalf("expected re-authenticating as another user to fail, got %v", ack)
+	}
+
+	bob.Send(map[string]any{"type": "auth", "data": map[string]any{"token": h.Token(bobUser)}})
+	if ack := bob.Expect("authenticated"); ack["success"] != true {
+		t.Fatalf("unexpected authenticated frame: %v", ack)
+	}
+	bob.ExpectNone(3 * time.Second)
+}
+
+func TestOriginAllowlist(t *testing.T) {
+	h := NewHarness(t)
+	aliceUser := h.CreateUser("Alice")
+
+	dial := func(origin string) int {
+		header := http.Header{"Cookie": {"auth_token=" + h.Token(aliceUser)}, "Origin": {origin}}
+		client, status := h.DialWith(aliceUser, nil, header, "")
+		if client != nil {
+			client.Close()
+		}
+		return status
+	}
+	if status := dial(AllowedOrigin); status != http.StatusSwitchingProtocols {
+		t.Fatalf("expected an upgrade from the allowed origin, got %d", status)
+	}
+	if status := dial("https://evil.example"); status != http.StatusForbidden {
+		t.Fatalf("expected 403 for a cross-site upgrade, got %d", status)
+	}
+
+	// CORS uses the same allowlist
+	preflight := func(origin string) string {
+		req, _ := http.NewRequest(http.MethodOptions, h.Server.URL+"/api/rooms", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("preflight failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.Header.Get("Access-Control-Allow-Origin")
+	}
+	if allowed := preflight(AllowedOrigin); allowed != AllowedOrigin {
+		t.Fatalf("expected the allowed origin to pass CORS, got %q", allowed)
+	}
+	if allowed := preflight("https://evil.example"); allowed != "" {
+		t.Fatalf("expected a cross-site origin to fail CORS, got %q", allowed)
+	}
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	h := NewHarness(t)
+	aliceUser := h.CreateUser("Alice")
+	first := h.RefreshToken(aliceUser)
+
+	type refreshResponse struct {
+		AuthToken    string `json:"auth_token"`
+		RefreshToken string `json:"refresh_token"`
+		Error        string `json:"error"`
+	}
+	refresh := func(token string) (int, refreshResponse) {
+		var resp refreshResponse
+		status := h.Do(aliceUser, http.MethodPost, "/api/auth/refresh_token", map[string]string{"refreshToken": token}, &resp)
+		return status, resp
+	}
+
+	status, rotated := refresh(first)
+	if status != http.StatusOK || rotated.AuthToken == "" || rotated.RefreshToken == "" || rotated.RefreshToken == first {
+		t.Fatalf("expected a new access and refresh token, got %d %+v", status, rotated)
+	}
+	if claims, err := h.JWT.ValidateToken(rotated.AuthToken); err != nil || claims.UserID != aliceUser.ID {
+		t.Fatalf("expected a valid access token for Alice, got %v", err)
+	}
+
+	status, second := refresh(rotated.RefreshToken)
+	if status != http.StatusOK {
+		t.Fatalf("expected the rotated token to refresh, got %d %+v", status, second)
+	}
+
+	// Replaying a used token revokes every token from the same login
+	if status, resp := refresh(first); status != http.StatusUnauthorized || resp.Error != "refresh token reused" {
+		t.Fatalf("expected the replayed token to be rejected as reused, got %d %+v", status, resp)
+	}
+	if status, resp := refresh(second.RefreshToken); status != http.StatusUnauthorized {
+		t.Fatalf("expected the latest token to be revoked after reuse, got %d %+v", status, resp)
+	}
+
+	// Other logins keep working
+	if status, resp := refresh(h.RefreshToken(aliceUser)); status != http.StatusOK {
+		t.Fatalf("expected another login to refresh, got %d %+v", status, resp)
+	}
+}
+
+func TestRefreshTokenIsNotAccessToken(t *testing.T) {
+	h := NewHarness(t)
+	aliceUser := h.CreateUser("Alice")
+	refreshToken := h.RefreshToken(aliceUser)
+
+	// A refresh token lasts far longer than an access token, and rotating it
+	// only revokes it for refreshing, so it must never authenticate anything else
+	bearer := http.Header{"Authorization": {"Bearer " + refreshToken}}
+	if status := h.DoWithHeader(aliceUser, http.MethodGet, "/api/user/profile", bearer, nil, nil); status != http.StatusUnauthorized {
+		t.Fatalf("expected a refresh token to be refused by the API, got %d", status)
+	}
+	if status := h.DialStatus(refreshToken); status != http.StatusUnauthorized {
+		t.Fatalf("expected a refresh token to be refused by the WebSocket, got %d", status)
+	}
+
+	// Nor is an access token any good for refreshing
+	var resp struct {
+		Error string `json:"error"`
+	}
+	if status := h.Do(aliceUser, http.MethodPost, "/api/auth/refresh_token", map[string]string{"refreshToken": h.Token(aliceUser)}, &resp); status != http.StatusUnauthorized || resp.Error != "invalid refresh token" {
+		t.Fatalf("expected an access token to be refused for refreshing, got %d %+v", status, resp)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	h := NewHarness(t)
+	aliceUser := h.CreateUser("Alice")
+	token, refreshToken := h.Token(aliceUser), h.RefreshToken(aliceUser)
+	withToken := http.Header{"Authorization": {"Bearer " + token}}
+
+	phone, status := h.DialWith(aliceUser, nil, withToken, "")
+	if phone == nil {
+		t.Fatalf("failed to dial as Alice (status %d)", status)
+	}
+	laptop := h.Dial(aliceUser)
+	phone.Sync()
+	laptop.Sync()
+
+	body := map[string]string{"refreshToken": refreshToken}
+	if status := h.DoWithHeader(aliceUser, http.MethodPost, "/api/auth/logout", withToken, body, nil); status != http.StatusOK {
+		t.Fatalf("expected 200 logging out, got %d", status)
+	}
+
+	// Only the session that logged out ends
+	phone.ExpectClose(handler.CloseUnauthorized)
+	laptop.Sync()
+
+	var resp struct {
+		Error string `json:"error"`
+	}
+	if status := h.DoWithHeader(aliceUser, http.MethodGet, "/api/user/profile", withToken, nil, &resp); status != http.StatusUnauthorized || resp.Error != "token revoked" {
+		t.Fatalf("expected the logged out token to be revoked, got %d %+v", status, resp)
+	}
+	if status := h.Do(aliceUser, http.MethodGet, "/api/user/profile", nil, nil); status != http.StatusOK {
+		t.Fatalf("expected other tokens to keep working, got %d", status)
+	}
+	if status := h.Do(aliceUser, http.MethodPost, "/api/auth/refresh_token", body, nil); status != http.StatusUnauthorized {
+		t.Fatalf("expected the logged out refresh token to be revoked, got %d", status)
+	}
+	if _, status := h.DialWith(aliceUser, nil, withToken, ""); status != http.StatusUnauthorized {
+		t.Fatalf("expected the logged out token to be refused a WebSocket, got %d", status)
+	}
+
+	// Logging out again with the dead tokens still clears the session
+	if status := h.DoWithHeader(aliceUser, http.MethodPost, "/api/auth/logout", withToken, body, nil); status != http.StatusOK {
+		t.Fatalf("expected 200 logging out twice, got %d", status)
+	}
+}
+
+func TestLogoutClearsCookies(t *testing.T) {
+	h := NewHarness(t)
+	aliceUser := h.CreateUser("Alice")
+
+	req, err := http.NewRequest(http.MethodPost, h.Server.URL+"/api/auth/logout", nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	req.AddCookie(&http.Cookie{Name: "auth_token", Value: h.Token(aliceUser)})
+	req.AddCookie(&http.Cookie{Name: "refresh_token", Value: h.RefreshToken(aliceUser)})
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("logout failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 logging out, got %d", resp.StatusCode)
+	}
+
+	cleared := map[string]bool{}
+	for _, cookie := range resp.Cookies() {
+		if cookie.MaxAge < 0 && cookie.Value == "" {
+			cleared[cookie.Name] = true
+		}
+	}
+	if !cleared["auth_token"] || !cleared["refresh_token"] {
+		t.Fatalf("expected both auth cookies to be cleared, got %v", resp.Header.Values("Set-Cookie"))
+	}
+}
+
+func TestLogoutAll(t *testing.T) {
+	h := NewHarness(t)
+	aliceUser, bobUser := h.CreateUser("Alice"), h.CreateUser("Bob")
+	room := h.CreateRoom("general", aliceUser, bobUser)
+	token, refreshToken := h.Token(aliceUser), h.RefreshToken(aliceUser)
+
+	bob := h.Dial(bobUser)
+	bob.Subscribe(room.ID)
+	phone := h.Dial(aliceUser)
+	laptop := h.Events(aliceUser)
+	phone.Subscribe(room.ID)
+	bob.Expect("system")
+
+	if status := h.Do(aliceUser, http.MethodPost, "/api/auth/logout-all", nil, nil); status != http.StatusOK {
+		t.Fatalf("expected 200 logging out everywhere, got %d", status)
+	}
+
+	phone.ExpectClose(handler.CloseUnauthorized)
+	select {
+	case <-laptop.done:
+	case <-time.After(frameTimeout):
+		t.Fatal("event stream was not closed")
+	}
+
+	// The room hears that Alice left once her closed connection is cleaned up
+	if left := bob.Expect("system"); left.String("action") != "left" || left.String("user_id") != aliceUser.ID {
+		t.Fatalf("unexpected left frame: %v", left)
+	}
+
+	withToken := http.Header{"Authorization": {"Bearer " + token}}
+	if status := h.DoWithHeader(aliceUser, http.MethodGet, "/api/user/profile", withToken, nil, nil); status != http.StatusUnauthorized {
+		t.Fatalf("expected every earlier token to be revoked, got %d", status)
+	}
+	if status := h.Do(aliceUser, http.MethodPost, "/api/auth/refresh_token", map[string]string{"refreshToken": refreshToken}, nil); status != http.StatusUnauthorized {
+		t.Fatalf("expected every refresh token to be revoked, got %d", status)
+	}
+	if status := h.Do(bobUser, http.MethodGet, "/api/user/profile", nil, nil); status != http.StatusOK {
+		t.Fatalf("expected other users to be unaffected, got %d", status)
+	}
+
+	// Logging straight back in works, even within the same second
+	if status := h.Do(aliceUser, http.MethodGet, "/api/user/profile", nil, nil); status != http.StatusOK {
+		t.Fatalf("expected a token issued after logging out to work, got %d", status)
+	}
+}
+
+func TestLogoutRevokesRefreshTokenFromBody(t *testing.T) {
+	h := NewHarness(t)
+	aliceUser := h.CreateUser("Alice")
+	refreshToken := h.RefreshToken(aliceUser)
+	body := map[string]string{"refreshToken": refreshToken}
+
+	logout := func(body string) int {
+		t.Helper()
+		resp, err := http.Post(h.Server.URL+"/api/auth/logout", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("logout failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// A body that can't be read is refused rather than leaving its token valid
+	if status := logout(`{"refreshToken":`); status != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an invalid body, got %d", status)
+	}
+	if status := logout(""); status != http.StatusOK {
+		t.Fatalf("expected 200 logging out without a body, got %d", status)
+	}
+
+	if status := logout(`{"refreshToken":"` + refreshToken + `"}`); status != http.StatusOK {
+		t.Fatalf("expected 200 logging out, got %d", status)
+	}
+	var resp struct {
+		Error string `json:"error"`
+	}
+	if status := h.Do(aliceUser, http.MethodPost, "/api/auth/refresh_token", body, &resp); status != http.StatusUnauthorized || resp.Error != "refresh token not valid" {
+		t.Fatalf("expected the logged out refresh token to be rejected, got %d %+v", status, resp)
+	}
+}
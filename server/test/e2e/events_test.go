@@ -0,0 +1,190 @@
+package e2e
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestEventsStreamSharesWebSocketFrames(t *testing.T) {
+	h := NewHarness(t)
+	aliceUser, bobUser := h.CreateUser("Alice"), h.CreateUser("Bob")
+	room := h.CreateRoom("general", aliceUser, bobUser)
+
+	bob := h.Dial(bobUser)
+	bob.Subscribe(room.ID)
+
+	alice := h.Events(aliceUser, room.ID)
+	if joined := bob.Expect("system"); joined.String("action") != "joined" || joined.String("user_id") != aliceUser.ID {
+		t.Fatalf("unexpected joined frame: %v", joined)
+	}
+
+	bob.Send(map[string]any{"type": "message", "room_id": room.ID, "content": "hello"})
+	bob.Expect("message_sent")
+	if message := alice.Expect("message"); message.String("content") != "hello" {
+		t.Fatalf("unexpected message frame: %v", message)
+	}
+
+	// Frames posted to the connection are handled like WebSocket frames
+	alice.Send(map[string]any{"type": "message", "room_id": room.ID, "content": "hi", "request_id": "r1"})
+	if sent := alice.Expect("message_sent"); sent["success"] != true || sent.String("request_id") != "r1" {
+		t.Fatalf("unexpected message_sent frame: %v", sent)
+	}
+	if message := bob.Expect("message"); message.String("content") != "hi" {
+		t.Fatalf("unexpected message frame: %v", message)
+	}
+
+	alice.Close()
+	if left := bob.Expect("system"); left.String("action") != "left" {
+		t.Fatalf("unexpected left frame: %v", left)
+	}
+}
+
+func TestEventsSendMessage(t *testing.T) {
+	h := NewHarness(t)
+	aliceUser, bobUser, carolUser := h.CreateUser("Alice"), h.CreateUser("Bob"), h.CreateUser("Carol")
+	room := h.CreateRoom("general", aliceUser, bobUser)
+
+	bob := h.Dial(bobUser)
+	bob.Subscribe(room.ID)
+	alice := h.Events(aliceUser, room.ID)
+	bob.Expect("system")
+
+	path := "/api/rooms/" + room.ID + "/messages"
+	send := map[string]any{"content": "hello", "client_msg_id": "c1", "connection_id": alice.ConnectionID}
+
+	var sent map[string]any
+	if status := h.Do(aliceUser, http.MethodPost, path, send, &sent); status != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %v", status, sent)
+	}
+	if sent["type"] != "message_sent" || sent["message_id"] == "" {
+		t.Fatalf("unexpected response: %v", sent)
+	}
+
+	if message := bob.Expect("message"); message.String("id") != sent["message_id"] {
+		t.Fatalf("unexpected message frame: %v", message)
+	}
+
+	// The sender's own connection doesn't get its message back
+	alice.ExpectNone(quiet)
+
+	var retried map[string]any
+	if status := h.Do(aliceUser, http.MethodPost, path, send, &retried); status != http.StatusOK {
+		t.Fatalf("expected 200 for a retry, got %d", status)
+	}
+	if retried["duplicate"] != true || retried["message_id"] != sent["message_id"] {
+		t.Fatalf("unexpected retry response: %v", retried)
+	}
+	bob.ExpectNone(quiet)
+
+	if status := h.Do(carolUser, http.MethodPost, path, map[string]any{"content": "hi"}, nil); status != http.StatusForbidden {
+		t.Fatalf("expected 403 for a non-member, got %d", status)
+	}
+}
+
+func TestSendMessageIdempotencyKey(t *testing.T) {
+	h := NewHarness(t)
+	aliceUser, bobUser := h.CreateUser("Alice"), h.CreateUser("Bob")
+	room := h.CreateRoom("general", aliceUser, bobUser)
+
+	bob := h.Dial(bobUser)
+	bob.Subscribe(room.ID)
+
+	// A bot posting without any connection
+	path := "/api/rooms/" + room.ID + "/messages"
+	header := http.Header{"Idempotency-Key": {"k1"}}
+	send := map[string]any{"content": "deploy finished"}
+
+	var sent map[string]any
+	if status := h.DoWithHeader(aliceUser, http.MethodPost, path, header, send, &sent); status != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %v", status, sent)
+	}
+	if sent["client_msg_id"] != "k1" || sent["duplicate"] == true {
+		t.Fatalf("unexpected response: %v", sent)
+	}
+	if message := bob.Expect("message"); message.String("id") != sent["message_id"] {
+		t.Fatalf("unexpected message frame: %v", message)
+	}
+
+	var retried map[string]any
+	if status := h.DoWithHeader(aliceUser, http.MethodPost, path, header, send, &retried); status != http.StatusOK {
+		t.Fatalf("expected 200 for a retry, got %d", status)
+	}
+	if retried["duplicate"] != true || retried["message_id"] != sent["message_id"] {
+		t.Fatalf("unexpected retry response: %v", retried)
+	}
+	bob.ExpectNone(quiet)
+
+	// The key identifies the message, so it can't be reused for other content
+	changed := map[string]any{"content": "deploy failed"}
+	if status := h.DoWithHeader(aliceUser, http.MethodPost, path, header, changed, nil); status != http.StatusConflict {
+		t.Fatalf("expected 409 reusing a key, got %d", status)
+	}
+
+	conflicting := map[string]any{"content": "hi", "client_msg_id": "c2"}
+	if status := h.DoWithHeader(aliceUser, http.MethodPost, path, header, conflicting, nil); status != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a key that differs from client_msg_id, got %d", status)
+	}
+	bob.ExpectNone(quiet)
+}
+
+func TestEventsLongPoll(t *testing.T) {
+	h := NewHarness(t)
+	aliceUser, bobUser := h.CreateUser("Alice"), h.CreateUser("Bob")
+	room := h.CreateRoom("general", aliceUser, bobUser)
+
+	bob := h.Dial(bobUser)
+	bob.Subscribe(room.ID)
+
+	var frames []Frame
+	if status := h.Do(aliceUser, http.MethodGet, "/api/events/poll?room_id="+room.ID, nil, &frames); status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+	if len(frames) != 1 || frames[0].Type() != "connected" {
+		t.Fatalf("unexpected frames: %v", frames)
+	}
+	connectionID := frames[0].String("connection_id")
+	bob.Expect("system")
+
+	bob.Send(map[string]any{"type": "message", "room_id": room.ID, "content": "hello"})
+	bob.Expect("message_sent")
+
+	poll := "/api/events/poll?connection_id=" + connectionID
+	if status := h.Do(aliceUser, http.MethodGet, poll, nil, &frames); status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+	if len(frames) != 1 || frames[0].String("content") != "hello" {
+		t.Fatalf("unexpected frames: %v", frames)
+	}
+
+	// Connections belong to the user that opened them
+	if status := h.Do(bobUser, http.MethodGet, poll, nil, nil); status != http.StatusNotFound {
+		t.Fatalf("expected 404 polling another user's connection, got %d", status)
+	}
+
+	if status := h.Do(aliceUser, http.MethodDelete, "/api/events/"+connectionID, nil, nil); status != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", status)
+	}
+	bob.Expect("system")
+
+	if status := h.Do(aliceUser, http.MethodGet, poll, nil, nil); status != http.StatusNotFound {
+		t.Fatalf("expected 404 polling a closed connection, got %d", status)
+	}
+}
+
+func TestEventsStreamServerGoingAway(t *testing.T) {
+	h := NewHarness(t)
+	alice := h.Events(h.CreateUser("Alice"))
+
+	if err := h.Hub.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+
+	alice.Expect("server_going_away")
+	select {
+	case <-alice.done:
+	case <-time.After(frameTimeout):
+		t.Fatal("event stream was not closed")
+	}
+}
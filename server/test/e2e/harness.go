@@ -0,0 +1,599 @@
+// test/e2e/harness.go
+
+// Package e2e runs the API end to end against in-memory storage, so the
+// WebSocket protocol can be tested with real clients over a real connection.
+package e2e
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	gorillaWs "github.com/gorilla/websocket"
+	"github.com/mjxoro/sent/server/internal/auth"
+	"github.com/mjxoro/sent/server/internal/config"
+	"github.com/mjxoro/sent/server/internal/db/memory"
+	"github.com/mjxoro/sent/server/internal/models"
+	"github.com/mjxoro/sent/server/internal/ratelimit"
+	"github.com/mjxoro/sent/server/internal/repository"
+	"github.com/mjxoro/sent/server/internal/server"
+	"github.com/mjxoro/sent/server/internal/service"
+	"github.com/mjxoro/sent/server/pkg/websocket"
+	"github.com/ugorji/go/codec"
+)
+
+// AllowedOrigin is the only browser origin the harness server allows
+const AllowedOrigin = "https://app.example.com"
+
+// frameTimeout is how long Next waits for a frame before failing the test
+const frameTimeout = 2 * time.Second
+
+// Harness runs the API router on an httptest server backed by in-memory storage
+type Harness struct {
+	t      *testing.T
+	Server *httptest.Server
+	Repos  *repository.Set
+	Chat   *service.ChatService
+	JWT    *auth.JWTService
+	Hub    *websocket.Hub
+
+	RefreshTokens *service.RefreshTokenService
+}
+
+// NewHarness starts a server for the duration of the test. Options can change
+// the router's dependencies, such as its rate limits, before it is created.
+func NewHarness(t *testing.T, options ...func(*server.Dependencies)) *Harness {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	store := memory.NewStore()
+	repos := store.Repositories()
+	pubsub := memory.NewPubSub()
+
+	userService := service.NewUserService(repos.Users)
+	chatService := service.NewChatService(store, repos.Rooms, repos.Messages, repos.Users, pubsub)
+	jwtService := auth.NewJWTService()
+	refreshTokenService := service.NewRefreshTokenService(store, repos.RefreshTokens)
+
+	origins, err := auth.NewOriginAllowlist([]string{AllowedOrigin}, false)
+	if err != nil {
+		t.Fatalf("NewOriginAllowlist: %v", err)
+	}
+
+	hub := websocket.NewHub(0, websocket.DefaultQueuePolicy())
+	go hub.Run()
+
+	deps := server.Dependencies{
+		UserService:         userService,
+		ChatService:         chatService,
+		RefreshTokenService: refreshTokenService,
+		FriendshipService:   service.NewFriendshipService(store, repos.Friendships, repos.Users, memory.NewCache()),
+		FriendListService:   service.NewFriendListService(repos.FriendLists, repos.Friendships),
+		OAuthService:        auth.NewOAuthService(config.Load()),
+		JWTService:          jwtService,
+		WSTicketService:     service.NewWSTicketService(memory.NewCache()),
+		TokenRevocations:    service.NewTokenRevocationService(memory.NewCache(), jwtService.TokenDuration()),
+		Hub:                 hub,
+		RateLimiter:         ratelimit.New(memory.NewRateLimiter(), config.Load().RateLimit),
+		Origins:             origins,
+		WebSocket: config.WebSocketConfig{
+			CompressionLevel: 1,
+			TypingTimeout:    time.Second,
+		},
+	}
+	for _, option := range options {
+		option(&deps)
+	}
+	router := server.NewRouter(deps)
+
+	srv := httptest.NewServer(router)
+	t.Cleanup(func() {
+		hub.Stop(context.Background())
+		srv.Close()
+	})
+
+	return &Harness{
+		t:      t,
+		Server: srv,
+		Repos:  repos,
+		Chat:   chatService,
+		JWT:    jwtService,
+		Hub:    hub,
+
+		RefreshTokens: refreshTokenService,
+	}
+}
+
+// CreateUser stores a user with the given name
+func (h *Harness) CreateUser(name string) *models.User {
+	h.t.Helper()
+	user := &models.User{
+		Name:     name,
+		Email:    strings.ToLower(name) + "@example.com",
+		OAuthID:  strings.ToLower(name),
+		Provider: "google",
+	}
+	if err := h.Repos.Users.Create(context.Background(), user); err != nil {
+		h.t.Fatalf("failed to create user %s: %v", name, err)
+	}
+	return user
+}
+
+// CreateRoom creates a group room owned by creator with the given members
+func (h *Harness) CreateRoom(name string, creator *models.User, members ...*models.User) *models.Room {
+	h.t.Helper()
+	memberIDs := make([]string, len(members))
+	for i, member := range members {
+		memberIDs[i] = member.ID
+	}
+	room, err := h.Chat.CreateRoom(context.Background(), name, "", false, creator.ID, memberIDs...)
+	if err != nil {
+		h.t.Fatalf("failed to create room %s: %v", name, err)
+	}
+	return room
+}
+
+// Token mints an access token for a user
+func (h *Harness) Token(user *models.User) string {
+	h.t.Helper()
+	token, err := h.JWT.GenerateToken(user.ID, user.Email, user.Name, user.Avatar)
+	if err != nil {
+		h.t.Fatalf("failed to generate token: %v", err)
+	}
+	return token
+}
+
+// RefreshToken mints and stores a refresh token for a user, as logging in does
+func (h *Harness) RefreshToken(user *models.User) string {
+	h.t.Helper()
+	token, err := h.JWT.GenerateRefreshToken(user.ID)
+	if err != nil {
+		h.t.Fatalf("failed to generate refresh token: %v", err)
+	}
+	if err := h.RefreshTokens.Store(context.Background(), user.ID, token, h.JWT.GetRefreshTokenExpiry()); err != nil {
+		h.t.Fatalf("failed to store refresh token: %v", err)
+	}
+	return token
+}
+
+// wsURL returns the WebSocket endpoint URL with query
+func (h *Harness) wsURL(query url.Values) string {
+	u := "ws" + strings.TrimPrefix(h.Server.URL, "http") + "/api/ws"
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+// Dial opens a WebSocket connection as user. The connection is closed when the test ends.
+func (h *Harness) Dial(user *models.User) *Client {
+	h.t.Helper()
+	return h.DialSubprotocol(user, "")
+}
+
+// DialSubprotocol opens a compressed WebSocket connection as user that asks for
+// subprotocol, or for none if it is empty
+func (h *Harness) DialSubprotocol(user *models.User, subprotocol string) *Client {
+	h.t.Helper()
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+h.Token(user))
+
+	client, status := h.DialWith(user, nil, header, subprotocol)
+	if client == nil {
+		h.t.Fatalf("failed to dial as %s (status %d)", user.Name, status)
+	}
+	return client
+}
+
+// DialWith opens a compressed WebSocket connection with the given query and
+// headers, asking for subprotocol unless it is empty. Frames are reported as
+// received by user, who the connection is expected to authenticate as. It
+// returns the handshake's HTTP status, and a nil client if the handshake failed.
+func (h *Harness) DialWith(user *models.User, query url.Values, header http.Header, subprotocol string) (*Client, int) {
+	h.t.Helper()
+
+	dialer := *gorillaWs.DefaultDialer
+	dialer.EnableCompression = true
+	if subprotocol != "" {
+		dialer.Subprotocols = []string{subprotocol}
+	}
+
+	conn, resp, err := dialer.Dial(h.wsURL(query), header)
+	if err != nil {
+		if resp == nil {
+			h.t.Fatalf("dial failed without a response: %v", err)
+		}
+		return nil, resp.StatusCode
+	}
+
+	client := &Client{
+		t:          h.t,
+		User:       user,
+		Compressed: strings.Contains(resp.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate"),
+		conn:       conn,
+		send:       conn.WriteMessage,
+		close:      func() { conn.Close() },
+		frames:     make(chan Frame, 256),
+		done:       make(chan struct{}),
+	}
+	go client.readLoop()
+	h.t.Cleanup(client.Close)
+
+	return client, resp.StatusCode
+}
+
+// DialStatus attempts a WebSocket handshake with the given token, passed the way
+// older clients do in ?token=, and returns the HTTP status
+func (h *Harness) DialStatus(token string) int {
+	h.t.Helper()
+	client, status := h.DialWith(nil, url.Values{"token": {token}}, nil, "")
+	if client != nil {
+		client.Close()
+	}
+	return status
+}
+
+// Events opens a Server-Sent Events stream as user, subscribed to roomIDs, and
+// waits for its connected frame. Frames sent on the returned client are posted
+// to the connection. The stream is closed when the test ends.
+func (h *Harness) Events(user *models.User, roomIDs ...string) *Client {
+	h.t.Helper()
+
+	query := url.Values{"room_id": roomIDs}
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.Server.URL+"/api/events?"+query.Encode(), nil)
+	if err != nil {
+		h.t.Fatalf("failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+h.Token(user))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		h.t.Fatalf("failed to open event stream as %s: %v", user.Name, err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		h.t.Fatalf("unexpected event stream response %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	client := &Client{
+		t:      h.t,
+		User:   user,
+		close:  cancel,
+		frames: make(chan Frame, 256),
+		done:   make(chan struct{}),
+	}
+	client.send = func(_ int, data []byte) error {
+		status := h.Do(user, http.MethodPost, "/api/events/"+client.ConnectionID, json.RawMessage(data), nil)
+		if status != http.StatusAccepted {
+			return fmt.Errorf("posting frame: status %d", status)
+		}
+		return nil
+	}
+
+	go func() {
+		defer close(client.done)
+		defer resp.Body.Close()
+
+		// Each event is a single data line holding one frame
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+			if !ok {
+				continue
+			}
+			var frame Frame
+			if err := json.Unmarshal([]byte(data), &frame); err != nil {
+				client.t.Errorf("%s received an invalid event %q: %v", user.Name, data, err)
+				continue
+			}
+			client.frames <- frame
+		}
+		client.closeErr = scanner.Err()
+	}()
+	h.t.Cleanup(client.Close)
+
+	client.ConnectionID = client.Expect("connected").String("connection_id")
+	return client
+}
+
+// Do sends an authenticated JSON request as user, decoding the response into out
+// if it isn't nil, and returns the status code
+func (h *Harness) Do(user *models.User, method, path string, body, out any) int {
+	h.t.Helper()
+	return h.DoWithHeader(user, method, path, nil, body, out)
+}
+
+// DoWithHeader is Do with extra request headers, which may include its own Authorization
+func (h *Harness) DoWithHeader(user *models.User, method, path string, header http.Header, body, out any) int {
+	h.t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			h.t.Fatalf("failed to encode request: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, h.Server.URL+path, reader)
+	if err != nil {
+		h.t.Fatalf("failed to create request: %v", err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if req.Header.Get("Authorization") == "" {
+		req.Header.Set("Authorization", "Bearer "+h.Token(user))
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		h.t.Fatalf("%s %s failed: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			h.t.Fatalf("%s %s returned an invalid body: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// Frame is a decoded server frame
+type Frame map[string]any
+
+// Type returns the frame's type field
+func (f Frame) Type() string {
+	s, _ := f["type"].(string)
+	return s
+}
+
+// String returns the frame's field as a string
+func (f Frame) String(key string) string {
+	s, _ := f[key].(string)
+	return s
+}
+
+// Data returns the frame's data object
+func (f Frame) Data() map[string]any {
+	data, _ := f["data"].(map[string]any)
+	return data
+}
+
+// Client is a WebSocket client connected to the harness server
+type Client struct {
+	t    *testing.T
+	User *models.User
+
+	// Compressed reports whether permessage-deflate was negotiated
+	Compressed bool
+
+	// ConnectionID identifies an event stream connection, which has no WebSocket
+	ConnectionID string
+
+	// conn is the WebSocket connection, or nil for an event stream
+	conn *gorillaWs.Conn
+
+	// send writes a client frame and close closes the connection, for either transport
+	send  func(messageType int, data []byte) error
+	close func()
+
+	frames  chan Frame
+	pending []Frame
+	done    chan struct{}
+
+	// closeErr is the error that ended the read loop, set before done is closed
+	closeErr error
+}
+
+// msgpackHandle decodes MessagePack maps with string keys, like JSON objects
+var msgpackHandle = func() *codec.MsgpackHandle {
+	h := &codec.MsgpackHandle{}
+	h.WriteExt = true
+	h.MapType = reflect.TypeOf(map[string]any(nil))
+	return h
+}()
+
+// readLoop decodes incoming frames until the connection closes. The server
+// batches queued frames into one WebSocket message, framed according to the
+// negotiated subprotocol.
+func (c *Client) readLoop() {
+	defer close(c.done)
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			c.closeErr = err
+			return
+		}
+
+		frames, err := c.decodeBatch(data)
+		if err != nil {
+			c.t.Errorf("%s received an invalid message %q: %v", c.User.Name, data, err)
+			continue
+		}
+		for _, frame := range frames {
+			c.frames <- frame
+		}
+	}
+}
+
+// decodeBatch splits a message from the server into frames
+func (c *Client) decodeBatch(data []byte) ([]Frame, error) {
+	var frames []Frame
+
+	switch c.conn.Subprotocol() {
+	case websocket.SubprotocolJSON:
+		err := json.Unmarshal(data, &frames)
+		return frames, err
+
+	case websocket.SubprotocolMsgpack:
+		var values []map[string]any
+		if err := codec.NewDecoderBytes(data, msgpackHandle).Decode(&values); err != nil {
+			return nil, err
+		}
+		for _, value := range values {
+			frames = append(frames, Frame(value))
+		}
+		return frames, nil
+
+	default:
+		for _, line := range strings.Split(string(data), "\n") {
+			if line == "" {
+				continue
+			}
+			var frame Frame
+			if err := json.Unmarshal([]byte(line), &frame); err != nil {
+				return nil, err
+			}
+			frames = append(frames, frame)
+		}
+		return frames, nil
+	}
+}
+
+// Close closes the connection
+func (c *Client) Close() {
+	c.close()
+	<-c.done
+}
+
+// SendRaw writes a raw text frame
+func (c *Client) SendRaw(data string) {
+	c.t.Helper()
+	if err := c.send(gorillaWs.TextMessage, []byte(data)); err != nil {
+		c.t.Fatalf("%s failed to write frame: %v", c.User.Name, err)
+	}
+}
+
+// Send writes a client frame, encoded as MessagePack if that subprotocol was
+// negotiated and as JSON otherwise
+func (c *Client) Send(frame map[string]any) {
+	c.t.Helper()
+
+	if c.conn != nil && c.conn.Subprotocol() == websocket.SubprotocolMsgpack {
+		var data []byte
+		if err := codec.NewEncoderBytes(&data, msgpackHandle).Encode(frame); err != nil {
+			c.t.Fatalf("failed to encode frame: %v", err)
+		}
+		if err := c.send(gorillaWs.BinaryMessage, data); err != nil {
+			c.t.Fatalf("%s failed to write frame: %v", c.User.Name, err)
+		}
+		return
+	}
+
+	data, err := json.Marshal(frame)
+	if err != nil {
+		c.t.Fatalf("failed to encode frame: %v", err)
+	}
+	c.SendRaw(string(data))
+}
+
+// Subscribe subscribes to a room and waits until the server has processed it
+func (c *Client) Subscribe(roomID string) {
+	c.t.Helper()
+	c.Send(map[string]any{"type": "subscribe", "room_id": roomID})
+	c.Sync()
+}
+
+// Sync waits until the server has handled every frame sent so far. The server
+// processes a connection's frames in order and replies to unparseable ones with
+// an error frame, so one is sent as a barrier. Other frames received meanwhile
+// are kept for Next.
+func (c *Client) Sync() {
+	c.t.Helper()
+	c.SendRaw("sync")
+
+	var skipped []Frame
+	for {
+		frame := c.receive()
+		if frame.Type() == "error" && frame.String("message") == "Invalid message format" {
+			c.pending = append(c.pending, skipped...)
+			return
+		}
+		skipped = append(skipped, frame)
+	}
+}
+
+// receive returns the next frame from the connection, ignoring pending frames
+func (c *Client) receive() Frame {
+	c.t.Helper()
+	select {
+	case frame := <-c.frames:
+		return frame
+	case <-time.After(frameTimeout):
+		c.t.Fatalf("%s timed out waiting for a frame", c.User.Name)
+		return nil
+	}
+}
+
+// Next returns the next frame received
+func (c *Client) Next() Frame {
+	c.t.Helper()
+	if len(c.pending) > 0 {
+		frame := c.pending[0]
+		c.pending = c.pending[1:]
+		return frame
+	}
+	return c.receive()
+}
+
+// Expect returns the next frame, failing the test if it is not of the given type
+func (c *Client) Expect(frameType string) Frame {
+	c.t.Helper()
+	frame := c.Next()
+	if frame.Type() != frameType {
+		c.t.Fatalf("%s expected a %q frame, got %v", c.User.Name, frameType, frame)
+	}
+	return frame
+}
+
+// ExpectSequence expects frames of the given types in order and returns them
+func (c *Client) ExpectSequence(frameTypes ...string) []Frame {
+	c.t.Helper()
+	frames := make([]Frame, len(frameTypes))
+	for i, frameType := range frameTypes {
+		frames[i] = c.Expect(frameType)
+	}
+	return frames
+}
+
+// ExpectClose waits for the server to close the connection with the given status code
+func (c *Client) ExpectClose(code int) {
+	c.t.Helper()
+	select {
+	case <-c.done:
+	case <-time.After(frameTimeout):
+		c.t.Fatalf("%s timed out waiting for the connection to close", c.User.Name)
+	}
+	if !gorillaWs.IsCloseError(c.closeErr, code) {
+		c.t.Fatalf("%s expected close code %d, got %v", c.User.Name, code, c.closeErr)
+	}
+}
+
+// ExpectNone fails the test if any frame arrives within d
+func (c *Client) ExpectNone(d time.Duration) {
+	c.t.Helper()
+	if len(c.pending) > 0 {
+		c.t.Fatalf("%s expected no frames, got %v", c.User.Name, c.pending[0])
+	}
+	select {
+	case frame := <-c.frames:
+		c.t.Fatalf("%s expected no frames, got %v", c.User.Name, frame)
+	case <-time.After(d):
+	}
+}
@@ -0,0 +1,111 @@
+package e2e
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mjxoro/sent/server/internal/config"
+	"github.com/mjxoro/sent/server/internal/db/memory"
+	"github.com/mjxoro/sent/server/internal/ratelimit"
+	"github.com/mjxoro/sent/server/internal/server"
+)
+
+// withRateLimits replaces the harness's rate limits
+func withRateLimits(limits config.RateLimitConfig) func(*server.Dependencies) {
+	return func(deps *server.Dependencies) {
+		deps.RateLimiter = ratelimit.New(memory.NewRateLimiter(), limits)
+	}
+}
+
+func TestWSRateLimitsFrames(t *testing.T) {
+	h := NewHarness(t, withRateLimits(config.RateLimitConfig{
+		Frames: map[string]config.RateLimit{
+			"message": {Burst: 2, Period: time.Minute},
+			"typing":  {Burst: 1, Period: time.Minute},
+		},
+	}))
+	aliceUser := h.CreateUser("Alice")
+	room := h.CreateRoom("general", aliceUser)
+
+	alice := h.Dial(aliceUser)
+	alice.Subscribe(room.ID)
+
+	for i := 0; i < 2; i++ {
+		alice.Send(map[string]any{"type": "message", "room_id": room.ID, "content": "hi"})
+		alice.Expect("message_sent")
+	}
+
+	alice.Send(map[string]any{"type": "message", "room_id": room.ID, "content": "hi", "request_id": "r3", "client_msg_id": "c3"})
+	limited := alice.Expect("rate_limited")
+	if limited.String("request_id") != "r3" || limited.String("client_msg_id") != "c3" {
+		t.Fatalf("unexpected rate_limited frame: %v", limited)
+	}
+	if retryAfter, _ := limited["retry_after_ms"].(float64); retryAfter <= 0 || retryAfter > 30000 {
+		t.Fatalf("expected a retry delay of at most 30s, got %v", limited["retry_after_ms"])
+	}
+
+	// Dropped typing frames are only answered if they ask for a response
+	for i := 0; i < 2; i++ {
+		alice.Send(map[string]any{"type": "typing", "room_id": room.ID, "data": map[string]any{"is_typing": true}})
+	}
+	alice.ExpectNone(quiet)
+	alice.Send(map[string]any{"type": "typing", "room_id": room.ID, "request_id": "t3", "data": map[string]any{"is_typing": true}})
+	if limited := alice.Expect("rate_limited"); limited.String("request_id") != "t3" {
+		t.Fatalf("unexpected rate_limited frame: %v", limited)
+	}
+}
+
+func TestWSRateLimitsRooms(t *testing.T) {
+	h := NewHarness(t, withRateLimits(config.RateLimitConfig{
+		RoomFrames: map[string]config.RateLimit{"message": {Burst: 2, Period: time.Minute}},
+	}))
+	aliceUser, bobUser, carolUser := h.CreateUser("Alice"), h.CreateUser("Bob"), h.CreateUser("Carol")
+	room := h.CreateRoom("general", aliceUser, bobUser)
+
+	// Frames from outside the room don't count against it
+	carol := h.Dial(carolUser)
+	carol.Send(map[string]any{"type": "message", "room_id": room.ID, "content": "spam"})
+	carol.Expect("message_sent")
+
+	alice, bob := h.Dial(aliceUser), h.Dial(bobUser)
+	alice.Subscribe(room.ID)
+	bob.Subscribe(room.ID)
+	alice.Expect("system")
+
+	alice.Send(map[string]any{"type": "message", "room_id": room.ID, "content": "one"})
+	alice.Expect("message_sent")
+	bob.Expect("message")
+
+	bob.Send(map[string]any{"type": "message", "room_id": room.ID, "content": "two"})
+	bob.Expect("message_sent")
+	alice.Expect("message")
+
+	bob.Send(map[string]any{"type": "message", "room_id": room.ID, "content": "three"})
+	bob.Expect("rate_limited")
+	alice.ExpectNone(quiet)
+}
+
+func TestRateLimitsEndpoints(t *testing.T) {
+	h := NewHarness(t, withRateLimits(config.RateLimitConfig{
+		Endpoints: map[string]config.RateLimit{"friend_request": {Burst: 1, Period: time.Minute}},
+	}))
+	aliceUser, bobUser, carolUser := h.CreateUser("Alice"), h.CreateUser("Bob"), h.CreateUser("Carol")
+
+	if status := h.Do(aliceUser, http.MethodPost, "/api/friends/requests/"+bobUser.ID, nil, nil); status >= 400 {
+		t.Fatalf("expected the first friend request to succeed, got %d", status)
+	}
+
+	var limited map[string]any
+	if status := h.Do(aliceUser, http.MethodPost, "/api/friends/requests/"+carolUser.ID, nil, &limited); status != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", status)
+	}
+	if retryAfter, _ := limited["retry_after_ms"].(float64); retryAfter <= 0 {
+		t.Fatalf("unexpected response: %v", limited)
+	}
+
+	// Limits are per user
+	if status := h.Do(bobUser, http.MethodPost, "/api/friends/requests/"+carolUser.ID, nil, nil); status >= 400 {
+		t.Fatalf("expected another user's friend request to succeed, got %d", status)
+	}
+}
@@ -0,0 +1,121 @@
+package e2e
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	sentws "github.com/mjxoro/sent/server/pkg/websocket"
+)
+
+func TestWSPresenceCountsDevicesOnce(t *testing.T) {
+	h := NewHarness(t)
+	aliceUser, bobUser := h.CreateUser("Alice"), h.CreateUser("Bob")
+	room := h.CreateRoom("general", aliceUser, bobUser)
+
+	alice := h.Dial(aliceUser)
+	alice.Subscribe(room.ID)
+
+	phone := h.Dial(bobUser)
+	laptop := h.Dial(bobUser)
+	phone.Subscribe(room.ID)
+	if joined := alice.Expect("system"); joined.String("action") != "joined" || joined.String("user_id") != bobUser.ID {
+		t.Fatalf("unexpected joined frame: %v", joined)
+	}
+
+	// Bob's second device joining or leaving doesn't change who is in the room
+	laptop.Subscribe(room.ID)
+	laptop.Send(map[string]any{"type": "unsubscribe", "room_id": room.ID})
+	laptop.Sync()
+	laptop.Subscribe(room.ID)
+	laptop.Close()
+	alice.ExpectNone(quiet)
+
+	phone.Close()
+	if left := alice.Expect("system"); left.String("action") != "left" || left.String("user_id") != bobUser.ID {
+		t.Fatalf("unexpected left frame: %v", left)
+	}
+}
+
+func TestWSSelfSyncRead(t *testing.T) {
+	h := NewHarness(t)
+	aliceUser, bobUser := h.CreateUser("Alice"), h.CreateUser("Bob")
+	room := h.CreateRoom("general", aliceUser, bobUser)
+
+	alice := h.Dial(aliceUser)
+	alice.Subscribe(room.ID)
+
+	// Bob's laptop isn't looking at the room, but still hears that his phone read it
+	phone := h.Dial(bobUser)
+	laptop := h.Events(bobUser)
+	phone.Subscribe(room.ID)
+	alice.Expect("system")
+
+	alice.Send(map[string]any{"type": "message", "room_id": room.ID, "content": "hello"})
+	messageID := alice.Expect("message_sent").String("message_id")
+	phone.Expect("message")
+
+	phone.Send(map[string]any{"type": "read", "room_id": room.ID, "data": map[string]any{"message_ids": []string{messageID}}})
+
+	sync := laptop.Expect("self_sync")
+	ids, _ := sync.Data()["message_ids"].([]any)
+	if sync.String("action") != "read" || sync.String("room_id") != room.ID || len(ids) != 1 || ids[0] != messageID {
+		t.Fatalf("unexpected self_sync frame: %v", sync)
+	}
+	if sync.String("session_id") == "" {
+		t.Fatalf("expected self_sync to name the session it came from: %v", sync)
+	}
+	alice.Expect("read")
+	phone.ExpectNone(quiet)
+}
+
+func TestListSessions(t *testing.T) {
+	h := NewHarness(t)
+	aliceUser, bobUser := h.CreateUser("Alice"), h.CreateUser("Bob")
+
+	header := http.Header{
+		"Authorization": {"Bearer " + h.Token(aliceUser)},
+		"User-Agent":    {"sent-test/1.0"},
+	}
+	phone, status := h.DialWith(aliceUser, url.Values{"device_id": {"phone"}}, header, "")
+	if phone == nil {
+		t.Fatalf("failed to dial as Alice (status %d)", status)
+	}
+	phone.Sync()
+	laptop := h.Events(aliceUser)
+	h.Dial(bobUser).Sync()
+
+	var resp struct {
+		Sessions []sentws.ClientInfo `json:"sessions"`
+	}
+	if status := h.Do(aliceUser, http.MethodGet, "/api/sessions", nil, &resp); status != http.StatusOK {
+		t.Fatalf("expected 200 listing sessions, got %d", status)
+	}
+	if len(resp.Sessions) != 2 {
+		t.Fatalf("expected Alice's 2 sessions, got %+v", resp.Sessions)
+	}
+
+	ws, sse := resp.Sessions[0], resp.Sessions[1]
+	if ws.Transport != "websocket" || ws.DeviceID != "phone" || ws.UserAgent != "sent-test/1.0" || ws.SessionID == "" || ws.ConnectedAt.IsZero() {
+		t.Errorf("unexpected WebSocket session: %+v", ws)
+	}
+	if sse.Transport != "sse" || sse.SessionID != laptop.ConnectionID {
+		t.Errorf("unexpected event stream session: %+v", sse)
+	}
+
+	// Disconnected devices drop out of the list once the server notices they're gone
+	phone.Close()
+	laptop.Close()
+	deadline := time.Now().Add(time.Second)
+	for {
+		h.Do(aliceUser, http.MethodGet, "/api/sessions", nil, &resp)
+		if len(resp.Sessions) == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected no sessions after disconnecting, got %+v", resp.Sessions)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
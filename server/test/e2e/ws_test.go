@@ -0,0 +1,471 @@
+package e2e
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	sentws "github.com/mjxoro/sent/server/pkg/websocket"
+)
+
+// quiet is how long to wait when asserting that no frame arrives
+const quiet = 100 * time.Millisecond
+
+func TestWSRejectsInvalidToken(t *testing.T) {
+	h := NewHarness(t)
+
+	if status := h.DialStatus("not-a-token"); status != http.StatusUnauthorized {
+		t.Fatalf("expected 401 with an invalid token, got %d", status)
+	}
+}
+
+func TestWSInvalidFrame(t *testing.T) {
+	h := NewHarness(t)
+	alice := h.Dial(h.CreateUser("Alice"))
+
+	alice.SendRaw("{not json")
+	frame := alice.Expect("error")
+	if frame["success"] != false || frame.String("message") != "Invalid message format" {
+		t.Fatalf("unexpected error frame: %v", frame)
+	}
+}
+
+func TestWSSubscribeAndUnsubscribe(t *testing.T) {
+	h := NewHarness(t)
+	aliceUser, bobUser := h.CreateUser("Alice"), h.CreateUser("Bob")
+	room := h.CreateRoom("general", aliceUser, bobUser)
+
+	alice := h.Dial(aliceUser)
+	bob := h.Dial(bobUser)
+
+	alice.Subscribe(room.ID)
+	bob.Subscribe(room.ID)
+
+	joined := alice.Expect("system")
+	if joined.String("action") != "joined" || joined.String("user_id") != bobUser.ID || joined.String("room_id") != room.ID {
+		t.Fatalf("unexpected joined frame: %v", joined)
+	}
+	if joined.Data()["user_name"] != "Bob" {
+		t.Fatalf("expected joined frame to carry the user name, got %v", joined.Data())
+	}
+
+	// Subscribers are not told about their own joins
+	bob.ExpectNone(quiet)
+
+	bob.Send(map[string]any{"type": "unsubscribe", "room_id": room.ID})
+	left := alice.Expect("system")
+	if left.String("action") != "left" || left.String("user_id") != bobUser.ID {
+		t.Fatalf("unexpected left frame: %v", left)
+	}
+}
+
+func TestWSDisconnectBroadcastsLeft(t *testing.T) {
+	h := NewHarness(t)
+	aliceUser, bobUser := h.CreateUser("Alice"), h.CreateUser("Bob")
+	room := h.CreateRoom("general", aliceUser, bobUser)
+
+	alice := h.Dial(aliceUser)
+	bob := h.Dial(bobUser)
+	alice.Subscribe(room.ID)
+	bob.Subscribe(room.ID)
+	alice.Expect("system")
+
+	bob.Close()
+
+	left := alice.Expect("system")
+	if left.String("action") != "left" || left.String("user_id") != bobUser.ID || left.String("room_id") != room.ID {
+		t.Fatalf("unexpected left frame: %v", left)
+	}
+}
+
+func TestWSMessage(t *testing.T) {
+	h := NewHarness(t)
+	aliceUser, bobUser := h.CreateUser("Alice"), h.CreateUser("Bob")
+	room := h.CreateRoom("general", aliceUser, bobUser)
+
+	alice := h.Dial(aliceUser)
+	bob := h.Dial(bobUser)
+	alice.Subscribe(room.ID)
+	bob.Subscribe(room.ID)
+	alice.Expect("system")
+
+	alice.Send(map[string]any{"type": "message", "room_id": room.ID, "content": "hello"})
+
+	sent := alice.Expect("message_sent")
+	if sent["success"] != true || sent.String("room_id") != room.ID || sent.String("message_id") == "" {
+		t.Fatalf("unexpected message_sent frame: %v", sent)
+	}
+
+	message := bob.Expect("message")
+	if message.String("id") != sent.String("message_id") {
+		t.Fatalf("expected broadcast id %s, got %v", sent.String("message_id"), message)
+	}
+	if message.String("content") != "hello" || message.String("user_id") != aliceUser.ID || message.String("user_name") != "Alice" {
+		t.Fatalf("unexpected message frame: %v", message)
+	}
+
+	// The sender only receives the confirmation
+	alice.ExpectNone(quiet)
+}
+
+func TestWSMessageRetryIsDeduplicated(t *testing.T) {
+	h := NewHarness(t)
+	aliceUser, bobUser := h.CreateUser("Alice"), h.CreateUser("Bob")
+	room := h.CreateRoom("general", aliceUser, bobUser)
+
+	alice := h.Dial(aliceUser)
+	bob := h.Dial(bobUser)
+	alice.Subscribe(room.ID)
+	bob.Subscribe(room.ID)
+	alice.Expect("system")
+
+	send := map[string]any{"type": "message", "room_id": room.ID, "content": "hello", "client_msg_id": "c1", "request_id": "r1"}
+	alice.Send(send)
+
+	sent := alice.Expect("message_sent")
+	if sent.String("request_id") != "r1" || sent.String("client_msg_id") != "c1" || sent["duplicate"] != nil {
+		t.Fatalf("unexpected message_sent frame: %v", sent)
+	}
+	bob.Expect("message")
+
+	// The client reconnects and retries the send it never saw acknowledged
+	alice.Close()
+	alice = h.Dial(aliceUser)
+	alice.Subscribe(room.ID)
+	alice.Expect("message")
+	bob.Expect("system")
+	bob.Expect("system")
+
+	send["request_id"] = "r2"
+	alice.Send(send)
+
+	retried := alice.Expect("message_sent")
+	if retried["success"] != true || retried.String("request_id") != "r2" || retried["duplicate"] != true ||
+		retried.String("message_id") != sent.String("message_id") {
+		t.Fatalf("unexpected retried message_sent frame: %v", retried)
+	}
+	bob.ExpectNone(quiet)
+
+	messages, err := h.Chat.GetRoomMessages(context.Background(), room.ID, 50, 0)
+	if err != nil {
+		t.Fatalf("GetRoomMessages: %v", err)
+	}
+	if len(messages) != 1 {
+		t.Fatalf("expected 1 stored message, got %d", len(messages))
+	}
+}
+
+func TestWSErrorsEchoRequestID(t *testing.T) {
+	h := NewHarness(t)
+	aliceUser := h.CreateUser("Alice")
+	room := h.CreateRoom("general", aliceUser)
+
+	alice := h.Dial(aliceUser)
+	alice.Send(map[string]any{"type": "message", "room_id": room.ID, "content": "hello", "request_id": "r1"})
+
+	failed := alice.Expect("message_sent")
+	if failed["success"] != false || failed.String("request_id") != "r1" {
+		t.Fatalf("unexpected message_sent frame: %v", failed)
+	}
+
+	// Frames without an acknowledgement only report errors when a response was asked for
+	alice.Send(map[string]any{"type": "typing", "room_id": room.ID})
+	alice.ExpectNone(quiet)
+
+	alice.Send(map[string]any{"type": "nope", "request_id": "r2"})
+	unknown := alice.Expect("error")
+	if unknown.String("request_id") != "r2" || unknown.String("message") != "Unknown frame type" {
+		t.Fatalf("unexpected error frame: %v", unknown)
+	}
+}
+
+func TestWSMessageRequiresSubscription(t *testing.T) {
+	h := NewHarness(t)
+	aliceUser := h.CreateUser("Alice")
+	room := h.CreateRoom("general", aliceUser)
+
+	alice := h.Dial(aliceUser)
+	alice.Send(map[string]any{"type": "message", "room_id": room.ID, "content": "hello"})
+
+	sent := alice.Expect("message_sent")
+	if sent["success"] != false || sent.String("message") != "Not subscribed to room" {
+		t.Fatalf("unexpected message_sent frame: %v", sent)
+	}
+}
+
+func TestWSSubscribeRequiresMembership(t *testing.T) {
+	h := NewHarness(t)
+	aliceUser := h.CreateUser("Alice")
+	bobUser := h.CreateUser("Bob")
+	room := h.CreateRoom("general", aliceUser)
+
+	alice := h.Dial(aliceUser)
+	alice.Subscribe(room.ID)
+
+	bob := h.Dial(bobUser)
+	bob.Send(map[string]any{"type": "subscribe", "room_id": room.ID})
+
+	rejected := bob.Expect("error")
+	if rejected["success"] != false || rejected.String("room_id") != room.ID {
+		t.Fatalf("unexpected error frame: %v", rejected)
+	}
+
+	// Bob was never subscribed, so Alice hears nothing
+	alice.ExpectNone(quiet)
+}
+
+func TestWSSubscribeSendsHistory(t *testing.T) {
+	h := NewHarness(t)
+	aliceUser, bobUser := h.CreateUser("Alice"), h.CreateUser("Bob")
+	room := h.CreateRoom("general", aliceUser, bobUser)
+
+	alice := h.Dial(aliceUser)
+	alice.Subscribe(room.ID)
+	alice.Send(map[string]any{"type": "message", "room_id": room.ID, "content": "first"})
+	alice.Expect("message_sent")
+
+	bob := h.Dial(bobUser)
+	bob.Send(map[string]any{"type": "subscribe", "room_id": room.ID})
+
+	history := bob.Expect("message")
+	if history["history"] != true || history.String("content") != "first" || history.String("user_name") != "Alice" {
+		t.Fatalf("unexpected history frame: %v", history)
+	}
+}
+
+func TestWSTyping(t *testing.T) {
+	h := NewHarness(t)
+	aliceUser, bobUser := h.CreateUser("Alice"), h.CreateUser("Bob")
+	room := h.CreateRoom("general", aliceUser, bobUser)
+
+	alice := h.Dial(aliceUser)
+	phone := h.Dial(aliceUser)
+	bob := h.Dial(bobUser)
+	alice.Subscribe(room.ID)
+	phone.Subscribe(room.ID)
+	bob.Subscribe(room.ID)
+	alice.Expect("system")
+	phone.Expect("system")
+
+	alice.Send(map[string]any{"type": "typing", "room_id": room.ID, "data": map[string]any{"is_typing": true}})
+	alice.Send(map[string]any{"type": "typing", "room_id": room.ID, "data": map[string]any{"is_typing": false}})
+
+	frames := bob.ExpectSequence("typing", "typing")
+	for i, isTyping := range []bool{true, false} {
+		if frames[i].String("user_id") != aliceUser.ID || frames[i].Data()["is_typing"] != isTyping || frames[i].Data()["user_name"] != "Alice" {
+			t.Fatalf("unexpected typing frame %d: %v", i, frames[i])
+		}
+	}
+
+	// Alice's other devices don't hear about her own typing
+	alice.ExpectNone(quiet)
+	phone.ExpectNone(quiet)
+}
+
+func TestWSTypingIsCoalesced(t *testing.T) {
+	h := NewHarness(t)
+	aliceUser, bobUser := h.CreateUser("Alice"), h.CreateUser("Bob")
+	room := h.CreateRoom("general", aliceUser, bobUser)
+
+	alice := h.Dial(aliceUser)
+	bob := h.Dial(bobUser)
+	alice.Subscribe(room.ID)
+	bob.Subscribe(room.ID)
+	alice.Expect("system")
+
+	typing := func(isTyping bool) map[string]any {
+		return map[string]any{"type": "typing", "room_id": room.ID, "data": map[string]any{"is_typing": isTyping}}
+	}
+
+	// Repeats are only rebroadcast every half timeout, and stopping twice is one change
+	for i := 0; i < 3; i++ {
+		alice.Send(typing(true))
+	}
+	alice.Send(typing(false))
+	alice.Send(typing(false))
+	alice.Sync()
+
+	frames := bob.ExpectSequence("typing", "typing")
+	if frames[0].Data()["is_typing"] != true || frames[1].Data()["is_typing"] != false {
+		t.Fatalf("unexpected typing frames: %v", frames)
+	}
+	bob.ExpectNone(quiet)
+}
+
+func TestWSTypingExpires(t *testing.T) {
+	h := NewHarness(t)
+	aliceUser, bobUser := h.CreateUser("Alice"), h.CreateUser("Bob")
+	room := h.CreateRoom("general", aliceUser, bobUser)
+
+	alice := h.Dial(aliceUser)
+	bob := h.Dial(bobUser)
+	alice.Subscribe(room.ID)
+	bob.Subscribe(room.ID)
+	alice.Expect("system")
+
+	alice.Send(map[string]any{"type": "typing", "room_id": room.ID, "data": map[string]any{"is_typing": true}})
+	if frame := bob.Expect("typing"); frame.Data()["is_typing"] != true {
+		t.Fatalf("unexpected typing frame: %v", frame)
+	}
+
+	// The harness times typing out after a second
+	if frame := bob.Expect("typing"); frame.Data()["is_typing"] != false || frame.String("user_id") != aliceUser.ID {
+		t.Fatalf("expected typing to expire, got %v", frame)
+	}
+}
+
+func TestWSTypingStopsOnDisconnect(t *testing.T) {
+	h := NewHarness(t)
+	aliceUser, bobUser, carolUser := h.CreateUser("Alice"), h.CreateUser("Bob"), h.CreateUser("Carol")
+	room := h.CreateRoom("general", aliceUser, bobUser, carolUser)
+
+	alice := h.Dial(aliceUser)
+	bob := h.Dial(bobUser)
+	alice.Subscribe(room.ID)
+	bob.Subscribe(room.ID)
+	alice.Expect("system")
+
+	alice.Send(map[string]any{"type": "typing", "room_id": room.ID, "data": map[string]any{"is_typing": true}})
+	bob.Expect("typing")
+
+	// Subscribers that join later are told who is typing
+	carol := h.Dial(carolUser)
+	carol.Subscribe(room.ID)
+	state := carol.Expect("typing_state")
+	users, _ := state.Data()["users"].([]any)
+	if len(users) != 1 || users[0].(map[string]any)["user_id"] != aliceUser.ID {
+		t.Fatalf("unexpected typing_state frame: %v", state)
+	}
+	bob.Expect("system")
+
+	alice.Close()
+	if frame := bob.Expect("typing"); frame.Data()["is_typing"] != false {
+		t.Fatalf("expected typing to stop on disconnect, got %v", frame)
+	}
+	bob.Expect("system")
+}
+
+func TestWSRead(t *testing.T) {
+	h := NewHarness(t)
+	aliceUser, bobUser := h.CreateUser("Alice"), h.CreateUser("Bob")
+	room := h.CreateRoom("general", aliceUser, bobUser)
+
+	alice := h.Dial(aliceUser)
+	bob := h.Dial(bobUser)
+	alice.Subscribe(room.ID)
+	bob.Subscribe(room.ID)
+	alice.Expect("system")
+
+	alice.Send(map[string]any{"type": "message", "room_id": room.ID, "content": "hello"})
+	messageID := alice.Expect("message_sent").String("message_id")
+	bob.Expect("message")
+
+	bob.Send(map[string]any{"type": "read", "room_id": room.ID, "data": map[string]any{"message_ids": []string{messageID}}})
+
+	read := alice.Expect("read")
+	ids, _ := read["message_ids"].([]any)
+	if read.String("user_id") != bobUser.ID || len(ids) != 1 || ids[0] != messageID {
+		t.Fatalf("unexpected read frame: %v", read)
+	}
+	bob.ExpectNone(quiet)
+
+	unread, err := h.Chat.GetUnreadCount(t.Context(), room.ID, bobUser.ID)
+	if err != nil || unread != 0 {
+		t.Fatalf("expected the message to be marked read, got %d unread (%v)", unread, err)
+	}
+}
+
+func TestWSServerGoingAway(t *testing.T) {
+	h := NewHarness(t)
+	aliceUser, bobUser := h.CreateUser("Alice"), h.CreateUser("Bob")
+	room := h.CreateRoom("general", aliceUser, bobUser)
+
+	alice := h.Dial(aliceUser)
+	bob := h.Dial(bobUser)
+	alice.Subscribe(room.ID)
+	bob.Subscribe(room.ID)
+	alice.Expect("system")
+
+	if err := h.Hub.Stop(t.Context()); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+
+	for _, client := range []*Client{alice, bob} {
+		frame := client.Expect("server_going_away")
+		delay, _ := frame["reconnect_after_ms"].(float64)
+		if delay < 1000 || delay > 5000 {
+			t.Fatalf("expected a reconnect hint between 1s and 5s, got %v", frame["reconnect_after_ms"])
+		}
+		client.ExpectClose(websocket.CloseGoingAway)
+	}
+
+	// Connections made after the hub stopped are closed straight away
+	carol := h.Dial(h.CreateUser("Carol"))
+	carol.ExpectClose(websocket.CloseGoingAway)
+}
+
+func TestWSOversizedFrameClosesConnection(t *testing.T) {
+	h := NewHarness(t)
+	alice := h.Dial(h.CreateUser("Alice"))
+
+	alice.conn.EnableWriteCompression(false)
+	alice.SendRaw(`{"type":"message","content":"` + strings.Repeat("a", 64*1024) + `"}`)
+	alice.ExpectClose(websocket.CloseMessageTooBig)
+}
+
+func TestWSOversizedCompressedFrameClosesConnection(t *testing.T) {
+	h := NewHarness(t)
+	alice := h.Dial(h.CreateUser("Alice"))
+
+	if !alice.Compressed {
+		t.Fatal("compression was not negotiated")
+	}
+
+	// Compresses to well under the read limit, but inflates to far over it
+	alice.conn.EnableWriteCompression(true)
+	alice.SendRaw(`{"type":"message","content":"` + strings.Repeat("a", 1024*1024) + `"}`)
+	alice.ExpectClose(websocket.CloseMessageTooBig)
+}
+
+func TestWSSubprotocols(t *testing.T) {
+	for _, subprotocol := range []string{"", sentws.SubprotocolJSON, sentws.SubprotocolMsgpack} {
+		t.Run("subprotocol="+subprotocol, func(t *testing.T) {
+			h := NewHarness(t)
+			aliceUser, bobUser := h.CreateUser("Alice"), h.CreateUser("Bob")
+			room := h.CreateRoom("general", aliceUser, bobUser)
+			for i := 0; i < 3; i++ {
+				if _, err := h.Chat.SendMessage(context.Background(), room.ID, bobUser.ID, strings.Repeat("history ", 20)); err != nil {
+					t.Fatalf("SendMessage: %v", err)
+				}
+			}
+
+			alice := h.DialSubprotocol(aliceUser, subprotocol)
+			if got := alice.conn.Subprotocol(); got != subprotocol {
+				t.Fatalf("negotiated subprotocol %q, want %q", got, subprotocol)
+			}
+			bob := h.Dial(bobUser)
+			bob.Subscribe(room.ID)
+			bob.ExpectSequence("message", "message", "message")
+			alice.Subscribe(room.ID)
+			bob.Expect("system")
+
+			for i := 0; i < 3; i++ {
+				if history := alice.Expect("message"); history["history"] != true {
+					t.Fatalf("unexpected history frame: %v", history)
+				}
+			}
+
+			alice.Send(map[string]any{"type": "message", "room_id": room.ID, "content": "hello", "request_id": "r1"})
+			sent := alice.Expect("message_sent")
+			if sent["success"] != true || sent.String("request_id") != "r1" {
+				t.Fatalf("unexpected message_sent frame: %v", sent)
+			}
+			if message := bob.Expect("message"); message.String("content") != "hello" {
+				t.Fatalf("unexpected message frame: %v", message)
+			}
+		})
+	}
+}